@@ -0,0 +1,75 @@
+package app
+
+import (
+	"air-sync/models"
+	"air-sync/services"
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// CleanupApplication runs the cleanup job once from the command line and
+// prints its report, only reporting what would be deleted on a dry run.
+type CleanupApplication struct {
+	DatabaseDriver services.DatabaseDriver
+	DatabaseDSN    string
+	Mongo          MongoOptions
+
+	Storage     StorageLocation
+	GracePeriod time.Duration
+	DryRun      bool
+}
+
+var _ Application = (*CleanupApplication)(nil)
+
+func (a *CleanupApplication) Start(ctx context.Context) error {
+	repos, err := newRepositoryService(ctx, a.DatabaseDriver, a.DatabaseDSN, a.Mongo)
+	if err != nil {
+		return err
+	}
+	if v, ok := repos.(services.Initializer); ok {
+		if err := v.Initialize(); err != nil {
+			return err
+		}
+		defer v.Deinitialize()
+	}
+	// Dry runs leave the schema as it is
+	if a.DryRun {
+		if err := checkRepositoryService(ctx, repos); err != nil {
+			return err
+		}
+	} else if err := migrateRepositoryService(ctx, repos, false); err != nil {
+		return err
+	}
+
+	storageService := newStorageService(ctx, a.Storage)
+	if err := storageService.Initialize(); err != nil {
+		return err
+	}
+	defer storageService.Deinitialize()
+
+	// Nothing is scheduled, and the events of the deleted sessions are left
+	// in the outbox for the servers to relay
+	cronJobService := services.NewCronJobService(ctx, services.CronJobOptions{
+		SessionRepository:    repos.SessionRepository(),
+		AttachmentRepository: repos.AttachmentRepository(),
+		RunRepository:        repos.CronRunRepository(),
+		Storage:              storageService.Storage(),
+		GracePeriod:          a.GracePeriod,
+	})
+	var report services.CronJobReport
+	if a.DryRun {
+		report, err = cronJobService.DryRunJob(ctx, services.CronJobCleanup)
+	} else {
+		_, report, err = cronJobService.RunJob(ctx, services.CronJobCleanup, models.CronTriggerCommand)
+	}
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	}
+	return err
+}
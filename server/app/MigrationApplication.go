@@ -0,0 +1,117 @@
+package app
+
+import (
+	"air-sync/repositories"
+	"air-sync/services"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type MigrationCommand string
+
+const (
+	MigrationCommandStatus MigrationCommand = "status"
+	MigrationCommandUp     MigrationCommand = "up"
+	MigrationCommandDown   MigrationCommand = "down"
+)
+
+// MigrationNoTarget lets up apply every pending migration, and down revert
+// only the last applied one.
+const MigrationNoTarget = -1
+
+type MigrationApplication struct {
+	DatabaseDriver services.DatabaseDriver
+	DatabaseDSN    string
+	Mongo          MongoOptions
+
+	Command MigrationCommand
+	Target  int
+	Output  io.Writer
+}
+
+var _ Application = (*MigrationApplication)(nil)
+
+func (a *MigrationApplication) Start(ctx context.Context) error {
+	repos, err := newRepositoryService(ctx, a.DatabaseDriver, a.DatabaseDSN, a.Mongo)
+	if err != nil {
+		return err
+	}
+	if v, ok := repos.(services.Initializer); ok {
+		if err := v.Initialize(); err != nil {
+			return err
+		}
+		defer v.Deinitialize()
+	}
+	migrator := repos.Migrator()
+
+	switch a.Command {
+	case MigrationCommandUp:
+		migrations, err := migrator.Up(ctx, a.Target)
+		log.Infof("Applied %d migration(s)", len(migrations))
+		return err
+	case MigrationCommandDown:
+		target := a.Target
+		if target == MigrationNoTarget {
+			res, err := a.previousVersion(ctx, migrator)
+			if err != nil {
+				return err
+			}
+			target = res
+		}
+		migrations, err := migrator.Down(ctx, target)
+		log.Infof("Reverted %d migration(s)", len(migrations))
+		return err
+	default:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		return a.printStatus(statuses)
+	}
+}
+
+// previousVersion returns the version the database is at without its last
+// applied migration.
+func (a *MigrationApplication) previousVersion(ctx context.Context, migrator *repositories.Migrator) (int, error) {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	applied := make([]int, 0, len(statuses))
+	for _, status := range statuses {
+		if status.AppliedAt != 0 {
+			applied = append(applied, status.Version)
+		}
+	}
+	if len(applied) < 2 {
+		return 0, nil
+	}
+	return applied[len(applied)-2], nil
+}
+
+func (a *MigrationApplication) printStatus(statuses []repositories.MigrationStatus) error {
+	out := a.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATUS\tAPPLIED AT\tDESCRIPTION")
+	for _, status := range statuses {
+		state, appliedAt := "pending", "-"
+		if status.AppliedAt != 0 {
+			state = "applied"
+			appliedAt = time.Unix(0, status.AppliedAt*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+		}
+		if status.Unknown {
+			state = "unknown"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, state, appliedAt, status.Description)
+	}
+	return w.Flush()
+}
@@ -2,12 +2,14 @@ package app
 
 import (
 	"air-sync/handlers"
+	"air-sync/models/events"
 	"air-sync/services"
 	"context"
 	"net/url"
 	"time"
 
 	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
 )
 
 type MongoOptions struct {
@@ -19,18 +21,42 @@ type MongoOptions struct {
 type MonolithicApplication struct {
 	Addr string
 
-	Mongo MongoOptions
+	DatabaseDriver services.DatabaseDriver
+	DatabaseDSN    string
+	Mongo          MongoOptions
+	// QueryTimeout bounds each session and attachment query, so a slow
+	// database can't hold the requests forever
+	QueryTimeout time.Duration
 
 	Redis        services.RedisOptions
+	RedisStreams services.RedisStreamsOptions
 	GooglePubSub services.GooglePubSubOptions
+	Nats         services.NatsOptions
+	Postgres     services.PostgresEventOptions
 	EventService string
-
-	StorageMode string
-	BucketName  string
-	UploadsDir  string
+	// EventInstanceID and EventRetention configure the MongoDB event broker,
+	// which uses the Mongo connection options
+	EventInstanceID string
+	EventRetention  time.Duration
+	// EventSigningKeys signs the events sent through Redis, Redis streams and
+	// Pub/Sub
+	EventSigningKeys *events.Keyring
+
+	StorageMode        string
+	StorageCompression string
+	BucketName         string
+	UploadsDir         string
 
 	CronEnvironment string
 	GracePeriod     time.Duration
+	// CleanupSchedule runs the cleanup within the server when set, each run
+	// on the instance taking the CronLease
+	CleanupSchedule string
+	// ReconcileSchedule runs the storage reconciliation likewise, repairing
+	// the drift it finds with ReconcileRepair
+	ReconcileSchedule string
+	ReconcileRepair   bool
+	CronLease         services.CronLease
 
 	EnableCORS bool
 }
@@ -38,43 +64,118 @@ type MonolithicApplication struct {
 var _ Application = (*MonolithicApplication)(nil)
 
 func (a *MonolithicApplication) Start(ctx context.Context) error {
-	repos := services.NewMongoRepositoryService(ctx, services.MongoRepositoryOptions{
-		URL:      a.Mongo.URL,
-		Database: a.Mongo.Database,
-		Recreate: a.Mongo.Recreate,
-	})
-	if err := repos.Initialize(); err != nil {
+	repos, err := newRepositoryService(ctx, a.DatabaseDriver, a.DatabaseDSN, a.Mongo)
+	if err != nil {
+		return err
+	}
+	if v, ok := repos.(services.Initializer); ok {
+		if err := v.Initialize(); err != nil {
+			return err
+		}
+		defer v.Deinitialize()
+	}
+	// Recreating is a Mongo option, so it never drops the SQL databases
+	recreate := a.Mongo.Recreate
+	if recreate && a.DatabaseDriver != services.DatabaseDriverMongo {
+		log.Warnf("Ignoring MONGODB_RECREATE with the %s database driver", a.DatabaseDriver)
+		recreate = false
+	}
+	if err := migrateRepositoryService(ctx, repos, recreate); err != nil {
 		return err
 	}
-	defer repos.Deinitialize()
+	repos = services.WithQueryTimeout(repos, a.QueryTimeout)
 
 	storageService := services.NewStorageService(ctx, services.StorageOptions{
 		StorageMode: services.StorageMode(a.StorageMode),
 		BucketName:  a.BucketName,
 		UploadsDir:  a.UploadsDir,
+		Compression: a.StorageCompression,
 	})
 	if err := storageService.Initialize(); err != nil {
 		return err
 	}
 	defer storageService.Deinitialize()
 
+	storageCleanupService := services.NewStorageCleanupService(storageService.Storage())
+	if err := storageCleanupService.Initialize(); err != nil {
+		return err
+	}
+	defer storageCleanupService.Deinitialize()
+
 	eventBroker := services.NewEventBrokerService(ctx, services.EventBrokerOptions{
 		Service:      services.EventService(a.EventService),
 		Redis:        a.Redis,
+		RedisStreams: a.RedisStreams,
 		GooglePubSub: a.GooglePubSub,
+		Nats:         a.Nats,
+		Postgres:     a.Postgres,
+		SigningKeys:  a.EventSigningKeys,
+		Mongo: services.MongoEventOptions{
+			URL:        a.Mongo.URL,
+			Database:   a.Mongo.Database,
+			InstanceID: a.EventInstanceID,
+			Retention:  a.EventRetention,
+		},
 	})
 	if err := eventBroker.Initialize(); err != nil {
 		return err
 	}
 	defer eventBroker.Deinitialize()
 
-	cronJobService := services.NewCronJobService(services.CronJobOptions{
+	outboxRelayService := services.NewOutboxRelayService(ctx, services.OutboxRelayOptions{
+		Repository: repos.OutboxRepository(),
+		Publisher:  eventBroker,
+	})
+	if err := outboxRelayService.Initialize(); err != nil {
+		return err
+	}
+	defer outboxRelayService.Deinitialize()
+
+	cronLease := repos.LeaseRepository()
+	switch a.CronLease {
+	case services.CronLeaseNone:
+		cronLease = nil
+	case services.CronLeaseRedis:
+		// Only connected to when something is scheduled
+		if a.CleanupSchedule != "" || a.ReconcileSchedule != "" {
+			redisLease := services.NewRedisLeaseService(ctx, a.Redis)
+			if err := redisLease.Initialize(); err != nil {
+				return err
+			}
+			defer redisLease.Deinitialize()
+			cronLease = redisLease
+		}
+	}
+
+	cronJobService := services.NewCronJobService(ctx, services.CronJobOptions{
 		SessionRepository:    repos.SessionRepository(),
 		AttachmentRepository: repos.AttachmentRepository(),
-		Publisher:            eventBroker.Publisher(),
+		RunRepository:        repos.CronRunRepository(),
+		Relay:                outboxRelayService,
 		Storage:              storageService.Storage(),
 		GracePeriod:          a.GracePeriod,
+		CleanupSchedule:      a.CleanupSchedule,
+		Scheduler: services.CronSchedulerOptions{
+			Lease:  cronLease,
+			Holder: a.EventInstanceID,
+		},
+	})
+	storageReconcileService := services.NewStorageReconcileService(services.StorageReconcileOptions{
+		Storage:              storageService.Storage(),
+		AttachmentRepository: repos.AttachmentRepository(),
+		Repair:               a.ReconcileRepair,
 	})
+	err = cronJobService.Register(services.CronJob{
+		Name:     services.CronJobReconcileStorage,
+		Schedule: a.ReconcileSchedule,
+		Timeout:  time.Hour,
+		Interval: time.Hour,
+		Run:      storageReconcileService.RunJob,
+		DryRun:   storageReconcileService.DryRunJob,
+	})
+	if err != nil {
+		return err
+	}
 	if err := cronJobService.Initialize(); err != nil {
 		return err
 	}
@@ -83,11 +184,19 @@ func (a *MonolithicApplication) Start(ctx context.Context) error {
 	router := mux.NewRouter()
 
 	handlers.NewApiHandler(
+		handlers.NewSessionArchiveHandler(
+			repos.SessionRepository(),
+			repos.AttachmentRepository(),
+			storageService.Storage(),
+		),
 		handlers.NewSessionRestHandler(
 			repos.SessionRepository(),
 			eventBroker.Publisher(),
+			storageCleanupService,
+			outboxRelayService,
 		),
 		handlers.QrRestHandler(0),
+		handlers.NewHealthHandler(eventBroker),
 	).RegisterRoutes(router)
 
 	handlers.NewWebSocketHandler(handlers.WebSocketOptions{
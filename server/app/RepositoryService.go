@@ -0,0 +1,66 @@
+package app
+
+import (
+	"air-sync/services"
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func newRepositoryService(ctx context.Context, driver services.DatabaseDriver, dsn string, mongo MongoOptions) (services.RepositoryService, error) {
+	switch driver {
+	case services.DatabaseDriverMemory:
+		return services.NewMemoryRepositoryService(), nil
+	case services.DatabaseDriverSqlite, services.DatabaseDriverPostgres:
+		return services.NewGormRepositoryService(
+			ctx,
+			services.NewGormDialector(driver, dsn),
+		), nil
+	case services.DatabaseDriverMongo:
+		return services.NewMongoRepositoryService(ctx, services.MongoRepositoryOptions{
+			URL:      mongo.URL,
+			Database: mongo.Database,
+		}), nil
+	}
+	return nil, fmt.Errorf("Unknown database driver: %q", driver)
+}
+
+// migrateRepositoryService brings the schema up to date. Recreating reverts
+// every applied migration first, which drops all the records.
+func migrateRepositoryService(ctx context.Context, repos services.RepositoryService, recreate bool) error {
+	migrator := repos.Migrator()
+	if recreate {
+		log.Warn("Recreating the database schema")
+		if _, err := migrator.Down(ctx, 0); err != nil {
+			return err
+		}
+	}
+	migrations, err := migrator.Up(ctx, 0)
+	if err != nil {
+		return err
+	}
+	if len(migrations) > 0 {
+		log.Infof("Applied %d migration(s)", len(migrations))
+	}
+	return nil
+}
+
+// checkRepositoryService fails when the schema has pending migrations, for
+// the commands which mustn't change the database.
+func checkRepositoryService(ctx context.Context, repos services.RepositoryService) error {
+	statuses, err := repos.Migrator().Status(ctx)
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, status := range statuses {
+		if status.AppliedAt == 0 {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("The database schema has %d pending migration(s), run air-sync migrate up first", pending)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"air-sync/services"
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type StorageLocation struct {
+	StorageMode string
+	BucketName  string
+	UploadsDir  string
+}
+
+type StorageMigrationApplication struct {
+	DatabaseDriver services.DatabaseDriver
+	DatabaseDSN    string
+	Mongo          MongoOptions
+
+	From StorageLocation
+	To   StorageLocation
+
+	Workers        int
+	CheckpointFile string
+	Verify         bool
+}
+
+var _ Application = (*StorageMigrationApplication)(nil)
+
+func (a *StorageMigrationApplication) Start(ctx context.Context) error {
+	repos, err := newRepositoryService(ctx, a.DatabaseDriver, a.DatabaseDSN, a.Mongo)
+	if err != nil {
+		return err
+	}
+	if v, ok := repos.(services.Initializer); ok {
+		if err := v.Initialize(); err != nil {
+			return err
+		}
+		defer v.Deinitialize()
+	}
+	if err := migrateRepositoryService(ctx, repos, false); err != nil {
+		return err
+	}
+
+	source := newStorageService(ctx, a.From)
+	if err := source.Initialize(); err != nil {
+		return err
+	}
+	defer source.Deinitialize()
+
+	destination := newStorageService(ctx, a.To)
+	if err := destination.Initialize(); err != nil {
+		return err
+	}
+	defer destination.Deinitialize()
+
+	log.Infof("Migrating storage from %s to %s", a.From.StorageMode, a.To.StorageMode)
+	report, err := services.NewStorageMigrationService(services.StorageMigrationOptions{
+		Source:               source.Storage(),
+		Destination:          destination.Storage(),
+		AttachmentRepository: repos.AttachmentRepository(),
+		Workers:              a.Workers,
+		CheckpointFile:       a.CheckpointFile,
+		Route:                a.From.String() + " -> " + a.To.String(),
+		Verify:               a.Verify,
+	}).Run(ctx)
+
+	log.WithFields(log.Fields{
+		"total":    report.Total,
+		"copied":   report.Copied,
+		"skipped":  report.Skipped,
+		"missing":  len(report.Missing),
+		"orphaned": len(report.Orphaned),
+		"failed":   len(report.Failed),
+	}).Info("Storage migration finished")
+	for _, id := range report.Missing {
+		log.WithField("attachment_id", id).Warn("Attachment has no object in source storage")
+	}
+	for _, name := range report.Orphaned {
+		log.WithField("object", name).Warn("Object in source storage has no attachment")
+	}
+	if err != nil {
+		return err
+	}
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("Failed to migrate %d object(s)", len(report.Failed))
+	}
+	return nil
+}
+
+// String names the storage the location points to.
+func (l StorageLocation) String() string {
+	switch services.StorageMode(l.StorageMode) {
+	case services.StorageModeLocal:
+		return fmt.Sprintf("%s:%s", l.StorageMode, l.UploadsDir)
+	case services.StorageModeCloudStorage:
+		return fmt.Sprintf("%s:%s", l.StorageMode, l.BucketName)
+	case services.StorageModeCache:
+		return fmt.Sprintf("%s:%s,%s", l.StorageMode, l.UploadsDir, l.BucketName)
+	}
+	return l.StorageMode
+}
+
+func newStorageService(ctx context.Context, loc StorageLocation) *services.StorageService {
+	return services.NewStorageService(ctx, services.StorageOptions{
+		StorageMode: services.StorageMode(loc.StorageMode),
+		BucketName:  loc.BucketName,
+		UploadsDir:  loc.UploadsDir,
+	})
+}
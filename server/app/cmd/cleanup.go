@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"air-sync/app"
+	"air-sync/util"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	cleanupDryRun      bool
+	cleanupGracePeriod string
+)
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Delete the sessions older than the grace period and the orphan attachments",
+	Long: `Delete the sessions older than the grace period and the attachments
+		no message references anymore, like the cleanup cron job, and print
+		the report of the run. With --dry-run, only report what would be
+		deleted without changing anything, failing while the database has
+		pending migrations.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := signalContext()
+
+		databaseDriver, err := envDatabaseDriver()
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		mongo, err := envMongoOptions()
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		// Environment files are loaded after flags are declared, so the
+		// grace period falls back to the environment here instead
+		if cleanupGracePeriod == "" {
+			cleanupGracePeriod = util.GetEnvDefault("CLEANUP_GRACE_PERIOD", "24h")
+		}
+		gracePeriod, err := util.ParseTimeDuration(cleanupGracePeriod)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		err = (&app.CleanupApplication{
+			DatabaseDriver: databaseDriver,
+			DatabaseDSN:    util.GetEnvDefault("DATABASE_DSN", ""),
+			Mongo:          mongo,
+			Storage: app.StorageLocation{
+				StorageMode: util.GetEnvDefault("STORAGE_MODE", "local"),
+				BucketName:  util.GetEnvDefault("BUCKET_NAME", "airsync"),
+				UploadsDir:  util.GetEnvDefault("UPLOADS_DIR", "uploads"),
+			},
+			GracePeriod: gracePeriod,
+			DryRun:      cleanupDryRun,
+		}).Start(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	flags := cleanupCmd.Flags()
+	flags.BoolVar(&cleanupDryRun, "dry-run", false, "Only report what would be deleted")
+	flags.StringVar(&cleanupGracePeriod, "grace-period", "", "Age of the sessions to delete (default $CLEANUP_GRACE_PERIOD or 24h)")
+
+	rootCmd.AddCommand(cleanupCmd)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"air-sync/app"
+	"air-sync/util"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrationTarget int
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage the database schema migrations",
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List the known migrations and whether they are applied",
+	Run:   runMigration(app.MigrationCommandStatus),
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply the pending migrations",
+	Run:   runMigration(app.MigrationCommandUp),
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Revert the last applied migration",
+	Long: `Revert the last applied migration, or every migration newer than
+		the version given with --to. Reverting version 1 drops all records.`,
+	Run: runMigration(app.MigrationCommandDown),
+}
+
+func runMigration(command app.MigrationCommand) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		ctx := signalContext()
+
+		databaseDriver, err := envDatabaseDriver()
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		mongo, err := envMongoOptions()
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		err = (&app.MigrationApplication{
+			DatabaseDriver: databaseDriver,
+			DatabaseDSN:    util.GetEnvDefault("DATABASE_DSN", ""),
+			Mongo:          mongo,
+			Command:        command,
+			Target:         migrationTarget,
+		}).Start(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func init() {
+	migrateUpCmd.Flags().IntVar(&migrationTarget, "to", app.MigrationNoTarget, "Version to migrate up to (default latest)")
+	migrateDownCmd.Flags().IntVar(&migrationTarget, "to", app.MigrationNoTarget, "Version to migrate down to (default previous)")
+
+	migrateCmd.AddCommand(migrateStatusCmd, migrateUpCmd, migrateDownCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
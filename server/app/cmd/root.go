@@ -2,12 +2,14 @@ package cmd
 
 import (
 	"air-sync/app"
+	"air-sync/models/events"
 	"air-sync/services"
 	"air-sync/util"
 	"air-sync/util/gcp"
 	"context"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
@@ -25,17 +27,21 @@ var rootCmd = &cobra.Command{
 	Long: `Small and lightweight, Air Sync is aimed to send various messages
 		from one device to the other securely over the internet.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		ch := make(chan os.Signal, 1)
-		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+		ctx := signalContext()
 
-		ctx, cancel := context.WithCancel(context.Background())
-		go func() {
-			sig := <-ch
-			log.Infof("Received signal: %+v", sig)
-			cancel()
-		}()
+		databaseDriver, err := envDatabaseDriver()
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		mongo, err := envMongoOptions()
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
 
-		mongoUrl, err := util.EnvMongoUrl()
+		redis, err := envRedisOptions()
 		if err != nil {
 			log.Fatal(err)
 			return
@@ -47,29 +53,92 @@ var rootCmd = &cobra.Command{
 			return
 		}
 
+		queryTimeout, err := util.ParseTimeDuration(util.GetEnvDefault("QUERY_TIMEOUT", "30s"))
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		eventRetention, err := util.ParseTimeDuration(util.GetEnvDefault("EVENT_RETENTION", "1h"))
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		redisStreamMaxLen, err := strconv.ParseInt(util.GetEnvDefault("REDIS_STREAM_MAXLEN", "0"), 10, 64)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		pubSubExpiration, err := util.ParseTimeDuration(util.GetEnvDefault("GOOGLE_PUBSUB_SUBSCRIPTION_EXPIRATION", "24h"))
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		eventInstanceID := util.GetEnvDefault("EVENT_INSTANCE_ID", "")
+
+		eventSigningKeys, err := events.ParseKeyring(util.GetEnvDefault("EVENT_SIGNING_KEYS", ""))
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		cronLease, err := services.ParseCronLease(util.GetEnvDefault("CRON_LEASE", string(services.CronLeaseRepository)))
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
 		err = (&app.MonolithicApplication{
-			Addr: ":" + util.GetEnvDefault("PORT", "8080"),
-			Mongo: app.MongoOptions{
-				URL:      mongoUrl,
-				Database: util.GetEnvDefault("MONGODB_DATABASE", "airsync"),
-				Recreate: util.GetEnvBoolDefault("MONGODB_RECREATE", false),
-			},
-			StorageMode: util.GetEnvDefault("STORAGE_MODE", "local"),
-			BucketName:  util.GetEnvDefault("BUCKET_NAME", "airsync"),
-			UploadsDir:  util.GetEnvDefault("UPLOADS_DIR", "uploads"),
-			Redis: services.RedisOptions{
-				Addr:     util.GetEnvDefault("REDIS_ADDR", "localhost:6379"),
-				Password: util.GetEnvDefault("REDIS_PASSWORD", ""),
+			Addr:               ":" + util.GetEnvDefault("PORT", "8080"),
+			DatabaseDriver:     databaseDriver,
+			DatabaseDSN:        util.GetEnvDefault("DATABASE_DSN", ""),
+			Mongo:              mongo,
+			QueryTimeout:       queryTimeout,
+			StorageMode:        util.GetEnvDefault("STORAGE_MODE", "local"),
+			StorageCompression: util.GetEnvDefault("STORAGE_COMPRESSION", ""),
+			BucketName:         util.GetEnvDefault("BUCKET_NAME", "airsync"),
+			UploadsDir:         util.GetEnvDefault("UPLOADS_DIR", "uploads"),
+			Redis:              redis,
+			RedisStreams: services.RedisStreamsOptions{
+				Stream:     util.GetEnvDefault("REDIS_STREAM", services.DefaultRedisStream),
+				MaxLen:     redisStreamMaxLen,
+				InstanceID: eventInstanceID,
 			},
 			GooglePubSub: services.GooglePubSubOptions{
-				ProjectID:      gcp.EnvProjectID(),
-				TopicID:        gcp.EnvPubSubTopicID(),
-				SubscriptionID: gcp.EnvPubSubSubscriptionID(),
+				ProjectID:              gcp.EnvProjectID(),
+				TopicID:                gcp.EnvPubSubTopicID(),
+				SubscriptionPrefix:     gcp.EnvPubSubSubscriptionPrefix(),
+				SubscriptionExpiration: pubSubExpiration,
+			},
+			Nats: services.NatsOptions{
+				URL:             util.GetEnvDefault("NATS_URL", "nats://localhost:4222"),
+				CredentialsFile: util.GetEnvDefault("NATS_CREDENTIALS", ""),
+				TLSCAFile:       util.GetEnvDefault("NATS_TLS_CA", ""),
+				TLSCertFile:     util.GetEnvDefault("NATS_TLS_CERT", ""),
+				TLSKeyFile:      util.GetEnvDefault("NATS_TLS_KEY", ""),
+				JetStream:       util.GetEnvBoolDefault("NATS_JETSTREAM", false),
+				Stream:          util.GetEnvDefault("NATS_STREAM", services.DefaultNatsStream),
+				Durable:         util.GetEnvDefault("NATS_DURABLE", ""),
+				MaxAge:          eventRetention,
 			},
-			EventService:    util.GetEnvDefault("EVENT_SERVICE", ""),
-			CronEnvironment: util.GetEnvDefault("CRON_ENVIRONMENT", ""),
-			GracePeriod:     gracePeriod,
-			EnableCORS:      enableCORS,
+			Postgres: services.PostgresEventOptions{
+				// The SQL repositories' database is used unless told otherwise
+				DSN: util.GetEnvDefault("EVENT_POSTGRES_DSN", util.GetEnvDefault("DATABASE_DSN", "")),
+			},
+			EventService:      util.GetEnvDefault("EVENT_SERVICE", ""),
+			EventInstanceID:   eventInstanceID,
+			EventRetention:    eventRetention,
+			EventSigningKeys:  eventSigningKeys,
+			CronEnvironment:   util.GetEnvDefault("CRON_ENVIRONMENT", ""),
+			GracePeriod:       gracePeriod,
+			CleanupSchedule:   util.GetEnvDefault("CLEANUP_SCHEDULE", ""),
+			ReconcileSchedule: util.GetEnvDefault("STORAGE_RECONCILE_SCHEDULE", ""),
+			ReconcileRepair:   util.GetEnvBoolDefault("STORAGE_RECONCILE_REPAIR", false),
+			CronLease:         cronLease,
+			EnableCORS:        enableCORS,
 		}).Start(ctx)
 		if err != nil {
 			log.Fatal(err)
@@ -84,3 +153,52 @@ func init() {
 func Execute() error {
 	return rootCmd.Execute()
 }
+
+func signalContext() context.Context {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		sig := <-ch
+		log.Infof("Received signal: %+v", sig)
+		cancel()
+	}()
+	return ctx
+}
+
+func envDatabaseDriver() (services.DatabaseDriver, error) {
+	return services.ParseDatabaseDriver(util.GetEnvDefault("DATABASE_DRIVER", string(services.DatabaseDriverMongo)))
+}
+
+func envMongoOptions() (app.MongoOptions, error) {
+	mongoUrl, err := util.EnvMongoUrl()
+	if err != nil {
+		return app.MongoOptions{}, err
+	}
+	return app.MongoOptions{
+		URL:      mongoUrl,
+		Database: util.GetEnvDefault("MONGODB_DATABASE", "airsync"),
+		Recreate: util.GetEnvBoolDefault("MONGODB_RECREATE", false),
+	}, nil
+}
+
+// envRedisOptions reads REDIS_URL, falling back to REDIS_ADDR and
+// REDIS_PASSWORD for a single server.
+func envRedisOptions() (services.RedisOptions, error) {
+	opts := services.RedisOptions{
+		Mode:     services.RedisModeStandalone,
+		Addrs:    []string{util.GetEnvDefault("REDIS_ADDR", "localhost:6379")},
+		Password: util.GetEnvDefault("REDIS_PASSWORD", ""),
+	}
+	if redisUrl := util.GetEnvDefault("REDIS_URL", ""); redisUrl != "" {
+		var err error
+		if opts, err = services.ParseRedisURL(redisUrl); err != nil {
+			return opts, err
+		}
+	}
+	opts.TLSCAFile = util.GetEnvDefault("REDIS_TLS_CA", "")
+	opts.TLSCertFile = util.GetEnvDefault("REDIS_TLS_CERT", "")
+	opts.TLSKeyFile = util.GetEnvDefault("REDIS_TLS_KEY", "")
+	return opts, nil
+}
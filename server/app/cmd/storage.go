@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"air-sync/app"
+	"air-sync/util"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateFrom       app.StorageLocation
+	migrateTo         app.StorageLocation
+	migrateWorkers    int
+	migrateCheckpoint string
+	migrateNoVerify   bool
+)
+
+var storageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "Manage the attachment storage",
+}
+
+var storageMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Copy every attachment object from one storage backend to another",
+	Long: `Copy every attachment object from one storage backend to another.
+		Objects are copied through parallel workers and verified with checksums.
+		Progress is recorded in the checkpoint file so an interrupted migration
+		can be resumed by running the same command again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := signalContext()
+
+		databaseDriver, err := envDatabaseDriver()
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		mongo, err := envMongoOptions()
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
+		// Environment files are loaded after flags are declared, so the
+		// locations fall back to the environment here instead
+		for _, loc := range []*app.StorageLocation{&migrateFrom, &migrateTo} {
+			if loc.BucketName == "" {
+				loc.BucketName = util.GetEnvDefault("BUCKET_NAME", "airsync")
+			}
+			if loc.UploadsDir == "" {
+				loc.UploadsDir = util.GetEnvDefault("UPLOADS_DIR", "uploads")
+			}
+		}
+
+		err = (&app.StorageMigrationApplication{
+			DatabaseDriver: databaseDriver,
+			DatabaseDSN:    util.GetEnvDefault("DATABASE_DSN", ""),
+			Mongo:          mongo,
+			From:           migrateFrom,
+			To:             migrateTo,
+			Workers:        migrateWorkers,
+			CheckpointFile: migrateCheckpoint,
+			Verify:         !migrateNoVerify,
+		}).Start(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	flags := storageMigrateCmd.Flags()
+	flags.StringVar(&migrateFrom.StorageMode, "from", "", "Source storage mode (local, cloud_storage, cache)")
+	flags.StringVar(&migrateFrom.BucketName, "from-bucket", "", "Source Google Cloud Storage bucket (default $BUCKET_NAME)")
+	flags.StringVar(&migrateFrom.UploadsDir, "from-dir", "", "Source uploads directory (default $UPLOADS_DIR)")
+	flags.StringVar(&migrateTo.StorageMode, "to", "", "Destination storage mode (local, cloud_storage, cache)")
+	flags.StringVar(&migrateTo.BucketName, "to-bucket", "", "Destination Google Cloud Storage bucket (default $BUCKET_NAME)")
+	flags.StringVar(&migrateTo.UploadsDir, "to-dir", "", "Destination uploads directory (default $UPLOADS_DIR)")
+	flags.IntVarP(&migrateWorkers, "workers", "w", 4, "Number of objects copied in parallel")
+	flags.StringVar(&migrateCheckpoint, "checkpoint", "storage-migrate.checkpoint", "File recording the migrated objects, resumed only by a migration between the same storages")
+	flags.BoolVar(&migrateNoVerify, "no-verify", false, "Skip verifying checksums of the copied objects")
+	storageMigrateCmd.MarkFlagRequired("from")
+	storageMigrateCmd.MarkFlagRequired("to")
+
+	storageCmd.AddCommand(storageMigrateCmd)
+	rootCmd.AddCommand(storageCmd)
+}
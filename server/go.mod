@@ -8,7 +8,11 @@ require (
 	github.com/go-redis/redis/v8 v8.0.0-beta.9
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/websocket v1.4.2
+	github.com/jackc/pgx/v4 v4.8.1
 	github.com/joho/godotenv v1.3.0
+	github.com/klauspost/compress v1.11.4
+	github.com/nats-io/nats.go v1.11.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/rs/cors v1.7.0
 	github.com/satori/go.uuid v1.2.0
 	github.com/sirupsen/logrus v1.6.0
@@ -17,10 +21,12 @@ require (
 	github.com/stretchr/testify v1.6.1
 	github.com/vmihailenco/msgpack/v5 v5.0.0-beta.1
 	go.mongodb.org/mongo-driver v1.4.0
-	golang.org/x/sys v0.0.0-20200831180312-196b9ba8737a // indirect
 	golang.org/x/tools v0.0.0-20200902171120-36b1a880d5d1 // indirect
-	google.golang.org/api v0.31.0 // indirect
+	google.golang.org/api v0.31.0
 	google.golang.org/genproto v0.0.0-20200901141002-b3bf27a9dbd1 // indirect
+	google.golang.org/grpc v1.31.1
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776 // indirect
+	gorm.io/driver/postgres v1.0.0
+	gorm.io/driver/sqlite v1.1.1
 	gorm.io/gorm v1.20.0
 )
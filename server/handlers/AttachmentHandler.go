@@ -60,13 +60,15 @@ func (h *AttachmentHandler) UploadAttachment(req *http.Request) (*util.RestRespo
 	filename := header.Filename
 	mime := http.DetectContentType(buf)
 	typ := req.URL.Query().Get("type")
-	attachment, err := h.repo.Create(models.NewCreateAttachment(filename, typ, mime))
+	create := models.NewCreateAttachment(filename, typ, mime)
+	create.Encoding = storages.ContentEncoding(h.storage, mime)
+	attachment, err := h.repo.Create(req.Context(), create)
 	if err != nil {
 		return nil, err
 	}
 
 	logger := util.RequestLogger(req)
-	w, err := h.storage.Write(attachment.ID)
+	w, err := storages.WriteEncoded(req.Context(), h.storage, attachment.ID, attachment.Encoding)
 	if err != nil {
 		return nil, err
 	}
@@ -91,24 +93,36 @@ func (h *AttachmentHandler) UploadAttachment(req *http.Request) (*util.RestRespo
 
 func (h *AttachmentHandler) DownloadAttachment(req *http.Request) (*util.Response, error) {
 	id := mux.Vars(req)["id"]
-	attachment, err := h.repo.Find(id)
+	attachment, err := h.repo.Find(req.Context(), id)
 	if err != nil {
 		if errors.Is(err, repos.ErrAttachmentNotFound) {
 			return ResAttachmentNotFound, nil
 		}
 		return nil, err
 	}
-	exists, err := h.storage.Exists(attachment.ID)
+	exists, err := h.storage.Exists(req.Context(), attachment.ID)
 	if err != nil {
 		return nil, err
 	} else if !exists {
+		// Left for the storage reconciliation to report
+		util.RequestLogger(req).WithField("attachment_id", attachment.ID).Warn("Attachment object missing from storage")
 		return ResAttachmentNotFound, nil
 	}
-	r, err := h.storage.Read(attachment.ID)
+	header := make(http.Header)
+	var r io.ReadCloser
+	if attachment.Encoding != "" && util.AcceptsEncoding(req, attachment.Encoding) {
+		// Serve the stored bytes as they are and let the client decode them
+		r, err = h.storage.Read(req.Context(), attachment.ID)
+		header.Set("Content-Encoding", attachment.Encoding)
+	} else {
+		r, err = storages.ReadDecoded(req.Context(), h.storage, attachment.ID, attachment.Encoding)
+	}
 	if err != nil {
 		return nil, err
 	}
-	header := make(http.Header)
+	if attachment.Encoding != "" {
+		header.Set("Vary", "Accept-Encoding")
+	}
 	header.Set("Content-Type", attachment.Mime)
 	if attachment.Type == "file" {
 		header.Set(
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"air-sync/models"
+	repos "air-sync/repositories"
+	"air-sync/storages"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachmentHandlerEncoding(t *testing.T) {
+	ctx := context.Background()
+	repo := repos.NewAttachmentMemoryRepository(repos.NewMemoryDatabase())
+	memory := storages.NewMemoryStorage()
+	storage := storages.NewCompressedStorage(memory, storages.EncodingGzip)
+	require.Nil(t, storage.Initialize())
+	defer storage.Deinitialize()
+	r := mux.NewRouter()
+	NewAttachmentHandler(repo, storage).RegisterRoutes(r)
+
+	content := []byte(strings.Repeat("Hello, world!\n", 100))
+	create := models.NewCreateAttachment("hello.txt", "file", "text/plain; charset=utf-8")
+	create.Encoding = storages.ContentEncoding(storage, create.Mime)
+	attachment, err := repo.Create(ctx, create)
+	require.Nil(t, err)
+	w, err := storages.WriteEncoded(ctx, storage, attachment.ID, attachment.Encoding)
+	require.Nil(t, err)
+	_, err = w.Write(content)
+	require.Nil(t, err)
+	require.Nil(t, w.Close())
+	stored, err := memory.Read(ctx, attachment.ID)
+	require.Nil(t, err)
+	compressed, err := ioutil.ReadAll(stored)
+	require.Nil(t, err)
+
+	download := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/attachments/"+attachment.ID, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+		return rec
+	}
+
+	// Clients accepting the stored encoding get the stored bytes
+	for _, accept := range []string{"gzip", "br, GZIP;q=0.5", "*"} {
+		rec := download(accept)
+		require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"), accept)
+		require.True(t, bytes.Equal(compressed, rec.Body.Bytes()), accept)
+	}
+	// Others get the original bytes
+	for _, accept := range []string{"", "zstd", "gzip;q=0"} {
+		rec := download(accept)
+		require.Equal(t, "", rec.Header().Get("Content-Encoding"), accept)
+		require.True(t, bytes.Equal(content, rec.Body.Bytes()), accept)
+	}
+}
@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"air-sync/models"
 	"air-sync/services"
 	"air-sync/util"
 	"net/http"
+	"strconv"
 
 	log "github.com/sirupsen/logrus"
 
@@ -17,6 +19,8 @@ const (
 	CronEnvAppEngine CronEnvironment = "app_engine"
 )
 
+const cronMaxHistory = 100
+
 type CronHandler struct {
 	env  CronEnvironment
 	cron *services.CronJobService
@@ -34,20 +38,76 @@ func NewCronHandler(env CronEnvironment, cron *services.CronJobService) *CronHan
 func (h *CronHandler) RegisterRoutes(r *mux.Router) {
 	s := r.PathPrefix("/cron").Subrouter()
 	s.Use(h.Middleware)
-	s.HandleFunc("/cleanup", util.WrapHandlerFunc(h.CleanupJob))
+	s.HandleFunc("/jobs", util.WrapRestHandlerFunc(h.GetJobs)).Methods("GET")
+	for _, name := range h.cron.JobNames() {
+		s.HandleFunc("/"+name, util.WrapRestHandlerFunc(h.RunJob(name))).Methods("GET", "POST")
+	}
+}
+
+// RunJob runs the job and returns its report, or only reports what it
+// would do when the dry_run query is true.
+func (h *CronHandler) RunJob(name string) util.RestHandlerFunc {
+	return func(req *http.Request) (*util.RestResponse, error) {
+		dryRun := false
+		if v := req.URL.Query().Get("dry_run"); v != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(v); err != nil {
+				return &util.RestResponse{
+					StatusCode: http.StatusBadRequest,
+					Error:      "Invalid dry_run: " + v,
+				}, nil
+			}
+		}
+		var report services.CronJobReport
+		var err error
+		message := "Ran " + name + " job"
+		if dryRun {
+			report, err = h.cron.DryRunJob(req.Context(), name)
+			message = "Dry ran " + name + " job"
+		} else {
+			_, report, err = h.cron.RunJob(req.Context(), name, models.CronTriggerRequest)
+		}
+		if err != nil {
+			if v, ok := err.(services.CronRequestError); ok {
+				return &util.RestResponse{
+					StatusCode: http.StatusBadRequest,
+					Error:      v.Error(),
+				}, nil
+			}
+			util.RequestLogger(req).Error(err)
+			// Failed runs still tell what they went through
+			return &util.RestResponse{
+				StatusCode: http.StatusInternalServerError,
+				Error:      err.Error(),
+				Data:       report,
+			}, nil
+		}
+		return &util.RestResponse{
+			Message: message,
+			Data:    report,
+		}, nil
+	}
 }
 
-func (h *CronHandler) CleanupJob(req *http.Request) (*util.Response, error) {
-	if err := h.cron.RunCleanupJob(); err != nil {
-		if v, ok := err.(services.CronRequestError); ok {
-			return &util.Response{
-				StatusCode: 400,
-				Body:       []byte(v.Error()),
+// GetJobs lists the jobs with their latest runs, as many as the limit query
+// asks for.
+func (h *CronHandler) GetJobs(req *http.Request) (*util.RestResponse, error) {
+	limit := services.DefaultCronJobHistory
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > cronMaxHistory {
+			return &util.RestResponse{
+				StatusCode: http.StatusBadRequest,
+				Error:      "Invalid limit: " + v,
 			}, nil
 		}
+		limit = n
+	}
+	jobs, err := h.cron.Jobs(req.Context(), limit)
+	if err != nil {
 		return nil, err
 	}
-	return util.SuccessResponse, nil
+	return util.CreateRestResponse(jobs), nil
 }
 
 func (h *CronHandler) Middleware(next http.Handler) http.Handler {
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	repos "air-sync/repositories"
+	"air-sync/services"
+	"air-sync/storages"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronHandler(t *testing.T) {
+	ctx := context.Background()
+	db := repos.NewMemoryDatabase()
+	cron := services.NewCronJobService(ctx, services.CronJobOptions{
+		SessionRepository:    repos.NewSessionMemoryRepository(db),
+		AttachmentRepository: repos.NewAttachmentMemoryRepository(db),
+		RunRepository:        repos.NewCronRunMemoryRepository(db),
+		Storage:              storages.NewMemoryStorage(),
+	})
+	require.Nil(t, cron.Initialize())
+	defer cron.Deinitialize()
+	r := mux.NewRouter()
+	NewCronHandler(CronEnvLocal, cron).RegisterRoutes(r)
+
+	request := func(method string, path string, header http.Header) int {
+		req := httptest.NewRequest(method, path, nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	local := http.Header{
+		"X-Cron-Agent": {"Postman"},
+		"X-Real-Ip":    {"127.0.0.1"},
+	}
+	require.Equal(t, http.StatusOK, request("GET", "/cron/cleanup", local))
+	require.Equal(t, http.StatusOK, request("POST", "/cron/cleanup?dry_run=true", local))
+	require.Equal(t, http.StatusMethodNotAllowed, request("DELETE", "/cron/cleanup", local))
+	require.Equal(t, http.StatusOK, request("GET", "/cron/jobs", local))
+	require.Equal(t, http.StatusMethodNotAllowed, request("POST", "/cron/jobs", local))
+
+	// Requests from outside the cron environment aren't let in
+	require.Equal(t, http.StatusNotFound, request("GET", "/cron/cleanup", http.Header{
+		"X-Real-Ip":     {"127.0.0.1"},
+		"Authorization": {"Bearer token"},
+	}))
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"air-sync/services"
+	"air-sync/util"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type HealthHandler struct {
+	broker services.HealthReporter
+}
+
+var _ RouteHandler = (*HealthHandler)(nil)
+
+func NewHealthHandler(broker services.HealthReporter) *HealthHandler {
+	return &HealthHandler{broker}
+}
+
+func (h *HealthHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/health", util.WrapRestHandlerFunc(h.GetHealth)).Methods("GET")
+}
+
+// GetHealth fails with 503 while the event broker isn't connected, as the
+// realtime sync with the other instances is broken meanwhile.
+func (h *HealthHandler) GetHealth(req *http.Request) (*util.RestResponse, error) {
+	health := h.broker.Health()
+	if !health.Healthy() {
+		return &util.RestResponse{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    "Event broker is " + string(health.State),
+			Data:       health,
+			Error:      health.Error,
+		}, nil
+	}
+	return util.CreateRestResponse(health), nil
+}
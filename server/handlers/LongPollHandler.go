@@ -30,7 +30,7 @@ func (h *LongPollHandler) RegisterRoutes(r *mux.Router) {
 
 func (h *LongPollHandler) PollSession(req *http.Request) (*util.RestResponse, error) {
 	id := mux.Vars(req)["id"]
-	session, err := h.repo.Find(id)
+	session, err := h.repo.Find(req.Context(), id)
 	if err != nil {
 		return h.HandleSessionRestError(err)
 	}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"air-sync/models"
+	repos "air-sync/repositories"
+	"air-sync/storages"
+	"air-sync/util"
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const archiveManifestName = "manifest.json"
+
+type SessionArchiveHandler struct {
+	sessionRepo    repos.SessionRepository
+	attachmentRepo repos.AttachmentRepository
+	storage        storages.Storage
+}
+
+type ArchiveManifest struct {
+	SessionID string                   `json:"session_id"`
+	CreatedAt int64                    `json:"created_at"`
+	Messages  []ArchiveManifestMessage `json:"messages"`
+}
+
+type ArchiveManifestMessage struct {
+	ID         string                     `json:"id"`
+	Sensitive  bool                       `json:"sensitive"`
+	Body       string                     `json:"body,omitempty"`
+	Attachment *ArchiveManifestAttachment `json:"attachment,omitempty"`
+	CreatedAt  int64                      `json:"created_at"`
+}
+
+type ArchiveManifestAttachment struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Mime    string `json:"mime"`
+	Name    string `json:"name"`
+	File    string `json:"file,omitempty"`
+	Missing bool   `json:"missing,omitempty"`
+}
+
+var _ RouteHandler = (*SessionArchiveHandler)(nil)
+
+func NewSessionArchiveHandler(
+	sessionRepo repos.SessionRepository,
+	attachmentRepo repos.AttachmentRepository,
+	storage storages.Storage,
+) *SessionArchiveHandler {
+	return &SessionArchiveHandler{
+		sessionRepo:    sessionRepo,
+		attachmentRepo: attachmentRepo,
+		storage:        storage,
+	}
+}
+
+func (h *SessionArchiveHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/sessions/{id}/attachments.zip", util.WrapHandlerFunc(h.DownloadArchive)).Methods("GET")
+}
+
+func (h *SessionArchiveHandler) DownloadArchive(req *http.Request) (*util.Response, error) {
+	id := mux.Vars(req)["id"]
+	session, err := h.sessionRepo.Find(req.Context(), id)
+	if err != nil {
+		if errors.Is(err, repos.ErrSessionNotFound) {
+			return &util.Response{
+				StatusCode:  http.StatusNotFound,
+				ContentType: "text/plain",
+				Body:        []byte("Session not found"),
+			}, nil
+		}
+		return nil, err
+	}
+
+	logger := util.RequestLogger(req).WithField("session_id", session.ID)
+	r, w := io.Pipe()
+	go func() {
+		// Closing the pipe with the error lets the response writer stop early,
+		// while a client disconnect cancels the request context and closes the
+		// reader, stopping this goroutine.
+		err := h.writeArchive(req.Context(), w, session)
+		if err != nil {
+			logger.Error(err)
+		}
+		w.CloseWithError(err)
+	}()
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/zip")
+	header.Set(
+		"Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"airsync-%s.zip\"", session.ID),
+	)
+	return &util.Response{
+		Header:     header,
+		BodyStream: r,
+	}, nil
+}
+
+func (h *SessionArchiveHandler) writeArchive(ctx context.Context, w io.Writer, session models.Session) error {
+	zw := zip.NewWriter(w)
+	manifest := ArchiveManifest{
+		SessionID: session.ID,
+		CreatedAt: session.CreatedAt,
+		Messages:  make([]ArchiveManifestMessage, 0, len(session.Messages)),
+	}
+	names := map[string]bool{archiveManifestName: true}
+	written := make(map[string]*ArchiveManifestAttachment)
+
+	// Messages are sorted from the newest, so walk them backwards to give the
+	// oldest attachment the undecorated file name.
+	for idx := len(session.Messages) - 1; idx >= 0; idx-- {
+		message := session.Messages[idx]
+		entry := ArchiveManifestMessage{
+			ID:        message.ID,
+			Sensitive: message.Sensitive,
+			Body:      message.Body,
+			CreatedAt: message.CreatedAt,
+		}
+		if message.AttachmentID != "" {
+			attachment, ok := written[message.AttachmentID]
+			if !ok {
+				res, err := h.writeAttachment(ctx, zw, message.AttachmentID, names)
+				if err != nil {
+					return err
+				}
+				attachment = res
+				written[message.AttachmentID] = attachment
+			}
+			entry.Attachment = attachment
+		}
+		manifest.Messages = append(manifest.Messages, entry)
+	}
+
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     archiveManifestName,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (h *SessionArchiveHandler) writeAttachment(ctx context.Context, zw *zip.Writer, id string, names map[string]bool) (*ArchiveManifestAttachment, error) {
+	attachment, err := h.attachmentRepo.Find(ctx, id)
+	if errors.Is(err, repos.ErrAttachmentNotFound) {
+		return &ArchiveManifestAttachment{ID: id, Missing: true}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	entry := &ArchiveManifestAttachment{
+		ID:   attachment.ID,
+		Type: attachment.Type,
+		Mime: attachment.Mime,
+		Name: attachment.Name,
+	}
+	exists, err := h.storage.Exists(ctx, attachment.ID)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		entry.Missing = true
+		return entry, nil
+	}
+
+	name := uniqueArchiveName(attachment, names)
+	method := zip.Deflate
+	if isCompressedMime(attachment.Mime) {
+		method = zip.Store
+	}
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: time.Unix(0, attachment.CreatedAt*int64(time.Millisecond)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	r, err := storages.ReadDecoded(ctx, h.storage, attachment.ID, attachment.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if _, err := io.Copy(fw, r); err != nil {
+		return nil, err
+	}
+	entry.File = name
+	return entry, nil
+}
+
+func uniqueArchiveName(attachment models.Attachment, names map[string]bool) string {
+	base := path.Base(strings.ReplaceAll(attachment.Name, "\\", "/"))
+	if base == "" || base == "." || base == "/" {
+		base = attachment.ID
+	}
+	ext := path.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	name := base
+	for n := 1; names[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+	}
+	names[strings.ToLower(name)] = true
+	return name
+}
+
+func isCompressedMime(mime string) bool {
+	for _, prefix := range []string{"image/", "video/", "audio/", "application/zip", "application/x-gzip"} {
+		if strings.HasPrefix(mime, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"air-sync/models"
+	repos "air-sync/repositories"
+	"air-sync/storages"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionArchiveHandler(t *testing.T) {
+	ctx := context.Background()
+	db := repos.NewMemoryDatabase()
+	sessionRepo := repos.NewSessionMemoryRepository(db)
+	attachmentRepo := repos.NewAttachmentMemoryRepository(db)
+	storage := storages.NewMemoryStorage()
+	r := mux.NewRouter()
+	NewSessionArchiveHandler(sessionRepo, attachmentRepo, storage).RegisterRoutes(r)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	attach := func(name string, content string) string {
+		attachment, err := attachmentRepo.Create(ctx, models.NewCreateAttachment(name, "file", "text/plain"))
+		require.Nil(t, err)
+		w, err := storage.Write(ctx, attachment.ID)
+		require.Nil(t, err)
+		_, err = w.Write([]byte(content))
+		require.Nil(t, err)
+		require.Nil(t, w.Close())
+		return attachment.ID
+	}
+	first, second := attach("notes.txt", "first"), attach("notes.txt", "second")
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	for _, message := range []models.BaseMessage{
+		{Body: "hello"},
+		{AttachmentID: first},
+		{AttachmentID: second},
+		{Body: "secret", Sensitive: true},
+		{AttachmentID: first},
+	} {
+		_, err := sessionRepo.InsertMessage(ctx, session.ID, models.InsertMessage{BaseMessage: message})
+		require.Nil(t, err)
+	}
+
+	res, err := http.Get(server.URL + "/sessions/" + session.ID + "/attachments.zip")
+	require.Nil(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/zip", res.Header.Get("Content-Type"))
+	b, err := ioutil.ReadAll(res.Body)
+	require.Nil(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	require.Nil(t, err)
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		require.Nil(t, err)
+		content, err := ioutil.ReadAll(r)
+		require.Nil(t, err)
+		r.Close()
+		files[f.Name] = string(content)
+	}
+
+	// Attachments sharing a name get distinct files, each written once
+	require.Len(t, files, 3)
+	require.Equal(t, "first", files["notes.txt"])
+	require.Equal(t, "second", files["notes (1).txt"])
+	manifest := ArchiveManifest{}
+	require.Nil(t, json.Unmarshal([]byte(files[archiveManifestName]), &manifest))
+	require.Equal(t, session.ID, manifest.SessionID)
+	require.Len(t, manifest.Messages, 5)
+	bodies := make([]string, 0)
+	attachments := make([]string, 0)
+	for _, message := range manifest.Messages {
+		if message.Body != "" {
+			bodies = append(bodies, message.Body)
+		}
+		if message.Attachment != nil {
+			attachments = append(attachments, message.Attachment.File)
+		}
+	}
+	// Messages are listed from the oldest
+	require.Equal(t, []string{"hello", "secret"}, bodies)
+	require.Equal(t, []string{"notes.txt", "notes (1).txt", "notes.txt"}, attachments)
+	require.True(t, manifest.Messages[3].Sensitive)
+
+	res, err = http.Get(server.URL + "/sessions/missing/attachments.zip")
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
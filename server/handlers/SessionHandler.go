@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"air-sync/models"
-	"air-sync/models/events"
 	repos "air-sync/repositories"
 	"air-sync/util"
 	"air-sync/util/logging"
@@ -33,18 +32,16 @@ var (
 )
 
 type SessionHandler struct {
-	repo  repos.SessionRepository
-	pub   *pubsub.Publisher
-	topic *pubsub.Topic
+	repo repos.SessionRepository
+	pub  *pubsub.Publisher
 }
 
 type SessionHandlerFunc func(req *http.Request, session models.Session) (interface{}, error)
 
 func NewSessionHandler(repo repos.SessionRepository, pub *pubsub.Publisher) *SessionHandler {
 	return &SessionHandler{
-		repo:  repo,
-		pub:   pub,
-		topic: pub.Topic(events.EventSession),
+		repo: repo,
+		pub:  pub,
 	}
 }
 
@@ -61,7 +58,7 @@ func (h *SessionHandler) ApplySessionLogger(logger *log.Logger, session models.S
 func (h *SessionHandler) WrapSessionHandlerFunc(handler SessionHandlerFunc) http.HandlerFunc {
 	return util.WrapRestHandlerFunc(func(req *http.Request) (*util.RestResponse, error) {
 		id := mux.Vars(req)["id"]
-		session, err := h.repo.Find(id)
+		session, err := h.repo.Find(req.Context(), id)
 		if err != nil {
 			return h.HandleSessionRestError(err)
 		}
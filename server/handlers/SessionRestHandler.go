@@ -2,26 +2,38 @@ package handlers
 
 import (
 	"air-sync/models"
-	"air-sync/models/events"
 	repos "air-sync/repositories"
+	"air-sync/services"
 	"air-sync/util"
 	"air-sync/util/pubsub"
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 )
 
+// SessionRestHandler leaves publishing the events of the changes to the
+// outbox relay, which the repository records them for.
 type SessionRestHandler struct {
 	*SessionHandler
+	cleanup *services.StorageCleanupService
+	relay   *services.OutboxRelayService
 }
 
 var _ RouteHandler = (*SessionRestHandler)(nil)
 
-func NewSessionRestHandler(repo repos.SessionRepository, pub *pubsub.Publisher) *SessionRestHandler {
+func NewSessionRestHandler(
+	repo repos.SessionRepository,
+	pub *pubsub.Publisher,
+	cleanup *services.StorageCleanupService,
+	relay *services.OutboxRelayService,
+) *SessionRestHandler {
 	return &SessionRestHandler{
 		SessionHandler: NewSessionHandler(repo, pub),
+		cleanup:        cleanup,
+		relay:          relay,
 	}
 }
 
@@ -31,17 +43,16 @@ func (h *SessionRestHandler) RegisterRoutes(r *mux.Router) {
 	s.HandleFunc("/{id}", h.WrapSessionHandlerFunc(h.GetSession)).Methods("GET")
 	s.HandleFunc("/{id}", util.WrapRestHandlerFunc(h.DeleteSession)).Methods("DELETE")
 	s.HandleFunc("/{id}", util.WrapRestHandlerFunc(h.InsertMessage)).Methods("PUT")
+	s.HandleFunc("/{id}/search", util.WrapRestHandlerFunc(h.SearchSession)).Methods("GET")
 	s.HandleFunc("/{id}/{message-id}", util.WrapRestHandlerFunc(h.DeleteMessage)).Methods("DELETE")
 }
 
 func (h *SessionRestHandler) CreateSession(req *http.Request) (*util.RestResponse, error) {
-	session, err := h.repo.Create()
+	session, err := h.repo.Create(req.Context())
 	if err != nil {
 		return h.HandleSessionRestError(err)
 	}
-	h.topic.Publish(events.CreateSessionEvent(
-		session.ID, events.EventSessionCreated, events.SessionCreate(session), nil,
-	))
+	h.relay.Notify()
 	util.RequestLogger(req).WithField("session_id", session.ID).Info("Created new session")
 	return &util.RestResponse{
 		Message: "Session created",
@@ -53,15 +64,73 @@ func (h *SessionRestHandler) GetSession(req *http.Request, session models.Sessio
 	return session, nil
 }
 
+func (h *SessionRestHandler) SearchSession(req *http.Request) (*util.RestResponse, error) {
+	id := mux.Vars(req)["id"]
+	values := req.URL.Query()
+	query := models.SearchQuery{
+		Terms:            models.ParseSearchTerms(values.Get("q")),
+		IncludeSensitive: values.Get("include_sensitive") == "true",
+		Limit:            models.DefaultSearchLimit,
+	}
+	if len(query.Terms) <= 0 {
+		return &util.RestResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Malformed request",
+			Error:      "Search query is empty",
+		}, nil
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return &util.RestResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    "Malformed request",
+				Error:      "Invalid limit",
+			}, nil
+		} else if limit > models.MaxSearchLimit {
+			limit = models.MaxSearchLimit
+		}
+		query.Limit = limit
+	}
+	if v := values.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return &util.RestResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    "Malformed request",
+				Error:      "Invalid offset",
+			}, nil
+		}
+		query.Offset = offset
+	}
+	result, err := h.repo.Search(req.Context(), id, query)
+	if err != nil {
+		return h.HandleSessionRestError(err)
+	}
+	return &util.RestResponse{
+		Message: "Session searched",
+		Data:    result,
+	}, nil
+}
+
 func (h *SessionRestHandler) DeleteSession(req *http.Request) (*util.RestResponse, error) {
 	id := mux.Vars(req)["id"]
-	if err := h.repo.Delete(id); err != nil {
+	attachmentIDs, err := h.repo.Delete(req.Context(), id)
+	if err != nil {
 		return h.HandleSessionRestError(err)
 	}
-	h.topic.Publish(events.CreateSessionEvent(
-		id, events.EventSessionDeleted, events.SessionDelete(id), nil,
-	))
-	util.RequestLogger(req).WithField("session_id", id).Info("Deleted session")
+	logger := util.RequestLogger(req).WithField("session_id", id)
+	// The records are gone, so nothing else would delete the objects the
+	// cleanup can't take
+	for _, name := range h.cleanup.Queue(attachmentIDs...) {
+		if err := h.cleanup.Delete(req.Context(), name); err != nil {
+			logger.WithField("object", name).Errorf("Storage cleanup: %v", err)
+		}
+	}
+	h.relay.Notify()
+	logger.Infof(
+		"Deleted session with %d attachment(s)", len(attachmentIDs),
+	)
 	return &util.RestResponse{
 		Message: "Session deleted",
 	}, nil
@@ -81,16 +150,11 @@ func (h *SessionRestHandler) InsertMessage(req *http.Request) (*util.RestRespons
 			Error:      "Message body and attachment are empty",
 		}, nil
 	}
-	message, err := h.repo.InsertMessage(id, insert)
+	message, err := h.repo.InsertMessage(req.Context(), id, insert)
 	if err != nil {
 		return h.HandleSessionRestError(err)
 	}
-	h.topic.Publish(events.CreateSessionEvent(
-		id, events.EventMessageInserted, events.MessageInsert{
-			SessionID: id,
-			Message:   message,
-		}, nil,
-	))
+	h.relay.Notify()
 	util.RequestLogger(req).WithFields(log.Fields{
 		"session_id": id,
 		"message_id": message.ID,
@@ -105,15 +169,11 @@ func (h *SessionRestHandler) DeleteMessage(req *http.Request) (*util.RestRespons
 	vars := mux.Vars(req)
 	sessionID := vars["id"]
 	messageID := vars["message-id"]
-	if err := h.repo.DeleteMessage(sessionID, messageID); err != nil {
+	_, err := h.repo.DeleteMessage(req.Context(), sessionID, messageID)
+	if err != nil {
 		return h.HandleSessionRestError(err)
 	}
-	h.pub.Topic(events.EventSession).Publish(events.CreateSessionEvent(
-		sessionID, events.EventMessageDeleted, events.MessageDelete{
-			SessionID: sessionID,
-			MessageID: messageID,
-		}, nil,
-	))
+	h.relay.Notify()
 	util.RequestLogger(req).WithFields(log.Fields{
 		"session_id": sessionID,
 		"message_id": messageID,
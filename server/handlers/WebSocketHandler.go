@@ -63,7 +63,7 @@ func (h *WebSocketHandler) RegisterRoutes(r *mux.Router) {
 func (h *WebSocketHandler) SetupWS(w http.ResponseWriter, req *http.Request) {
 	req = util.DecorateRequest(req)
 	id := mux.Vars(req)["id"]
-	session, err := h.repo.Find(id)
+	session, err := h.repo.Find(req.Context(), id)
 	if err != nil {
 		h.HandleSessionError(w, err)
 		return
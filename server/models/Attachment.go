@@ -1,9 +1,10 @@
 package models
 
 type BaseAttachment struct {
-	Type string `json:"type"`
-	Mime string `json:"mime"`
-	Name string `json:"name"`
+	Type     string `json:"type"`
+	Mime     string `json:"mime"`
+	Name     string `json:"name"`
+	Encoding string `json:"encoding,omitempty"`
 }
 
 type Attachment struct {
@@ -0,0 +1,30 @@
+package models
+
+const (
+	CronRunRunning   = "running"
+	CronRunSucceeded = "succeeded"
+	CronRunFailed    = "failed"
+)
+
+const (
+	// CronTriggerSchedule is a run started by the scheduler of an instance
+	CronTriggerSchedule = "schedule"
+	// CronTriggerRequest is a run started by a request to the job route
+	CronTriggerRequest = "request"
+	// CronTriggerCommand is a run started from the command line
+	CronTriggerCommand = "command"
+)
+
+// CronRun records a run of a cron job and what it did.
+type CronRun struct {
+	ID        string `json:"id"`
+	Job       string `json:"job"`
+	Trigger   string `json:"trigger"`
+	StartedAt int64  `json:"started_at"`
+	// EndedAt is zero while the job runs
+	EndedAt int64  `json:"ended_at"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	// Counts are the numbers of items the job went through, by kind
+	Counts map[string]int64 `json:"counts,omitempty"`
+}
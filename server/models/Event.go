@@ -5,5 +5,6 @@ type Event struct {
 	Event     string      `json:"event"`
 	Data      interface{} `json:"data,omitempty"`
 	Error     string      `json:"error,omitempty"`
+	Seq       int64       `json:"seq,omitempty"`
 	Timestamp int64       `json:"timestamp"`
 }
@@ -0,0 +1,9 @@
+package models
+
+// Lease is held by a single instance until it expires, so only that one
+// performs the work it names.
+type Lease struct {
+	Name      string `json:"name"`
+	Holder    string `json:"holder"`
+	ExpiresAt int64  `json:"expires_at"`
+}
@@ -11,6 +11,7 @@ type Message struct {
 	ID             string `json:"id"`
 	AttachmentName string `json:"attachment_name,omitempty"`
 	AttachmentType string `json:"attachment_type,omitempty"`
+	Seq            int64  `json:"seq"`
 	CreatedAt      int64  `json:"created_at"`
 }
 
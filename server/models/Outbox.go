@@ -0,0 +1,18 @@
+package models
+
+// OutboxEntry is an event recorded along with the change it's about, until
+// it's published to the other instances.
+type OutboxEntry struct {
+	// ID is the ID of the event
+	ID        string `json:"id"`
+	SessionID string `json:"session_id"`
+	// Payload is the event encoded with the JSON codec
+	Payload string `json:"payload"`
+	// Position orders the entries in the order they were recorded
+	Position  int64 `json:"position"`
+	CreatedAt int64 `json:"created_at"`
+	// ClaimedUntil keeps other relays from publishing the entry meanwhile
+	ClaimedUntil int64 `json:"claimed_until"`
+	// DeliveredAt is zero until the event is published
+	DeliveredAt int64 `json:"delivered_at"`
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+	maxSearchTerms     = 16
+)
+
+type SearchQuery struct {
+	// Terms are lowercase, and all of them have to match either the message
+	// body or the attachment name
+	Terms            []string
+	IncludeSensitive bool
+	Offset           int
+	Limit            int
+}
+
+// HighlightSegment is a piece of a searched field, marked when it matches
+// one of the terms. Joining the segments gives back the original text.
+type HighlightSegment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+type SearchMatch struct {
+	Message        Message            `json:"message"`
+	Body           []HighlightSegment `json:"body,omitempty"`
+	AttachmentName []HighlightSegment `json:"attachment_name,omitempty"`
+}
+
+type SearchResult struct {
+	Total   int           `json:"total"`
+	Offset  int           `json:"offset"`
+	Limit   int           `json:"limit"`
+	Matches []SearchMatch `json:"matches"`
+}
+
+// ParseSearchTerms splits a query into distinct lowercase terms.
+func ParseSearchTerms(q string) []string {
+	seen := make(map[string]bool)
+	terms := make([]string, 0)
+	for _, field := range strings.Fields(strings.ToLower(q)) {
+		field = strings.Trim(field, "\"")
+		if field == "" || seen[field] {
+			continue
+		}
+		seen[field] = true
+		terms = append(terms, field)
+		if len(terms) >= maxSearchTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// MatchesSearchTerms reports whether text contains every term.
+func MatchesSearchTerms(text string, terms []string) bool {
+	if text == "" {
+		return false
+	}
+	text = strings.ToLower(text)
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// Highlight splits text around the occurrences of the terms, or returns nil
+// when none of them occurs.
+func Highlight(text string, terms []string) []HighlightSegment {
+	// Lowercasing may change byte lengths, so match rune by rune
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		lower = runes
+	}
+	type span struct{ start, end int }
+	spans := make([]span, 0)
+	for _, term := range terms {
+		needle := []rune(term)
+		for i := 0; len(needle) > 0 && i+len(needle) <= len(lower); i++ {
+			if string(lower[i:i+len(needle)]) == term {
+				spans = append(spans, span{i, i + len(needle)})
+			}
+		}
+	}
+	if len(spans) <= 0 {
+		return nil
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+	segments := make([]HighlightSegment, 0)
+	pos := 0
+	for _, s := range spans {
+		if s.end <= pos {
+			continue
+		} else if s.start < pos {
+			s.start = pos
+		}
+		if s.start > pos {
+			segments = append(segments, HighlightSegment{Text: string(runes[pos:s.start])})
+		}
+		// Overlapping and adjacent matches are merged
+		if n := len(segments); n > 0 && segments[n-1].Match && s.start == pos {
+			segments[n-1].Text += string(runes[s.start:s.end])
+		} else {
+			segments = append(segments, HighlightSegment{Text: string(runes[s.start:s.end]), Match: true})
+		}
+		pos = s.end
+	}
+	if pos < len(runes) {
+		segments = append(segments, HighlightSegment{Text: string(runes[pos:])})
+	}
+	return segments
+}
+
+// NewSearchMatch highlights the terms in the searched fields of the message.
+func NewSearchMatch(message Message, terms []string) SearchMatch {
+	return SearchMatch{
+		Message:        message,
+		Body:           Highlight(message.Body, terms),
+		AttachmentName: Highlight(message.AttachmentName, terms),
+	}
+}
@@ -5,9 +5,11 @@ import (
 )
 
 type Session struct {
-	ID        string    `json:"id"`
-	Messages  []Message `json:"messages"`
-	CreatedAt int64     `json:"created_at"`
+	ID       string    `json:"id"`
+	Messages []Message `json:"messages"`
+	// Seq is the sequence number of the last change to the messages
+	Seq       int64 `json:"seq"`
+	CreatedAt int64 `json:"created_at"`
 }
 
 var EmptySession = Session{}
@@ -0,0 +1,208 @@
+package events
+
+import (
+	"air-sync/models"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/codes"
+)
+
+// SchemaVersion is the version of the encoded events. Events of a newer
+// version are rejected, as their layout is unknown.
+const SchemaVersion = 1
+
+var ErrUnsupportedSchema = errors.New("Unsupported event schema version")
+
+// Codec encodes the events sent through the brokers.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+type msgpackCodec struct{}
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// envelope is the encoded layout of the events. Data is encoded with the
+// same codec, and decoded once the event name tells its type.
+type envelope struct {
+	Version   int        `json:"v"`
+	ID        string     `json:"id"`
+	Event     string     `json:"event"`
+	Data      rawPayload `json:"data,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Timestamp int64      `json:"timestamp"`
+	SessionID string     `json:"session_id"`
+	Seq       int64      `json:"seq,omitempty"`
+	ClientID  string     `json:"client_id"`
+	// SentAt, KeyID and Signature are only set on signed envelopes
+	SentAt    int64  `json:"sent_at,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	Signature []byte `json:"sig,omitempty"`
+}
+
+// rawPayload holds the value encoded with either codec.
+type rawPayload []byte
+
+func EncodePubSubEvent(codec Codec, event PubSubSessionEvent) ([]byte, error) {
+	return EncodeSignedPubSubEvent(codec, nil, event)
+}
+
+// EncodeSignedPubSubEvent encodes the event signed with the current key of
+// the keyring, or unsigned without keyring.
+func EncodeSignedPubSubEvent(codec Codec, keys *Keyring, event PubSubSessionEvent) ([]byte, error) {
+	env := envelope{
+		Version:   SchemaVersion,
+		ID:        event.ID,
+		Event:     event.Event,
+		Timestamp: event.Timestamp,
+		SessionID: event.SessionID,
+		Seq:       event.Seq,
+		ClientID:  event.ClientID,
+	}
+	if event.Value != nil {
+		b, err := codec.Marshal(event.Value)
+		if err != nil {
+			return nil, err
+		}
+		env.Data = b
+	}
+	if event.Error != nil {
+		env.Error = event.Error.Error()
+	}
+	if keys != nil {
+		env.SentAt = models.Timestamp()
+		keys.sign(&env)
+	}
+	return codec.Marshal(env)
+}
+
+func DecodePubSubEvent(codec Codec, b []byte) (PubSubSessionEvent, error) {
+	return DecodeVerifiedPubSubEvent(codec, nil, b, 0)
+}
+
+// DecodeVerifiedPubSubEvent decodes the event, rejecting envelopes which
+// aren't signed with a key of the keyring or were sent longer than maxAge
+// ago. Without keyring, any envelope is accepted.
+func DecodeVerifiedPubSubEvent(codec Codec, keys *Keyring, b []byte, maxAge time.Duration) (PubSubSessionEvent, error) {
+	env := envelope{}
+	if err := codec.Unmarshal(b, &env); err != nil {
+		return PubSubSessionEvent{}, err
+	}
+	if env.Version > SchemaVersion {
+		return PubSubSessionEvent{}, fmt.Errorf("%w: %d", ErrUnsupportedSchema, env.Version)
+	}
+	if keys != nil {
+		if err := keys.verify(&env); err != nil {
+			return PubSubSessionEvent{}, err
+		}
+		if err := checkSentAt(&env, maxAge); err != nil {
+			return PubSubSessionEvent{}, err
+		}
+	}
+	event := PubSubSessionEvent{
+		SessionEvent: SessionEvent{
+			BaseEvent: BaseEvent{
+				ID:        env.ID,
+				Event:     env.Event,
+				Timestamp: env.Timestamp,
+			},
+			SessionID: env.SessionID,
+			Seq:       env.Seq,
+		},
+		ClientID: env.ClientID,
+		SentAt:   env.SentAt,
+	}
+	if env.Error != "" {
+		event.Error = errors.New(env.Error)
+	}
+	if len(env.Data) == 0 {
+		return event, nil
+	}
+	// Unknown events still decode, with a generic value
+	v, ok := NewPayload(env.Event)
+	if !ok {
+		err := codec.Unmarshal(env.Data, &event.Value)
+		return event, err
+	}
+	if err := codec.Unmarshal(env.Data, v); err != nil {
+		return event, err
+	}
+	event.Value = reflect.ValueOf(v).Elem().Interface()
+	return event, nil
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+// Marshal uses the JSON field names, so both codecs encode the same keys.
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	enc := msgpack.NewEncoder(&buf)
+	enc.UseJSONTag(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(b []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+	dec.UseJSONTag(true)
+	return dec.Decode(v)
+}
+
+func (p rawPayload) MarshalJSON() ([]byte, error) {
+	return json.RawMessage(p).MarshalJSON()
+}
+
+func (p *rawPayload) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*p = nil
+		return nil
+	}
+	*p = append((*p)[:0], b...)
+	return nil
+}
+
+func (p rawPayload) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return enc.Encode(msgpack.RawMessage(p))
+}
+
+func (p *rawPayload) DecodeMsgpack(dec *msgpack.Decoder) error {
+	raw, err := dec.DecodeRaw()
+	if err != nil {
+		return err
+	}
+	if len(raw) == 1 && codes.Code(raw[0]) == codes.Nil {
+		*p = nil
+		return nil
+	}
+	*p = rawPayload(raw)
+	return nil
+}
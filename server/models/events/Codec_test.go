@@ -0,0 +1,75 @@
+package events
+
+import (
+	"air-sync/models"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecs(t *testing.T) {
+	message := models.Message{
+		ID:        "message",
+		Seq:       3,
+		CreatedAt: 1234,
+	}
+	message.Body = "body"
+	session := models.Session{
+		ID:        "session",
+		Messages:  []models.Message{message},
+		CreatedAt: 1234,
+	}
+	values := []SessionEvent{
+		CreateSessionEvent("session", EventSessionCreated, SessionCreate(session), nil),
+		CreateSessionEvent("session", EventSessionDeleted, SessionDelete("session"), nil),
+		CreateSessionEvent("session", EventMessageInserted, MessageInsert{
+			SessionID: "session",
+			Message:   message,
+		}, nil),
+		CreateSessionEvent("session", EventMessageDeleted, MessageDelete{
+			SessionID: "session",
+			MessageID: "message",
+		}, nil),
+		CreateSessionEvent("session", EventSessionCreated, nil, nil),
+	}
+	values[2].Seq = 3
+
+	for _, codec := range []Codec{JSONCodec, MsgpackCodec} {
+		for _, event := range values {
+			b, err := EncodePubSubEvent(codec, PubSubSessionEvent{
+				SessionEvent: event,
+				ClientID:     "client",
+			})
+			require.Nil(t, err)
+			res, err := DecodePubSubEvent(codec, b)
+			require.Nil(t, err)
+			require.Equal(t, "client", res.ClientID)
+			require.Equal(t, event, res.SessionEvent, codec.Name())
+		}
+
+		// Errors come back with their message
+		event := CreateSessionEvent("session", EventSessionDeleted, nil, errors.New("failed"))
+		b, err := EncodePubSubEvent(codec, PubSubSessionEvent{SessionEvent: event})
+		require.Nil(t, err)
+		res, err := DecodePubSubEvent(codec, b)
+		require.Nil(t, err)
+		require.EqualError(t, res.Error, "failed")
+
+		// Unknown events keep a generic value
+		event = CreateSessionEvent("session", "session.unknown", map[string]interface{}{
+			"key": "value",
+		}, nil)
+		b, err = EncodePubSubEvent(codec, PubSubSessionEvent{SessionEvent: event})
+		require.Nil(t, err)
+		res, err = DecodePubSubEvent(codec, b)
+		require.Nil(t, err)
+		require.Equal(t, event.Value, res.Value)
+
+		// Newer schemas are rejected
+		b, err = codec.Marshal(envelope{Version: SchemaVersion + 1})
+		require.Nil(t, err)
+		_, err = DecodePubSubEvent(codec, b)
+		require.True(t, errors.Is(err, ErrUnsupportedSchema))
+	}
+}
@@ -3,4 +3,6 @@ package events
 type PubSubSessionEvent struct {
 	SessionEvent
 	ClientID string `json:"client_id"`
+	// SentAt is when the envelope was signed, zero when it wasn't
+	SentAt int64 `json:"-"`
 }
@@ -0,0 +1,38 @@
+package events
+
+import (
+	"reflect"
+	"sync"
+)
+
+var (
+	payloadTypesMu sync.RWMutex
+	payloadTypes   = map[string]reflect.Type{}
+)
+
+func init() {
+	RegisterPayload(EventSessionCreated, SessionCreate{})
+	RegisterPayload(EventSessionDeleted, SessionDelete(""))
+	RegisterPayload(EventMessageInserted, MessageInsert{})
+	RegisterPayload(EventMessageDeleted, MessageDelete{})
+}
+
+// RegisterPayload sets the type of the value carried by the event, so the
+// decoded events carry the same type as the local ones.
+func RegisterPayload(event string, v interface{}) {
+	payloadTypesMu.Lock()
+	defer payloadTypesMu.Unlock()
+	payloadTypes[event] = reflect.TypeOf(v)
+}
+
+// NewPayload returns a pointer to a new value of the type registered for the
+// event, or false when there is none.
+func NewPayload(event string) (interface{}, bool) {
+	payloadTypesMu.RLock()
+	defer payloadTypesMu.RUnlock()
+	t, ok := payloadTypes[event]
+	if !ok {
+		return nil, false
+	}
+	return reflect.New(t).Interface(), true
+}
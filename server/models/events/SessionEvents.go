@@ -19,6 +19,9 @@ type Event BaseEvent
 type SessionEvent struct {
 	BaseEvent
 	SessionID string `json:"session_id"`
+	// Seq is the sequence number of the message change, which lets clients
+	// notice missed events. It's zero for events of the session itself.
+	Seq int64 `json:"seq,omitempty"`
 }
 
 type SessionCreate models.Session
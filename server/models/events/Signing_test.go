@@ -0,0 +1,100 @@
+package events
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedEvents(t *testing.T) {
+	keys, err := ParseKeyring("current:secret, previous:old-secret")
+	require.Nil(t, err)
+	event := PubSubSessionEvent{
+		SessionEvent: CreateSessionEvent("session", EventMessageDeleted, MessageDelete{
+			SessionID: "session",
+			MessageID: "message",
+		}, nil),
+		ClientID: "client",
+	}
+
+	for _, codec := range []Codec{JSONCodec, MsgpackCodec} {
+		b, err := EncodeSignedPubSubEvent(codec, keys, event)
+		require.Nil(t, err)
+		res, err := DecodeVerifiedPubSubEvent(codec, keys, b, time.Minute)
+		require.Nil(t, err, codec.Name())
+		// Decoded events tell when their envelope was signed
+		require.InDelta(t, event.Timestamp, res.SentAt, float64(time.Minute/time.Millisecond))
+		signed := event
+		signed.SentAt = res.SentAt
+		require.Equal(t, signed, res)
+
+		// Envelopes are still decoded without verifying them
+		res, err = DecodePubSubEvent(codec, b)
+		require.Nil(t, err)
+		require.Equal(t, signed, res)
+
+		unsigned, err := EncodePubSubEvent(codec, event)
+		require.Nil(t, err)
+		_, err = DecodeVerifiedPubSubEvent(codec, keys, unsigned, time.Minute)
+		require.True(t, errors.Is(err, ErrUnsignedEvent), codec.Name())
+
+		// Every field is covered by the signature
+		env := envelope{}
+		require.Nil(t, codec.Unmarshal(b, &env))
+		env.Event = EventSessionDeleted
+		forged, err := codec.Marshal(env)
+		require.Nil(t, err)
+		_, err = DecodeVerifiedPubSubEvent(codec, keys, forged, time.Minute)
+		require.True(t, errors.Is(err, ErrInvalidSignature), codec.Name())
+
+		env = envelope{}
+		require.Nil(t, codec.Unmarshal(b, &env))
+		env.SentAt -= int64(2 * time.Minute / time.Millisecond)
+		keys.sign(&env)
+		stale, err := codec.Marshal(env)
+		require.Nil(t, err)
+		_, err = DecodeVerifiedPubSubEvent(codec, keys, stale, time.Minute)
+		require.True(t, errors.Is(err, ErrStaleEvent), codec.Name())
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	event := PubSubSessionEvent{
+		SessionEvent: CreateSessionEvent("session", EventSessionDeleted, SessionDelete("session"), nil),
+	}
+	previous, err := ParseKeyring("previous:old-secret")
+	require.Nil(t, err)
+	rotated, err := ParseKeyring("current:secret,previous:old-secret")
+	require.Nil(t, err)
+	other, err := ParseKeyring("previous:other-secret")
+	require.Nil(t, err)
+
+	b, err := EncodeSignedPubSubEvent(JSONCodec, previous, event)
+	require.Nil(t, err)
+	_, err = DecodeVerifiedPubSubEvent(JSONCodec, rotated, b, time.Minute)
+	require.Nil(t, err)
+	_, err = DecodeVerifiedPubSubEvent(JSONCodec, other, b, time.Minute)
+	require.True(t, errors.Is(err, ErrInvalidSignature))
+
+	b, err = EncodeSignedPubSubEvent(JSONCodec, rotated, event)
+	require.Nil(t, err)
+	_, err = DecodeVerifiedPubSubEvent(JSONCodec, previous, b, time.Minute)
+	require.True(t, errors.Is(err, ErrUnknownEventKey))
+}
+
+func TestParseKeyring(t *testing.T) {
+	keys, err := ParseKeyring("")
+	require.Nil(t, err)
+	require.Nil(t, keys)
+
+	keys, err = ParseKeyring("a:secret:with:colons")
+	require.Nil(t, err)
+	require.Equal(t, []byte("secret:with:colons"), keys.keys["a"])
+
+	for _, s := range []string{"secret", ":secret", "a:", "a:one,a:two"} {
+		_, err := ParseKeyring(s)
+		require.NotNil(t, err, s)
+	}
+}
@@ -10,6 +10,7 @@ func FromSessionEvent(e events.SessionEvent) models.Event {
 		ID:        e.ID,
 		Event:     e.Event,
 		Data:      e.Value,
+		Seq:       e.Seq,
 		Timestamp: e.Timestamp,
 	}
 	if e.Error != nil {
@@ -11,6 +11,7 @@ type Attachment struct {
 	Type      string `bson:"type"`
 	Mime      string `bson:"mime"`
 	Name      string `bson:"name"`
+	Encoding  string `bson:"encoding,omitempty"`
 	CreatedAt int64  `bson:"created_at"`
 }
 
@@ -28,15 +29,17 @@ func FromCreateAttachmentModel(create models.CreateAttachment) Attachment {
 	attachment.Type = create.Type
 	attachment.Mime = create.Mime
 	attachment.Name = create.Name
+	attachment.Encoding = create.Encoding
 	return attachment
 }
 
 func ToAttachmentModel(attachment Attachment) models.Attachment {
 	return models.Attachment{
 		BaseAttachment: models.BaseAttachment{
-			Type: attachment.Type,
-			Mime: attachment.Mime,
-			Name: attachment.Name,
+			Type:     attachment.Type,
+			Mime:     attachment.Mime,
+			Name:     attachment.Name,
+			Encoding: attachment.Encoding,
 		},
 		ID:        attachment.ID,
 		CreatedAt: attachment.CreatedAt,
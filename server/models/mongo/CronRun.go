@@ -0,0 +1,54 @@
+package mongo
+
+import (
+	"air-sync/models"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+type CronRun struct {
+	ID        string           `bson:"_id"`
+	Job       string           `bson:"job"`
+	Trigger   string           `bson:"trigger"`
+	StartedAt int64            `bson:"started_at"`
+	EndedAt   int64            `bson:"ended_at"`
+	Outcome   string           `bson:"outcome"`
+	Error     string           `bson:"error,omitempty"`
+	Counts    map[string]int64 `bson:"counts,omitempty"`
+}
+
+func NewCronRun(job string, trigger string) CronRun {
+	return CronRun{
+		ID:        uuid.NewV4().String(),
+		Job:       job,
+		Trigger:   trigger,
+		StartedAt: models.Timestamp(),
+		Outcome:   models.CronRunRunning,
+	}
+}
+
+func FromCronRunModel(run models.CronRun) CronRun {
+	return CronRun{
+		ID:        run.ID,
+		Job:       run.Job,
+		Trigger:   run.Trigger,
+		StartedAt: run.StartedAt,
+		EndedAt:   run.EndedAt,
+		Outcome:   run.Outcome,
+		Error:     run.Error,
+		Counts:    run.Counts,
+	}
+}
+
+func ToCronRunModel(run CronRun) models.CronRun {
+	return models.CronRun{
+		ID:        run.ID,
+		Job:       run.Job,
+		Trigger:   run.Trigger,
+		StartedAt: run.StartedAt,
+		EndedAt:   run.EndedAt,
+		Outcome:   run.Outcome,
+		Error:     run.Error,
+		Counts:    run.Counts,
+	}
+}
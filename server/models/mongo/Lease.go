@@ -0,0 +1,27 @@
+package mongo
+
+import (
+	"air-sync/models"
+)
+
+type Lease struct {
+	Name      string `bson:"_id"`
+	Holder    string `bson:"holder"`
+	ExpiresAt int64  `bson:"expires_at"`
+}
+
+func FromLeaseModel(lease models.Lease) Lease {
+	return Lease{
+		Name:      lease.Name,
+		Holder:    lease.Holder,
+		ExpiresAt: lease.ExpiresAt,
+	}
+}
+
+func ToLeaseModel(lease Lease) models.Lease {
+	return models.Lease{
+		Name:      lease.Name,
+		Holder:    lease.Holder,
+		ExpiresAt: lease.ExpiresAt,
+	}
+}
@@ -12,6 +12,7 @@ type Message struct {
 	Sensitive    bool   `bson:"sensitive"`
 	Body         string `bson:"body"`
 	AttachmentID string `bson:"attachment_id"`
+	Seq          int64  `bson:"seq"`
 	CreatedAt    int64  `bson:"created_at"`
 }
 
@@ -42,6 +43,7 @@ func ToMessageModel(message Message, attachment Attachment) models.Message {
 		ID:             message.ID,
 		AttachmentType: attachment.Type,
 		AttachmentName: attachment.Name,
+		Seq:            message.Seq,
 		CreatedAt:      message.CreatedAt,
 	}
 }
@@ -0,0 +1,39 @@
+package mongo
+
+import (
+	"air-sync/models"
+)
+
+type OutboxEntry struct {
+	ID           string `bson:"_id"`
+	SessionID    string `bson:"session_id"`
+	Payload      string `bson:"payload"`
+	Position     int64  `bson:"position"`
+	CreatedAt    int64  `bson:"created_at"`
+	ClaimedUntil int64  `bson:"claimed_until"`
+	DeliveredAt  int64  `bson:"delivered_at"`
+}
+
+func FromOutboxEntryModel(entry models.OutboxEntry) OutboxEntry {
+	return OutboxEntry{
+		ID:           entry.ID,
+		SessionID:    entry.SessionID,
+		Payload:      entry.Payload,
+		Position:     entry.Position,
+		CreatedAt:    entry.CreatedAt,
+		ClaimedUntil: entry.ClaimedUntil,
+		DeliveredAt:  entry.DeliveredAt,
+	}
+}
+
+func ToOutboxEntryModel(entry OutboxEntry) models.OutboxEntry {
+	return models.OutboxEntry{
+		ID:           entry.ID,
+		SessionID:    entry.SessionID,
+		Payload:      entry.Payload,
+		Position:     entry.Position,
+		CreatedAt:    entry.CreatedAt,
+		ClaimedUntil: entry.ClaimedUntil,
+		DeliveredAt:  entry.DeliveredAt,
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 type Session struct {
 	ID        string `bson:"id"`
+	Seq       int64  `bson:"seq"`
 	CreatedAt int64  `bson:"created_at"`
 }
 
@@ -22,6 +23,7 @@ func ToSessionModel(session Session, messages []models.Message) models.Session {
 	return models.Session{
 		ID:        session.ID,
 		Messages:  messages,
+		Seq:       session.Seq,
 		CreatedAt: session.CreatedAt,
 	}
 }
@@ -11,7 +11,8 @@ type Attachment struct {
 	Type      string `gorm:"not null"`
 	Mime      string `gorm:"not null"`
 	Name      string `gorm:"not null"`
-	CreatedAt int64  `gorm:"autoCreateTime"`
+	Encoding  string
+	CreatedAt int64 `gorm:"autoCreateTime:milli;index"`
 }
 
 func NewAttachment() Attachment {
@@ -26,15 +27,17 @@ func FromCreateAttachmentModel(create models.CreateAttachment) Attachment {
 	attachment.Type = create.Type
 	attachment.Mime = create.Mime
 	attachment.Name = create.Name
+	attachment.Encoding = create.Encoding
 	return attachment
 }
 
 func ToAttachmentModel(attachment Attachment) models.Attachment {
 	return models.Attachment{
 		BaseAttachment: models.BaseAttachment{
-			Type: attachment.Type,
-			Mime: attachment.Mime,
-			Name: attachment.Name,
+			Type:     attachment.Type,
+			Mime:     attachment.Mime,
+			Name:     attachment.Name,
+			Encoding: attachment.Encoding,
 		},
 		ID:        attachment.ID,
 		CreatedAt: attachment.CreatedAt,
@@ -0,0 +1,67 @@
+package orm
+
+import (
+	"air-sync/models"
+	"encoding/json"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+type CronRun struct {
+	ID        string `gorm:"primaryKey"`
+	Job       string `gorm:"not null;index:idx_cron_runs_job_started,priority:1"`
+	Trigger   string `gorm:"not null"`
+	StartedAt int64  `gorm:"not null;index:idx_cron_runs_job_started,priority:2;index"`
+	EndedAt   int64  `gorm:"not null;default:0"`
+	Outcome   string `gorm:"not null"`
+	Error     string
+	// Counts is the JSON encoded counts of the run
+	Counts string
+}
+
+func NewCronRun(job string, trigger string) CronRun {
+	return CronRun{
+		ID:        uuid.NewV4().String(),
+		Job:       job,
+		Trigger:   trigger,
+		StartedAt: models.Timestamp(),
+		Outcome:   models.CronRunRunning,
+	}
+}
+
+func FromCronRunModel(run models.CronRun) CronRun {
+	counts := ""
+	if len(run.Counts) > 0 {
+		// A map of numbers always encodes
+		b, _ := json.Marshal(run.Counts)
+		counts = string(b)
+	}
+	return CronRun{
+		ID:        run.ID,
+		Job:       run.Job,
+		Trigger:   run.Trigger,
+		StartedAt: run.StartedAt,
+		EndedAt:   run.EndedAt,
+		Outcome:   run.Outcome,
+		Error:     run.Error,
+		Counts:    counts,
+	}
+}
+
+func ToCronRunModel(run CronRun) models.CronRun {
+	var counts map[string]int64
+	if run.Counts != "" {
+		// Only ever written by FromCronRunModel
+		_ = json.Unmarshal([]byte(run.Counts), &counts)
+	}
+	return models.CronRun{
+		ID:        run.ID,
+		Job:       run.Job,
+		Trigger:   run.Trigger,
+		StartedAt: run.StartedAt,
+		EndedAt:   run.EndedAt,
+		Outcome:   run.Outcome,
+		Error:     run.Error,
+		Counts:    counts,
+	}
+}
@@ -0,0 +1,27 @@
+package orm
+
+import (
+	"air-sync/models"
+)
+
+type Lease struct {
+	Name      string `gorm:"primaryKey"`
+	Holder    string `gorm:"not null"`
+	ExpiresAt int64  `gorm:"not null"`
+}
+
+func FromLeaseModel(lease models.Lease) Lease {
+	return Lease{
+		Name:      lease.Name,
+		Holder:    lease.Holder,
+		ExpiresAt: lease.ExpiresAt,
+	}
+}
+
+func ToLeaseModel(lease Lease) models.Lease {
+	return models.Lease{
+		Name:      lease.Name,
+		Holder:    lease.Holder,
+		ExpiresAt: lease.ExpiresAt,
+	}
+}
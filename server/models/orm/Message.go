@@ -8,12 +8,13 @@ import (
 
 type Message struct {
 	ID           string `gorm:"primaryKey"`
-	SessionID    string `gorm:"foreignKey,not null"`
+	SessionID    string `gorm:"not null;index"`
 	Sensitive    bool   `gorm:"not null"`
 	Body         string
-	AttachmentID string
+	AttachmentID string `gorm:"index"`
 	Attachment   Attachment
-	CreatedAt    int64 `gorm:"autoCreateTime"`
+	Seq          int64 `gorm:"not null;default:0;index"`
+	CreatedAt    int64 `gorm:"autoCreateTime:milli;index"`
 }
 
 func NewMessage(sessionID string) Message {
@@ -43,6 +44,7 @@ func ToMessageModel(message Message) models.Message {
 		ID:             message.ID,
 		AttachmentType: message.Attachment.Type,
 		AttachmentName: message.Attachment.Name,
+		Seq:            message.Seq,
 		CreatedAt:      message.CreatedAt,
 	}
 }
@@ -0,0 +1,40 @@
+package orm
+
+import (
+	"air-sync/models"
+)
+
+type OutboxEntry struct {
+	ID           string `gorm:"primaryKey"`
+	SessionID    string `gorm:"not null"`
+	Payload      string `gorm:"not null"`
+	Position     int64  `gorm:"not null;index"`
+	CreatedAt    int64  `gorm:"autoCreateTime:milli"`
+	ClaimedBy    string
+	ClaimedUntil int64 `gorm:"not null;default:0"`
+	DeliveredAt  int64 `gorm:"not null;default:0;index"`
+}
+
+func FromOutboxEntryModel(entry models.OutboxEntry) OutboxEntry {
+	return OutboxEntry{
+		ID:           entry.ID,
+		SessionID:    entry.SessionID,
+		Payload:      entry.Payload,
+		Position:     entry.Position,
+		CreatedAt:    entry.CreatedAt,
+		ClaimedUntil: entry.ClaimedUntil,
+		DeliveredAt:  entry.DeliveredAt,
+	}
+}
+
+func ToOutboxEntryModel(entry OutboxEntry) models.OutboxEntry {
+	return models.OutboxEntry{
+		ID:           entry.ID,
+		SessionID:    entry.SessionID,
+		Payload:      entry.Payload,
+		Position:     entry.Position,
+		CreatedAt:    entry.CreatedAt,
+		ClaimedUntil: entry.ClaimedUntil,
+		DeliveredAt:  entry.DeliveredAt,
+	}
+}
@@ -9,7 +9,8 @@ import (
 type Session struct {
 	ID        string `gorm:"primaryKey"`
 	Messages  []Message
-	CreatedAt int64 `gorm:"autoCreateTime"`
+	Seq       int64 `gorm:"not null;default:0"`
+	CreatedAt int64 `gorm:"autoCreateTime:milli;index"`
 }
 
 func NewSession() Session {
@@ -28,6 +29,7 @@ func ToSessionModel(session Session) models.Session {
 	return models.Session{
 		ID:        session.ID,
 		Messages:  messages,
+		Seq:       session.Seq,
 		CreatedAt: session.CreatedAt,
 	}
 }
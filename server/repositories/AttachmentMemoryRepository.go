@@ -0,0 +1,118 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"context"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+type AttachmentMemoryRepository struct {
+	*MemoryRepository
+}
+
+var _ AttachmentRepository = (*AttachmentMemoryRepository)(nil)
+
+func NewAttachmentMemoryRepository(db *MemoryDatabase) *AttachmentMemoryRepository {
+	return &AttachmentMemoryRepository{NewMemoryRepository(db)}
+}
+
+func (r *AttachmentMemoryRepository) Create(ctx context.Context, arg models.CreateAttachment) (models.Attachment, error) {
+	if err := ctx.Err(); err != nil {
+		return models.EmptyAttachment, err
+	}
+	attachment := models.Attachment{
+		BaseAttachment: arg.BaseAttachment,
+		ID:             uuid.NewV4().String(),
+		CreatedAt:      models.Timestamp(),
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	r.db.attachments[attachment.ID] = attachment
+	return attachment, nil
+}
+
+func (r *AttachmentMemoryRepository) Find(ctx context.Context, id string) (models.Attachment, error) {
+	if err := ctx.Err(); err != nil {
+		return models.EmptyAttachment, err
+	}
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	attachment, ok := r.db.attachments[id]
+	if !ok {
+		return models.EmptyAttachment, ErrAttachmentNotFound
+	}
+	return attachment, nil
+}
+
+func (r *AttachmentMemoryRepository) FindAll(ctx context.Context) ([]models.Attachment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	attachments := make([]models.Attachment, 0, len(r.db.attachments))
+	for _, attachment := range r.db.attachments {
+		attachments = append(attachments, attachment)
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		return attachments[i].CreatedAt < attachments[j].CreatedAt
+	})
+	return attachments, nil
+}
+
+func (r *AttachmentMemoryRepository) FindOrphansBefore(ctx context.Context, t time.Time) ([]models.Attachment, error) {
+	return r.FindReleasedBefore(ctx, t, nil)
+}
+
+func (r *AttachmentMemoryRepository) FindReleasedBefore(ctx context.Context, t time.Time, sessionIds []string) ([]models.Attachment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	deleted := make(map[string]bool, len(sessionIds))
+	for _, id := range sessionIds {
+		deleted[id] = true
+	}
+	deadline := models.FromTime(t)
+	attachments := make([]models.Attachment, 0)
+	for _, attachment := range r.db.attachments {
+		if attachment.CreatedAt >= deadline || r.isAttachmentReferencedOutside(attachment.ID, deleted) {
+			continue
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+func (r *AttachmentMemoryRepository) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if _, ok := r.db.attachments[id]; !ok {
+		return ErrAttachmentNotFound
+	}
+	delete(r.db.attachments, id)
+	return nil
+}
+
+func (r *AttachmentMemoryRepository) DeleteMany(ctx context.Context, ids []string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	n := 0
+	for _, id := range ids {
+		if _, ok := r.db.attachments[id]; ok {
+			delete(r.db.attachments, id)
+			n++
+		}
+	}
+	return n, nil
+}
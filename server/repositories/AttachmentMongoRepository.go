@@ -8,49 +8,39 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const MongoAttachmentCollection = "attachments"
 
 type AttachmentMongoRepository struct {
 	*MongoRepository
-	context     context.Context
 	attachments *mongo.Collection
 }
 
 var _ AttachmentRepository = (*AttachmentMongoRepository)(nil)
-var _ RepositoryMigration = (*AttachmentMongoRepository)(nil)
 
-func NewAttachmentMongoRepository(ctx context.Context, opts MongoOptions) *AttachmentMongoRepository {
+func NewAttachmentMongoRepository(opts MongoOptions) *AttachmentMongoRepository {
 	return &AttachmentMongoRepository{
 		MongoRepository: NewMongoRepository(opts),
-		context:         ctx,
 		attachments:     opts.Database.Collection(MongoAttachmentCollection),
 	}
 }
 
-func (r *AttachmentMongoRepository) Migrate() error {
-	_, err := r.attachments.Indexes().CreateMany(r.context, []mongo.IndexModel{
-		{Keys: bson.M{"id": "hashed"}},
-		{Keys: bson.M{"created_at": 1}},
-	})
-	return err
-}
-
-func (r *AttachmentMongoRepository) Create(arg models.CreateAttachment) (models.Attachment, error) {
+func (r *AttachmentMongoRepository) Create(ctx context.Context, arg models.CreateAttachment) (models.Attachment, error) {
 	attachment := mongoModels.FromCreateAttachmentModel(arg)
-	_, err := r.attachments.InsertOne(r.context, attachment)
+	_, err := r.attachments.InsertOne(ctx, attachment)
 	return mongoModels.ToAttachmentModel(attachment), err
 }
 
-func (r *AttachmentMongoRepository) Find(id string) (models.Attachment, error) {
-	cur, err := r.attachments.Find(r.context, bson.M{"id": id})
+func (r *AttachmentMongoRepository) Find(ctx context.Context, id string) (models.Attachment, error) {
+	cur, err := r.attachments.Find(ctx, bson.M{"id": id})
 	if err != nil {
 		return models.EmptyAttachment, err
 	}
-	defer cur.Close(r.context)
-	if !cur.Next(r.context) {
-		return models.EmptyAttachment, ErrAttachmentNotFound
+	defer cur.Close(ctx)
+	if !cur.Next(ctx) {
+		return models.EmptyAttachment, mongoCursorError(cur, ErrAttachmentNotFound)
 	}
 	attachment := mongoModels.Attachment{}
 	if err := cur.Decode(&attachment); err != nil {
@@ -59,9 +49,33 @@ func (r *AttachmentMongoRepository) Find(id string) (models.Attachment, error) {
 	return mongoModels.ToAttachmentModel(attachment), nil
 }
 
-func (r *AttachmentMongoRepository) FindOrphansBefore(t time.Time) ([]models.Attachment, error) {
+func (r *AttachmentMongoRepository) FindAll(ctx context.Context) ([]models.Attachment, error) {
+	attachments := make([]models.Attachment, 0)
+	cur, err := r.attachments.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return attachments, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		attachment := mongoModels.Attachment{}
+		if err := cur.Decode(&attachment); err != nil {
+			return attachments, err
+		}
+		attachments = append(attachments, mongoModels.ToAttachmentModel(attachment))
+	}
+	return attachments, cur.Err()
+}
+
+func (r *AttachmentMongoRepository) FindOrphansBefore(ctx context.Context, t time.Time) ([]models.Attachment, error) {
+	return r.FindReleasedBefore(ctx, t, nil)
+}
+
+func (r *AttachmentMongoRepository) FindReleasedBefore(ctx context.Context, t time.Time, sessionIds []string) ([]models.Attachment, error) {
+	if sessionIds == nil {
+		sessionIds = make([]string, 0)
+	}
 	attachments := make([]models.Attachment, 0)
-	cur, err := r.attachments.Aggregate(r.context, bson.A{
+	cur, err := r.attachments.Aggregate(ctx, bson.A{
 		bson.M{"$match": bson.M{
 			"created_at": bson.M{"$lt": models.FromTime(t)},
 		}},
@@ -71,24 +85,27 @@ func (r *AttachmentMongoRepository) FindOrphansBefore(t time.Time) ([]models.Att
 			"foreignField": "attachment_id",
 			"as":           "messages",
 		}},
-		bson.M{"$match": bson.M{"messages": bson.A{}}},
+		// Only the messages of the deleted sessions may reference it
+		bson.M{"$match": bson.M{"messages": bson.M{"$not": bson.M{"$elemMatch": bson.M{
+			"session_id": bson.M{"$nin": sessionIds},
+		}}}}},
 	})
 	if err != nil {
 		return attachments, err
 	}
-	defer cur.Close(r.context)
-	for cur.Next(r.context) {
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
 		attachment := mongoModels.Attachment{}
 		if err := cur.Decode(&attachment); err != nil {
 			return attachments, err
 		}
 		attachments = append(attachments, mongoModels.ToAttachmentModel(attachment))
 	}
-	return attachments, nil
+	return attachments, cur.Err()
 }
 
-func (r *AttachmentMongoRepository) Delete(id string) error {
-	res, err := r.attachments.DeleteOne(r.context, bson.M{"id": id})
+func (r *AttachmentMongoRepository) Delete(ctx context.Context, id string) error {
+	res, err := r.attachments.DeleteOne(ctx, bson.M{"id": id})
 	if err != nil {
 		return err
 	} else if res.DeletedCount <= 0 {
@@ -97,8 +114,8 @@ func (r *AttachmentMongoRepository) Delete(id string) error {
 	return nil
 }
 
-func (r *AttachmentMongoRepository) DeleteMany(ids []string) (int, error) {
-	res, err := r.attachments.DeleteMany(r.context, bson.M{
+func (r *AttachmentMongoRepository) DeleteMany(ctx context.Context, ids []string) (int, error) {
+	res, err := r.attachments.DeleteMany(ctx, bson.M{
 		"id": bson.M{"$in": ids},
 	})
 	return int(res.DeletedCount), err
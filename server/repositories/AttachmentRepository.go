@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"air-sync/models"
+	"context"
 	"errors"
 	"time"
 )
@@ -9,9 +10,13 @@ import (
 var ErrAttachmentNotFound = errors.New("Attachment not found")
 
 type AttachmentRepository interface {
-	Create(arg models.CreateAttachment) (models.Attachment, error)
-	Find(id string) (models.Attachment, error)
-	FindOrphansBefore(t time.Time) ([]models.Attachment, error)
-	Delete(id string) error
-	DeleteMany(ids []string) (int, error)
+	Create(ctx context.Context, arg models.CreateAttachment) (models.Attachment, error)
+	Find(ctx context.Context, id string) (models.Attachment, error)
+	FindAll(ctx context.Context) ([]models.Attachment, error)
+	FindOrphansBefore(ctx context.Context, t time.Time) ([]models.Attachment, error)
+	// FindReleasedBefore returns the attachments older than t which would be
+	// orphans once the given sessions are deleted.
+	FindReleasedBefore(ctx context.Context, t time.Time, sessionIds []string) ([]models.Attachment, error)
+	Delete(ctx context.Context, id string) error
+	DeleteMany(ctx context.Context, ids []string) (int, error)
 }
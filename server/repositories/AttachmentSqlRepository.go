@@ -3,6 +3,7 @@ package repositories
 import (
 	"air-sync/models"
 	"air-sync/models/orm"
+	"context"
 	"errors"
 	"time"
 
@@ -14,39 +15,80 @@ type AttachmentSqlRepository struct {
 }
 
 var _ AttachmentRepository = (*AttachmentSqlRepository)(nil)
-var _ RepositoryMigration = (*AttachmentSqlRepository)(nil)
 
 func NewAttachmentSqlRepository(db *gorm.DB) *AttachmentSqlRepository {
 	return &AttachmentSqlRepository{NewSqlRepository(db)}
 }
 
-func (r *AttachmentSqlRepository) Migrate() error {
-	return r.db.AutoMigrate(orm.Attachment{})
-}
-
-func (r *AttachmentSqlRepository) Create(arg models.CreateAttachment) (models.Attachment, error) {
+func (r *AttachmentSqlRepository) Create(ctx context.Context, arg models.CreateAttachment) (models.Attachment, error) {
 	attachment := orm.FromCreateAttachmentModel(arg)
-	err := r.db.Create(&attachment).Error
+	err := r.db.WithContext(ctx).Create(&attachment).Error
 	return orm.ToAttachmentModel(attachment), r.crudError(err)
 }
 
-func (r *AttachmentSqlRepository) Find(id string) (models.Attachment, error) {
+func (r *AttachmentSqlRepository) Find(ctx context.Context, id string) (models.Attachment, error) {
 	attachment := orm.Attachment{}
-	err := r.db.First(&attachment, id).Error
-	return orm.ToAttachmentModel(attachment), r.crudError(err)
+	if err := r.findOne(r.db.WithContext(ctx), &attachment, id, ErrAttachmentNotFound); err != nil {
+		return models.EmptyAttachment, err
+	}
+	return orm.ToAttachmentModel(attachment), nil
 }
 
-func (r *AttachmentSqlRepository) FindOrphansBefore(t time.Time) ([]models.Attachment, error) {
-	return make([]models.Attachment, 0), ErrNotImplemented
+func (r *AttachmentSqlRepository) FindAll(ctx context.Context) ([]models.Attachment, error) {
+	records := make([]orm.Attachment, 0)
+	if err := r.db.WithContext(ctx).Order("created_at").Find(&records).Error; err != nil {
+		return make([]models.Attachment, 0), err
+	}
+	return r.toAttachmentModels(records), nil
 }
 
-func (r *AttachmentSqlRepository) Delete(id string) error {
-	err := r.db.Delete(orm.Attachment{}, id).Error
-	return r.crudError(err)
+func (r *AttachmentSqlRepository) FindOrphansBefore(ctx context.Context, t time.Time) ([]models.Attachment, error) {
+	return r.FindReleasedBefore(ctx, t, nil)
+}
+
+func (r *AttachmentSqlRepository) FindReleasedBefore(ctx context.Context, t time.Time, sessionIds []string) ([]models.Attachment, error) {
+	records := make([]orm.Attachment, 0)
+	references := r.db.
+		Model(orm.Message{}).
+		Select("1").
+		Where("messages.attachment_id = attachments.id")
+	if len(sessionIds) > 0 {
+		references = references.Where("messages.session_id NOT IN ?", sessionIds)
+	}
+	err := r.db.WithContext(ctx).
+		Where("created_at < ?", models.FromTime(t)).
+		Where("NOT EXISTS (?)", references).
+		Find(&records).Error
+	if err != nil {
+		return make([]models.Attachment, 0), err
+	}
+	return r.toAttachmentModels(records), nil
 }
 
-func (r *AttachmentSqlRepository) DeleteMany(ids []string) (int, error) {
-	return 0, ErrNotImplemented
+func (r *AttachmentSqlRepository) Delete(ctx context.Context, id string) error {
+	res := r.db.WithContext(ctx).Where("id = ?", id).Delete(orm.Attachment{})
+	if res.Error != nil {
+		return r.crudError(res.Error)
+	} else if res.RowsAffected <= 0 {
+		return ErrAttachmentNotFound
+	}
+	return nil
+}
+
+func (r *AttachmentSqlRepository) DeleteMany(ctx context.Context, ids []string) (int, error) {
+	if len(ids) <= 0 {
+		return 0, nil
+	}
+	res := r.db.WithContext(ctx).Where("id IN ?", ids).Delete(orm.Attachment{})
+	return int(res.RowsAffected), res.Error
+}
+
+func (r *AttachmentSqlRepository) toAttachmentModels(records []orm.Attachment) []models.Attachment {
+	attachments := make([]models.Attachment, len(records))
+	for idx, attachment := range records {
+		attachments[idx] = orm.ToAttachmentModel(attachment)
+	}
+	return attachments
 }
 
 func (r *AttachmentSqlRepository) crudError(err error) error {
@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"context"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+type CronRunMemoryRepository struct {
+	*MemoryRepository
+}
+
+var _ CronRunRepository = (*CronRunMemoryRepository)(nil)
+
+func NewCronRunMemoryRepository(db *MemoryDatabase) *CronRunMemoryRepository {
+	return &CronRunMemoryRepository{NewMemoryRepository(db)}
+}
+
+func (r *CronRunMemoryRepository) Start(ctx context.Context, job string, trigger string) (models.CronRun, error) {
+	if err := ctx.Err(); err != nil {
+		return models.CronRun{}, err
+	}
+	run := models.CronRun{
+		ID:        uuid.NewV4().String(),
+		Job:       job,
+		Trigger:   trigger,
+		StartedAt: models.Timestamp(),
+		Outcome:   models.CronRunRunning,
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	r.db.cronRuns = append(r.db.cronRuns, run)
+	return run, nil
+}
+
+func (r *CronRunMemoryRepository) Finish(ctx context.Context, run models.CronRun) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for idx, record := range r.db.cronRuns {
+		if record.ID != run.ID {
+			continue
+		}
+		record.EndedAt = run.EndedAt
+		record.Outcome = run.Outcome
+		record.Error = run.Error
+		record.Counts = run.Counts
+		r.db.cronRuns[idx] = record
+		return nil
+	}
+	return ErrCronRunNotFound
+}
+
+func (r *CronRunMemoryRepository) FindRecent(ctx context.Context, job string, limit int) ([]models.CronRun, error) {
+	if err := ctx.Err(); err != nil {
+		return make([]models.CronRun, 0), err
+	}
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	runs := make([]models.CronRun, 0)
+	// Runs are kept in the order they started
+	for idx := len(r.db.cronRuns) - 1; idx >= 0 && len(runs) < limit; idx-- {
+		if run := r.db.cronRuns[idx]; run.Job == job {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+func (r *CronRunMemoryRepository) DeleteBefore(ctx context.Context, t time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	deadline := models.FromTime(t)
+	kept := make([]models.CronRun, 0, len(r.db.cronRuns))
+	for _, run := range r.db.cronRuns {
+		if run.StartedAt >= deadline {
+			kept = append(kept, run)
+		}
+	}
+	n := len(r.db.cronRuns) - len(kept)
+	r.db.cronRuns = kept
+	return n, nil
+}
@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"air-sync/models"
+	mongoModels "air-sync/models/mongo"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const MongoCronRunCollection = "cron_runs"
+
+type CronRunMongoRepository struct {
+	*MongoRepository
+	runs *mongo.Collection
+}
+
+var _ CronRunRepository = (*CronRunMongoRepository)(nil)
+
+func NewCronRunMongoRepository(opts MongoOptions) *CronRunMongoRepository {
+	return &CronRunMongoRepository{
+		MongoRepository: NewMongoRepository(opts),
+		runs:            opts.Database.Collection(MongoCronRunCollection),
+	}
+}
+
+func (r *CronRunMongoRepository) Start(ctx context.Context, job string, trigger string) (models.CronRun, error) {
+	run := mongoModels.NewCronRun(job, trigger)
+	_, err := r.runs.InsertOne(ctx, run)
+	return mongoModels.ToCronRunModel(run), err
+}
+
+func (r *CronRunMongoRepository) Finish(ctx context.Context, run models.CronRun) error {
+	record := mongoModels.FromCronRunModel(run)
+	res, err := r.runs.UpdateOne(
+		ctx,
+		bson.M{"_id": run.ID},
+		bson.M{"$set": bson.M{
+			"ended_at": record.EndedAt,
+			"outcome":  record.Outcome,
+			"error":    record.Error,
+			"counts":   record.Counts,
+		}},
+	)
+	if err != nil {
+		return err
+	} else if res.MatchedCount <= 0 {
+		return ErrCronRunNotFound
+	}
+	return nil
+}
+
+func (r *CronRunMongoRepository) FindRecent(ctx context.Context, job string, limit int) ([]models.CronRun, error) {
+	runs := make([]models.CronRun, 0)
+	cur, err := r.runs.Find(
+		ctx,
+		bson.M{"job": job},
+		options.Find().
+			SetSort(bson.M{"started_at": -1}).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return runs, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		run := mongoModels.CronRun{}
+		if err := cur.Decode(&run); err != nil {
+			return runs, err
+		}
+		runs = append(runs, mongoModels.ToCronRunModel(run))
+	}
+	return runs, cur.Err()
+}
+
+func (r *CronRunMongoRepository) DeleteBefore(ctx context.Context, t time.Time) (int, error) {
+	res, err := r.runs.DeleteMany(ctx, bson.M{
+		"started_at": bson.M{"$lt": models.FromTime(t)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
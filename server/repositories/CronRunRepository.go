@@ -0,0 +1,22 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrCronRunNotFound = errors.New("Cron run not found")
+
+// CronRunRepository keeps the history of the cron job runs.
+type CronRunRepository interface {
+	// Start records a run of the job as running.
+	Start(ctx context.Context, job string, trigger string) (models.CronRun, error)
+	// Finish records the end, outcome, error and counts of the run.
+	Finish(ctx context.Context, run models.CronRun) error
+	// FindRecent returns up to limit runs of the job, the latest first.
+	FindRecent(ctx context.Context, job string, limit int) ([]models.CronRun, error)
+	// DeleteBefore removes the runs started before the given time.
+	DeleteBefore(ctx context.Context, t time.Time) (int, error)
+}
@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"air-sync/models/orm"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type CronRunSqlRepository struct {
+	*SqlRepository
+}
+
+var _ CronRunRepository = (*CronRunSqlRepository)(nil)
+
+func NewCronRunSqlRepository(db *gorm.DB) *CronRunSqlRepository {
+	return &CronRunSqlRepository{NewSqlRepository(db)}
+}
+
+func (r *CronRunSqlRepository) Start(ctx context.Context, job string, trigger string) (models.CronRun, error) {
+	run := orm.NewCronRun(job, trigger)
+	err := r.db.WithContext(ctx).Create(&run).Error
+	return orm.ToCronRunModel(run), err
+}
+
+func (r *CronRunSqlRepository) Finish(ctx context.Context, run models.CronRun) error {
+	record := orm.FromCronRunModel(run)
+	res := r.db.WithContext(ctx).Model(orm.CronRun{}).
+		Where("id = ?", run.ID).
+		Updates(map[string]interface{}{
+			"ended_at": record.EndedAt,
+			"outcome":  record.Outcome,
+			"error":    record.Error,
+			"counts":   record.Counts,
+		})
+	if res.Error != nil {
+		return res.Error
+	} else if res.RowsAffected <= 0 {
+		return ErrCronRunNotFound
+	}
+	return nil
+}
+
+func (r *CronRunSqlRepository) FindRecent(ctx context.Context, job string, limit int) ([]models.CronRun, error) {
+	records := make([]orm.CronRun, 0)
+	err := r.db.WithContext(ctx).
+		Where("job = ?", job).
+		Order("started_at DESC").
+		Limit(limit).
+		Find(&records).Error
+	if err != nil {
+		return make([]models.CronRun, 0), err
+	}
+	runs := make([]models.CronRun, len(records))
+	for idx, record := range records {
+		runs[idx] = orm.ToCronRunModel(record)
+	}
+	return runs, nil
+}
+
+func (r *CronRunSqlRepository) DeleteBefore(ctx context.Context, t time.Time) (int, error) {
+	res := r.db.WithContext(ctx).
+		Where("started_at < ?", models.FromTime(t)).
+		Delete(orm.CronRun{})
+	return int(res.RowsAffected), res.Error
+}
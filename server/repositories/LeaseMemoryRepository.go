@@ -0,0 +1,35 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"context"
+	"time"
+)
+
+type LeaseMemoryRepository struct {
+	*MemoryRepository
+}
+
+var _ LeaseRepository = (*LeaseMemoryRepository)(nil)
+
+func NewLeaseMemoryRepository(db *MemoryDatabase) *LeaseMemoryRepository {
+	return &LeaseMemoryRepository{NewMemoryRepository(db)}
+}
+
+func (r *LeaseMemoryRepository) Acquire(ctx context.Context, name string, holder string, until time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	lease, ok := r.db.leases[name]
+	if ok && lease.Holder != holder && lease.ExpiresAt >= models.Timestamp() {
+		return false, nil
+	}
+	r.db.leases[name] = models.Lease{
+		Name:      name,
+		Holder:    holder,
+		ExpiresAt: models.FromTime(until),
+	}
+	return true, nil
+}
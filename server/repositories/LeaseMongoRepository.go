@@ -0,0 +1,51 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const MongoLeaseCollection = "leases"
+
+type LeaseMongoRepository struct {
+	*MongoRepository
+	leases *mongo.Collection
+}
+
+var _ LeaseRepository = (*LeaseMongoRepository)(nil)
+
+func NewLeaseMongoRepository(opts MongoOptions) *LeaseMongoRepository {
+	return &LeaseMongoRepository{
+		MongoRepository: NewMongoRepository(opts),
+		leases:          opts.Database.Collection(MongoLeaseCollection),
+	}
+}
+
+func (r *LeaseMongoRepository) Acquire(ctx context.Context, name string, holder string, until time.Time) (bool, error) {
+	// A lease held by another holder doesn't match, so the upsert collides
+	// with it on the name instead
+	_, err := r.leases.UpdateOne(
+		ctx,
+		bson.M{
+			"_id": name,
+			"$or": bson.A{
+				bson.M{"holder": holder},
+				bson.M{"expires_at": bson.M{"$lt": models.Timestamp()}},
+			},
+		},
+		bson.M{"$set": bson.M{
+			"holder":     holder,
+			"expires_at": models.FromTime(until),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if isMongoDuplicateKey(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
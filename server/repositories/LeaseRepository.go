@@ -0,0 +1,15 @@
+package repositories
+
+import (
+	"context"
+	"time"
+)
+
+// LeaseRepository hands out named leases, so that among the instances
+// sharing the database only one does the work a lease names at a time.
+type LeaseRepository interface {
+	// Acquire takes the named lease for the holder until the given time,
+	// unless another holder has it and it hasn't expired. Acquiring a lease
+	// the holder has already extends it.
+	Acquire(ctx context.Context, name string, holder string, until time.Time) (bool, error)
+}
@@ -0,0 +1,43 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"air-sync/models/orm"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LeaseSqlRepository struct {
+	*SqlRepository
+}
+
+var _ LeaseRepository = (*LeaseSqlRepository)(nil)
+
+func NewLeaseSqlRepository(db *gorm.DB) *LeaseSqlRepository {
+	return &LeaseSqlRepository{NewSqlRepository(db)}
+}
+
+func (r *LeaseSqlRepository) Acquire(ctx context.Context, name string, holder string, until time.Time) (bool, error) {
+	db := r.db.WithContext(ctx)
+	expiresAt := models.FromTime(until)
+	// Each statement is atomic, so of the instances racing for the lease
+	// only one changes a row
+	res := db.Model(orm.Lease{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", name, holder, models.Timestamp()).
+		Updates(map[string]interface{}{
+			"holder":     holder,
+			"expires_at": expiresAt,
+		})
+	if res.Error != nil || res.RowsAffected > 0 {
+		return res.Error == nil, res.Error
+	}
+	res = db.Clauses(clause.OnConflict{DoNothing: true}).Create(&orm.Lease{
+		Name:      name,
+		Holder:    holder,
+		ExpiresAt: expiresAt,
+	})
+	return res.Error == nil && res.RowsAffected > 0, res.Error
+}
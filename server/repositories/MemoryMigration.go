@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryMigrationStore keeps migration records for the lifetime of the
+// process, which is all an in-memory database needs.
+type MemoryMigrationStore struct {
+	records     map[int]MigrationRecord
+	owner       string
+	lockedUntil time.Time
+	mu          sync.Mutex
+}
+
+var _ MigrationStore = (*MemoryMigrationStore)(nil)
+
+func NewMemoryMigrationStore() *MemoryMigrationStore {
+	return &MemoryMigrationStore{
+		records: make(map[int]MigrationRecord),
+	}
+}
+
+// NewMemoryMigrator returns the migrator of the in-memory database, which
+// has no schema to evolve.
+func NewMemoryMigrator() *Migrator {
+	return NewMigrator(NewMemoryMigrationStore())
+}
+
+func (s *MemoryMigrationStore) Setup(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (s *MemoryMigrationStore) Lock(ctx context.Context, owner string, until time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owner != "" && s.owner != owner && time.Now().Before(s.lockedUntil) {
+		return false, nil
+	}
+	s.owner = owner
+	s.lockedUntil = until
+	return true, nil
+}
+
+func (s *MemoryMigrationStore) Unlock(ctx context.Context, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owner == owner {
+		s.owner = ""
+	}
+	return nil
+}
+
+func (s *MemoryMigrationStore) Applied(ctx context.Context) ([]MigrationRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]MigrationRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *MemoryMigrationStore) Insert(ctx context.Context, record MigrationRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.Version] = record
+	return nil
+}
+
+func (s *MemoryMigrationStore) Remove(ctx context.Context, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, version)
+	return nil
+}
@@ -0,0 +1,69 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"air-sync/models/events"
+	"sync"
+)
+
+type MemoryDatabase struct {
+	sessions    map[string]models.Session
+	messages    map[string][]models.Message
+	attachments map[string]models.Attachment
+	// outbox is kept in the order the entries were recorded
+	outbox []models.OutboxEntry
+	leases map[string]models.Lease
+	// cronRuns is kept in the order the runs started
+	cronRuns []models.CronRun
+	mu       sync.RWMutex
+}
+
+type MemoryRepository struct {
+	db *MemoryDatabase
+}
+
+func NewMemoryDatabase() *MemoryDatabase {
+	return &MemoryDatabase{
+		sessions:    make(map[string]models.Session),
+		messages:    make(map[string][]models.Message),
+		attachments: make(map[string]models.Attachment),
+		outbox:      make([]models.OutboxEntry, 0),
+		leases:      make(map[string]models.Lease),
+		cronRuns:    make([]models.CronRun, 0),
+	}
+}
+
+func NewMemoryRepository(db *MemoryDatabase) *MemoryRepository {
+	return &MemoryRepository{db}
+}
+
+// isAttachmentReferenced must be called with the database lock held.
+func (r *MemoryRepository) isAttachmentReferenced(id string) bool {
+	return r.isAttachmentReferencedOutside(id, nil)
+}
+
+// isAttachmentReferencedOutside ignores the messages of the excluded
+// sessions. It must be called with the database lock held.
+func (r *MemoryRepository) isAttachmentReferencedOutside(id string, excluded map[string]bool) bool {
+	for sessionId, messages := range r.db.messages {
+		if excluded[sessionId] {
+			continue
+		}
+		for _, message := range messages {
+			if message.AttachmentID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recordEvent must be called with the database lock held.
+func (r *MemoryRepository) recordEvent(event events.SessionEvent) error {
+	entry, err := newOutboxEntry(event)
+	if err != nil {
+		return err
+	}
+	r.db.outbox = append(r.db.outbox, entry)
+	return nil
+}
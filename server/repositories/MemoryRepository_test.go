@@ -0,0 +1,73 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDatabase()
+	sessionRepo := NewSessionMemoryRepository(db)
+	attachmentRepo := NewAttachmentMemoryRepository(db)
+
+	attachment, err := attachmentRepo.Create(ctx, models.CreateAttachment{})
+	require.Nil(t, err)
+	orphan, err := attachmentRepo.Create(ctx, models.CreateAttachment{})
+	require.Nil(t, err)
+
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	insert := models.InsertMessage{}
+	insert.AttachmentID = attachment.ID
+	_, err = sessionRepo.InsertMessage(ctx, session.ID, insert)
+	require.Nil(t, err)
+	_, err = sessionRepo.InsertMessage(ctx, session.ID, models.InsertMessage{})
+	require.Nil(t, err)
+
+	found, err := sessionRepo.Find(ctx, session.ID)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(found.Messages))
+	require.GreaterOrEqual(t, found.Messages[0].CreatedAt, found.Messages[1].CreatedAt)
+	require.Empty(t, found.Messages[0].AttachmentID)
+	require.Equal(t, attachment.ID, found.Messages[1].AttachmentID)
+
+	deadline := time.Now().Add(time.Second)
+	sessions, err := sessionRepo.FindBefore(ctx, deadline)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(sessions))
+	orphans, err := attachmentRepo.FindOrphansBefore(ctx, deadline)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(orphans))
+	require.Equal(t, orphan.ID, orphans[0].ID)
+
+	n, err := sessionRepo.DeleteMany(ctx, []string{session.ID})
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+	_, err = sessionRepo.Find(ctx, session.ID)
+	require.Equal(t, ErrSessionNotFound, err)
+	orphans, err = attachmentRepo.FindOrphansBefore(ctx, deadline)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(orphans))
+}
+
+func TestMemoryRepositoryContract(t *testing.T) {
+	runRepositoryContract(t, func(t *testing.T) (SessionRepository, AttachmentRepository) {
+		db := NewMemoryDatabase()
+		return NewSessionMemoryRepository(db), NewAttachmentMemoryRepository(db)
+	})
+	runOutboxContract(t, func(t *testing.T) (SessionRepository, OutboxRepository) {
+		db := NewMemoryDatabase()
+		return NewSessionMemoryRepository(db), NewOutboxMemoryRepository(db)
+	})
+	runLeaseContract(t, func(t *testing.T) LeaseRepository {
+		return NewLeaseMemoryRepository(NewMemoryDatabase())
+	})
+	runCronRunContract(t, func(t *testing.T) CronRunRepository {
+		return NewCronRunMemoryRepository(NewMemoryDatabase())
+	})
+}
@@ -0,0 +1,295 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	MongoMigrationCollection     = "migrations"
+	MongoMigrationLockCollection = "migration_locks"
+
+	mongoMigrationLockID = "migrations"
+	mongoDuplicateKey    = 11000
+)
+
+type MongoMigrationStore struct {
+	migrations *mongo.Collection
+	locks      *mongo.Collection
+}
+
+type mongoMigrationRecord struct {
+	Version     int    `bson:"_id"`
+	Description string `bson:"description"`
+	AppliedAt   int64  `bson:"applied_at"`
+}
+
+var _ MigrationStore = (*MongoMigrationStore)(nil)
+
+func NewMongoMigrationStore(db *mongo.Database) *MongoMigrationStore {
+	return &MongoMigrationStore{
+		migrations: db.Collection(MongoMigrationCollection),
+		locks:      db.Collection(MongoMigrationLockCollection),
+	}
+}
+
+func NewMongoMigrator(opts MongoOptions) *Migrator {
+	db := opts.Database
+	sessions := db.Collection(MongoSessionCollection)
+	messages := db.Collection(MongoMessageCollection)
+	attachments := db.Collection(MongoAttachmentCollection)
+	outbox := db.Collection(MongoOutboxCollection)
+	leases := db.Collection(MongoLeaseCollection)
+	cronRuns := db.Collection(MongoCronRunCollection)
+	return NewMigrator(
+		NewMongoMigrationStore(db),
+		Migration{
+			Version:     1,
+			Description: "Create session, message and attachment collections",
+			Up: func(ctx context.Context) error {
+				_, err := sessions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{Keys: bson.M{"id": "hashed"}},
+					{Keys: bson.M{"created_at": 1}},
+				})
+				if err != nil {
+					return err
+				}
+				_, err = messages.Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{Keys: bson.M{"id": "hashed"}},
+					{Keys: bson.M{"session_id": "hashed"}},
+					{Keys: bson.M{"attachment_id": "hashed"}},
+					{Keys: bson.M{"created_at": 1}},
+				})
+				if err != nil {
+					return err
+				}
+				_, err = attachments.Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{Keys: bson.M{"id": "hashed"}},
+					{Keys: bson.M{"created_at": 1}},
+				})
+				return err
+			},
+			Down: func(ctx context.Context) error {
+				for _, coll := range []*mongo.Collection{sessions, messages, attachments} {
+					if err := coll.Drop(ctx); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		Migration{
+			Version:     2,
+			Description: "Add session sequence numbers to messages",
+			Up: func(ctx context.Context) error {
+				_, err := messages.Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys: bson.D{{Key: "session_id", Value: 1}, {Key: "seq", Value: -1}},
+				})
+				if err != nil {
+					return err
+				}
+				return mongoBackfillSeq(ctx, sessions, messages)
+			},
+			Down: func(ctx context.Context) error {
+				if _, err := messages.Indexes().DropOne(ctx, "session_id_1_seq_-1"); err != nil {
+					return err
+				}
+				for _, coll := range []*mongo.Collection{sessions, messages} {
+					if _, err := coll.UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"seq": ""}}); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		Migration{
+			Version:     3,
+			Description: "Create outbox collection",
+			Up: func(ctx context.Context) error {
+				_, err := outbox.Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys: bson.D{
+						{Key: "delivered_at", Value: 1},
+						{Key: "position", Value: 1},
+					},
+				})
+				return err
+			},
+			Down: func(ctx context.Context) error {
+				return outbox.Drop(ctx)
+			},
+		},
+		Migration{
+			Version:     4,
+			Description: "Create lease collection",
+			Up: func(ctx context.Context) error {
+				// Leases are looked up by name only, which is the _id
+				return db.CreateCollection(ctx, MongoLeaseCollection)
+			},
+			Down: func(ctx context.Context) error {
+				return leases.Drop(ctx)
+			},
+		},
+		Migration{
+			Version:     5,
+			Description: "Create cron run collection",
+			Up: func(ctx context.Context) error {
+				_, err := cronRuns.Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{Keys: bson.D{{Key: "job", Value: 1}, {Key: "started_at", Value: -1}}},
+					{Keys: bson.M{"started_at": 1}},
+				})
+				return err
+			},
+			Down: func(ctx context.Context) error {
+				return cronRuns.Drop(ctx)
+			},
+		},
+	)
+}
+
+// mongoBackfillSeq numbers the existing messages of every session in the
+// order they were created.
+func mongoBackfillSeq(ctx context.Context, sessions *mongo.Collection, messages *mongo.Collection) error {
+	cur, err := sessions.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		session := struct {
+			ID string `bson:"id"`
+		}{}
+		if err := cur.Decode(&session); err != nil {
+			return err
+		}
+		ids, err := mongoSessionMessageIDs(ctx, messages, session.ID)
+		if err != nil {
+			return err
+		}
+		updates := make([]mongo.WriteModel, len(ids))
+		for idx, id := range ids {
+			updates[idx] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"id": id}).
+				SetUpdate(bson.M{"$set": bson.M{"seq": idx + 1}})
+		}
+		if len(updates) > 0 {
+			if _, err := messages.BulkWrite(ctx, updates); err != nil {
+				return err
+			}
+		}
+		_, err = sessions.UpdateOne(ctx, bson.M{"id": session.ID}, bson.M{"$set": bson.M{"seq": len(ids)}})
+		if err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func mongoSessionMessageIDs(ctx context.Context, messages *mongo.Collection, sessionID string) ([]string, error) {
+	cur, err := messages.Find(
+		ctx,
+		bson.M{"session_id": sessionID},
+		options.Find().
+			SetProjection(bson.M{"id": 1}).
+			SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	ids := make([]string, 0)
+	for cur.Next(ctx) {
+		message := struct {
+			ID string `bson:"id"`
+		}{}
+		if err := cur.Decode(&message); err != nil {
+			return nil, err
+		}
+		ids = append(ids, message.ID)
+	}
+	return ids, cur.Err()
+}
+
+func (s *MongoMigrationStore) Setup(ctx context.Context) error {
+	// Collections are created on the first write
+	return nil
+}
+
+func (s *MongoMigrationStore) Lock(ctx context.Context, owner string, until time.Time) (bool, error) {
+	// An expired or owned lock is taken over, while one held by another
+	// owner makes the upsert collide with the existing document
+	_, err := s.locks.UpdateOne(
+		ctx,
+		bson.M{
+			"_id": mongoMigrationLockID,
+			"$or": bson.A{
+				bson.M{"locked_until": bson.M{"$lt": models.Timestamp()}},
+				bson.M{"owner": owner},
+			},
+		},
+		bson.M{"$set": bson.M{
+			"owner":        owner,
+			"locked_until": models.FromTime(until),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if isMongoDuplicateKey(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *MongoMigrationStore) Unlock(ctx context.Context, owner string) error {
+	_, err := s.locks.DeleteOne(ctx, bson.M{
+		"_id":   mongoMigrationLockID,
+		"owner": owner,
+	})
+	return err
+}
+
+func (s *MongoMigrationStore) Applied(ctx context.Context) ([]MigrationRecord, error) {
+	cur, err := s.migrations.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	records := make([]MigrationRecord, 0)
+	for cur.Next(ctx) {
+		record := mongoMigrationRecord{}
+		if err := cur.Decode(&record); err != nil {
+			return nil, err
+		}
+		records = append(records, MigrationRecord(record))
+	}
+	return records, cur.Err()
+}
+
+func (s *MongoMigrationStore) Insert(ctx context.Context, record MigrationRecord) error {
+	_, err := s.migrations.InsertOne(ctx, mongoMigrationRecord(record))
+	return err
+}
+
+func (s *MongoMigrationStore) Remove(ctx context.Context, version int) error {
+	_, err := s.migrations.DeleteOne(ctx, bson.M{"_id": version})
+	return err
+}
+
+func isMongoDuplicateKey(err error) bool {
+	writeErr := mongo.WriteException{}
+	if !errors.As(err, &writeErr) {
+		return false
+	}
+	for _, e := range writeErr.WriteErrors {
+		if e.Code == mongoDuplicateKey {
+			return true
+		}
+	}
+	return false
+}
@@ -1,20 +1,72 @@
 package repositories
 
-import "go.mongodb.org/mongo-driver/mongo"
+import (
+	"air-sync/models/events"
+	mongoModels "air-sync/models/mongo"
+	"context"
+	"errors"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Returned by servers which can't run transactions, such as standalone ones
+const mongoIllegalOperation = 20
 
 type MongoOptions struct {
 	Database *mongo.Database
-	Recreate bool
 }
 
 type MongoRepository struct {
-	db       *mongo.Database
-	recreate bool
+	db *mongo.Database
 }
 
+var warnNoTransactions sync.Once
+
 func NewMongoRepository(opts MongoOptions) *MongoRepository {
 	return &MongoRepository{
-		db:       opts.Database,
-		recreate: opts.Recreate,
+		db: opts.Database,
+	}
+}
+
+// transaction runs fn in a transaction, which may be retried. Deployments
+// without transaction support, like a standalone server, run fn directly.
+func (r *MongoRepository) transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := r.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	cmdErr := mongo.CommandError{}
+	if errors.As(err, &cmdErr) && cmdErr.Code == mongoIllegalOperation {
+		warnNoTransactions.Do(func() {
+			log.Warn("MongoDB deployment doesn't support transactions, running without them")
+		})
+		return fn(ctx)
+	}
+	return err
+}
+
+// recordEvent adds the event to the outbox, within the transaction when
+// called from one.
+func (r *MongoRepository) recordEvent(ctx context.Context, event events.SessionEvent) error {
+	entry, err := newOutboxEntry(event)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Collection(MongoOutboxCollection).InsertOne(ctx, mongoModels.FromOutboxEntryModel(entry))
+	return err
+}
+
+// mongoCursorError returns the error which stopped the cursor, falling back
+// to notFound when the cursor simply ran out of documents.
+func mongoCursorError(cur *mongo.Cursor, notFound error) error {
+	if err := cur.Err(); err != nil {
+		return err
 	}
+	return notFound
 }
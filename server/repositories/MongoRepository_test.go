@@ -1,7 +1,6 @@
 package repositories
 
 import (
-	"air-sync/models"
 	"context"
 	"os"
 	"testing"
@@ -14,7 +13,7 @@ import (
 func TestMongoRepository(t *testing.T) {
 	uri := os.Getenv("MONGODB_URI")
 	if uri == "" {
-		t.Fatal("Requires MONGODB_URI env to test")
+		t.Skip("Requires MONGODB_URI env to test")
 		return
 	}
 	ctx, cancel := context.WithCancel(context.Background())
@@ -22,39 +21,35 @@ func TestMongoRepository(t *testing.T) {
 
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
 	require.Nil(t, err)
+	defer client.Disconnect(ctx)
 	db := client.Database("airsync-test")
-	require.Nil(t, db.Drop(ctx))
 
-	opts := MongoOptions{db, true}
-	sessionRepo := NewSessionMongoRepository(ctx, opts)
-	require.Nil(t, sessionRepo.Migrate())
-	attachmentRepo := NewAttachmentMongoRepository(ctx, opts)
-	require.Nil(t, attachmentRepo.Migrate())
-
-	attachment, err := attachmentRepo.Create(models.CreateAttachment{})
-	require.Nil(t, err)
-
-	session, err := sessionRepo.Create()
-	require.Nil(t, err)
-	_, err = sessionRepo.InsertMessage(session.ID, models.InsertMessage{})
-	require.Nil(t, err)
-
-	session, err = sessionRepo.Create()
-	require.Nil(t, err)
-	insert := models.InsertMessage{}
-	insert.AttachmentID = attachment.ID
-	_, err = sessionRepo.InsertMessage(session.ID, insert)
-	require.Nil(t, err)
-	_, err = sessionRepo.InsertMessage(session.ID, insert)
-	require.Nil(t, err)
-	_, err = sessionRepo.InsertMessage(session.ID, models.InsertMessage{})
-	require.Nil(t, err)
-
-	found, err := sessionRepo.Find(session.ID)
-	require.Nil(t, err)
-	require.Equal(t, session.ID, found.ID)
-	require.Equal(t, 3, len(found.Messages))
-	require.GreaterOrEqual(t, found.Messages[0].CreatedAt, found.Messages[1].CreatedAt)
-	require.Empty(t, found.Messages[0].AttachmentID)
-	require.Equal(t, attachment.ID, found.Messages[1].AttachmentID)
+	runRepositoryContract(t, func(t *testing.T) (SessionRepository, AttachmentRepository) {
+		require.Nil(t, db.Drop(ctx))
+		opts := MongoOptions{db}
+		_, err := NewMongoMigrator(opts).Up(ctx, 0)
+		require.Nil(t, err)
+		return NewSessionMongoRepository(opts), NewAttachmentMongoRepository(opts)
+	})
+	runOutboxContract(t, func(t *testing.T) (SessionRepository, OutboxRepository) {
+		require.Nil(t, db.Drop(ctx))
+		opts := MongoOptions{db}
+		_, err := NewMongoMigrator(opts).Up(ctx, 0)
+		require.Nil(t, err)
+		return NewSessionMongoRepository(opts), NewOutboxMongoRepository(opts)
+	})
+	runLeaseContract(t, func(t *testing.T) LeaseRepository {
+		require.Nil(t, db.Drop(ctx))
+		opts := MongoOptions{db}
+		_, err := NewMongoMigrator(opts).Up(ctx, 0)
+		require.Nil(t, err)
+		return NewLeaseMongoRepository(opts)
+	})
+	runCronRunContract(t, func(t *testing.T) CronRunRepository {
+		require.Nil(t, db.Drop(ctx))
+		opts := MongoOptions{db}
+		_, err := NewMongoMigrator(opts).Up(ctx, 0)
+		require.Nil(t, err)
+		return NewCronRunMongoRepository(opts)
+	})
 }
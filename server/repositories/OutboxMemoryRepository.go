@@ -0,0 +1,95 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"context"
+	"time"
+)
+
+type OutboxMemoryRepository struct {
+	*MemoryRepository
+}
+
+var _ OutboxRepository = (*OutboxMemoryRepository)(nil)
+
+func NewOutboxMemoryRepository(db *MemoryDatabase) *OutboxMemoryRepository {
+	return &OutboxMemoryRepository{NewMemoryRepository(db)}
+}
+
+func (r *OutboxMemoryRepository) Claim(ctx context.Context, until time.Time, limit int) ([]models.OutboxEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	now := models.Timestamp()
+	entries := make([]models.OutboxEntry, 0)
+	for idx, entry := range r.db.outbox {
+		if len(entries) >= limit {
+			break
+		}
+		if entry.DeliveredAt != 0 || entry.ClaimedUntil >= now {
+			continue
+		}
+		entry.ClaimedUntil = models.FromTime(until)
+		r.db.outbox[idx] = entry
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (r *OutboxMemoryRepository) Release(ctx context.Context, ids []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	released := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		released[id] = true
+	}
+	for idx, entry := range r.db.outbox {
+		if released[entry.ID] && entry.DeliveredAt == 0 {
+			r.db.outbox[idx].ClaimedUntil = 0
+		}
+	}
+	return nil
+}
+
+func (r *OutboxMemoryRepository) MarkDelivered(ctx context.Context, ids []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	delivered := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		delivered[id] = true
+	}
+	now := models.Timestamp()
+	for idx, entry := range r.db.outbox {
+		if delivered[entry.ID] && entry.DeliveredAt == 0 {
+			r.db.outbox[idx].DeliveredAt = now
+		}
+	}
+	return nil
+}
+
+func (r *OutboxMemoryRepository) DeleteDelivered(ctx context.Context, t time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	deadline := models.FromTime(t)
+	kept := make([]models.OutboxEntry, 0, len(r.db.outbox))
+	for _, entry := range r.db.outbox {
+		if entry.DeliveredAt != 0 && entry.DeliveredAt < deadline {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	n := len(r.db.outbox) - len(kept)
+	r.db.outbox = kept
+	return n, nil
+}
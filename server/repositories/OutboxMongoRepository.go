@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"air-sync/models"
+	mongoModels "air-sync/models/mongo"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const MongoOutboxCollection = "outbox"
+
+type OutboxMongoRepository struct {
+	*MongoRepository
+	outbox *mongo.Collection
+}
+
+var _ OutboxRepository = (*OutboxMongoRepository)(nil)
+
+func NewOutboxMongoRepository(opts MongoOptions) *OutboxMongoRepository {
+	return &OutboxMongoRepository{
+		MongoRepository: NewMongoRepository(opts),
+		outbox:          opts.Database.Collection(MongoOutboxCollection),
+	}
+}
+
+func (r *OutboxMongoRepository) Claim(ctx context.Context, until time.Time, limit int) ([]models.OutboxEntry, error) {
+	now := models.Timestamp()
+	entries := make([]models.OutboxEntry, 0)
+	// Each entry is claimed atomically, so relays never share one
+	for len(entries) < limit {
+		entry := mongoModels.OutboxEntry{}
+		err := r.outbox.FindOneAndUpdate(
+			ctx,
+			bson.M{
+				"delivered_at":  0,
+				"claimed_until": bson.M{"$lt": now},
+			},
+			bson.M{"$set": bson.M{"claimed_until": models.FromTime(until)}},
+			options.FindOneAndUpdate().
+				SetSort(bson.M{"position": 1}).
+				SetReturnDocument(options.After),
+		).Decode(&entry)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		} else if err != nil {
+			return entries, err
+		}
+		entries = append(entries, mongoModels.ToOutboxEntryModel(entry))
+	}
+	return entries, nil
+}
+
+func (r *OutboxMongoRepository) Release(ctx context.Context, ids []string) error {
+	if len(ids) <= 0 {
+		return nil
+	}
+	_, err := r.outbox.UpdateMany(
+		ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "delivered_at": 0},
+		bson.M{"$set": bson.M{"claimed_until": 0}},
+	)
+	return err
+}
+
+func (r *OutboxMongoRepository) MarkDelivered(ctx context.Context, ids []string) error {
+	if len(ids) <= 0 {
+		return nil
+	}
+	_, err := r.outbox.UpdateMany(
+		ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "delivered_at": 0},
+		bson.M{"$set": bson.M{"delivered_at": models.Timestamp()}},
+	)
+	return err
+}
+
+func (r *OutboxMongoRepository) DeleteDelivered(ctx context.Context, t time.Time) (int, error) {
+	res, err := r.outbox.DeleteMany(ctx, bson.M{
+		"delivered_at": bson.M{"$ne": 0, "$lt": models.FromTime(t)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"air-sync/models/events"
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// lastOutboxPosition is the position of the last entry recorded by the
+// instance.
+var lastOutboxPosition int64
+
+// OutboxRepository keeps the events recorded by the session changes until
+// they're published. Relays claim the pending entries for a while, so two
+// of them rarely publish the same event.
+type OutboxRepository interface {
+	// Claim returns up to limit pending entries in the order they were
+	// recorded, claiming them until the given time.
+	Claim(ctx context.Context, until time.Time, limit int) ([]models.OutboxEntry, error)
+	// Release gives up the claim of the pending entries, so they can be
+	// claimed again right away.
+	Release(ctx context.Context, ids []string) error
+	MarkDelivered(ctx context.Context, ids []string) error
+	// DeleteDelivered removes the entries delivered before the given time.
+	DeleteDelivered(ctx context.Context, t time.Time) (int, error)
+}
+
+// newOutboxEntry encodes the event to record along with the change.
+func newOutboxEntry(event events.SessionEvent) (models.OutboxEntry, error) {
+	b, err := events.EncodePubSubEvent(events.JSONCodec, events.PubSubSessionEvent{
+		SessionEvent: event,
+	})
+	if err != nil {
+		return models.OutboxEntry{}, err
+	}
+	return models.OutboxEntry{
+		ID:        event.ID,
+		SessionID: event.SessionID,
+		Payload:   string(b),
+		Position:  nextOutboxPosition(),
+		CreatedAt: models.Timestamp(),
+	}, nil
+}
+
+// nextOutboxPosition returns the current time in nanoseconds, moved past the
+// last position so entries recorded at once keep their order.
+func nextOutboxPosition() int64 {
+	for {
+		last := atomic.LoadInt64(&lastOutboxPosition)
+		next := time.Now().UnixNano()
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&lastOutboxPosition, last, next) {
+			return next
+		}
+	}
+}
+
+func sessionCreatedEvent(session models.Session) events.SessionEvent {
+	return events.CreateSessionEvent(
+		session.ID, events.EventSessionCreated, events.SessionCreate(session), nil,
+	)
+}
+
+func sessionDeletedEvent(id string) events.SessionEvent {
+	return events.CreateSessionEvent(
+		id, events.EventSessionDeleted, events.SessionDelete(id), nil,
+	)
+}
+
+func messageInsertedEvent(id string, message models.Message) events.SessionEvent {
+	event := events.CreateSessionEvent(
+		id, events.EventMessageInserted, events.MessageInsert{
+			SessionID: id,
+			Message:   message,
+		}, nil,
+	)
+	event.Seq = message.Seq
+	return event
+}
+
+func messageDeletedEvent(id string, messageID string, seq int64) events.SessionEvent {
+	event := events.CreateSessionEvent(
+		id, events.EventMessageDeleted, events.MessageDelete{
+			SessionID: id,
+			MessageID: messageID,
+		}, nil,
+	)
+	event.Seq = seq
+	return event
+}
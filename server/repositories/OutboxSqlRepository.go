@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"air-sync/models/orm"
+	"context"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+type OutboxSqlRepository struct {
+	*SqlRepository
+}
+
+var _ OutboxRepository = (*OutboxSqlRepository)(nil)
+
+func NewOutboxSqlRepository(db *gorm.DB) *OutboxSqlRepository {
+	return &OutboxSqlRepository{NewSqlRepository(db)}
+}
+
+func (r *OutboxSqlRepository) Claim(ctx context.Context, until time.Time, limit int) ([]models.OutboxEntry, error) {
+	db := r.db.WithContext(ctx)
+	now := models.Timestamp()
+	pending := make([]string, 0)
+	err := db.Model(orm.OutboxEntry{}).
+		Where("delivered_at = 0 AND claimed_until < ?", now).
+		Order("position").
+		Limit(limit).
+		Pluck("id", &pending).Error
+	if err != nil || len(pending) <= 0 {
+		return make([]models.OutboxEntry, 0), err
+	}
+	// Entries claimed by another relay meanwhile are left out, which the
+	// token tells apart
+	token := uuid.NewV4().String()
+	err = db.Model(orm.OutboxEntry{}).
+		Where("id IN ? AND delivered_at = 0 AND claimed_until < ?", pending, now).
+		Updates(map[string]interface{}{
+			"claimed_by":    token,
+			"claimed_until": models.FromTime(until),
+		}).Error
+	if err != nil {
+		return make([]models.OutboxEntry, 0), err
+	}
+	records := make([]orm.OutboxEntry, 0)
+	err = db.Where("claimed_by = ?", token).Order("position").Find(&records).Error
+	if err != nil {
+		return make([]models.OutboxEntry, 0), err
+	}
+	entries := make([]models.OutboxEntry, len(records))
+	for idx, record := range records {
+		entries[idx] = orm.ToOutboxEntryModel(record)
+	}
+	return entries, nil
+}
+
+func (r *OutboxSqlRepository) Release(ctx context.Context, ids []string) error {
+	if len(ids) <= 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Model(orm.OutboxEntry{}).
+		Where("id IN ? AND delivered_at = 0", ids).
+		UpdateColumn("claimed_until", 0).Error
+}
+
+func (r *OutboxSqlRepository) MarkDelivered(ctx context.Context, ids []string) error {
+	if len(ids) <= 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Model(orm.OutboxEntry{}).
+		Where("id IN ? AND delivered_at = 0", ids).
+		UpdateColumn("delivered_at", models.Timestamp()).Error
+}
+
+func (r *OutboxSqlRepository) DeleteDelivered(ctx context.Context, t time.Time) (int, error) {
+	res := r.db.WithContext(ctx).
+		Where("delivered_at <> 0 AND delivered_at < ?", models.FromTime(t)).
+		Delete(orm.OutboxEntry{})
+	return int(res.RowsAffected), res.Error
+}
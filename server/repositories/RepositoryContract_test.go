@@ -0,0 +1,740 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"air-sync/models/events"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// repositoryFactory returns an empty pair of repositories sharing a database.
+type repositoryFactory func(t *testing.T) (SessionRepository, AttachmentRepository)
+
+// runRepositoryContract checks the behaviour every repository backend has to
+// share, so the backends can't quietly diverge from each other.
+func runRepositoryContract(t *testing.T, factory repositoryFactory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, sessionRepo SessionRepository, attachmentRepo AttachmentRepository)
+	}{
+		{"InsertAndFind", testContractInsertAndFind},
+		{"NotFound", testContractNotFound},
+		{"Ordering", testContractOrdering},
+		{"DeleteCascade", testContractDeleteCascade},
+		{"DeleteMany", testContractDeleteMany},
+		{"Search", testContractSearch},
+		{"FindBefore", testContractFindBefore},
+		{"Orphans", testContractOrphans},
+		{"Released", testContractReleased},
+		{"ConcurrentInserts", testContractConcurrentInserts},
+		{"Cancelled", testContractCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionRepo, attachmentRepo := factory(t)
+			tt.test(t, sessionRepo, attachmentRepo)
+		})
+	}
+}
+
+func testContractInsertAndFind(t *testing.T, sessionRepo SessionRepository, attachmentRepo AttachmentRepository) {
+	ctx := context.Background()
+	attachment, err := attachmentRepo.Create(ctx, models.NewCreateAttachment("file.txt", "file", "text/plain"))
+	require.Nil(t, err)
+	require.NotEmpty(t, attachment.ID)
+
+	found, err := attachmentRepo.Find(ctx, attachment.ID)
+	require.Nil(t, err)
+	require.Equal(t, attachment, found)
+
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	require.NotEmpty(t, session.ID)
+	require.Empty(t, session.Messages)
+
+	insert := models.InsertMessage{}
+	insert.Body = "Hello"
+	insert.Sensitive = true
+	message, err := sessionRepo.InsertMessage(ctx, session.ID, insert)
+	require.Nil(t, err)
+	require.Equal(t, "Hello", message.Body)
+	require.True(t, message.Sensitive)
+
+	insert = models.InsertMessage{}
+	insert.AttachmentID = attachment.ID
+	message, err = sessionRepo.InsertMessage(ctx, session.ID, insert)
+	require.Nil(t, err)
+	require.Equal(t, attachment.Name, message.AttachmentName)
+	require.Equal(t, attachment.Type, message.AttachmentType)
+
+	res, err := sessionRepo.Find(ctx, session.ID)
+	require.Nil(t, err)
+	require.Equal(t, session.ID, res.ID)
+	require.Equal(t, session.CreatedAt, res.CreatedAt)
+	require.Equal(t, 2, len(res.Messages))
+	for _, m := range res.Messages {
+		if m.AttachmentID == "" {
+			continue
+		}
+		require.Equal(t, attachment.Name, m.AttachmentName)
+		require.Equal(t, attachment.Type, m.AttachmentType)
+	}
+}
+
+func testContractNotFound(t *testing.T, sessionRepo SessionRepository, attachmentRepo AttachmentRepository) {
+	ctx := context.Background()
+	_, err := sessionRepo.Find(ctx, "missing")
+	require.Equal(t, ErrSessionNotFound, err)
+	_, err = sessionRepo.InsertMessage(ctx, "missing", models.InsertMessage{})
+	require.Equal(t, ErrSessionNotFound, err)
+	_, err = sessionRepo.Delete(ctx, "missing")
+	require.Equal(t, ErrSessionNotFound, err)
+
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	insert := models.InsertMessage{}
+	insert.AttachmentID = "missing"
+	_, err = sessionRepo.InsertMessage(ctx, session.ID, insert)
+	require.Equal(t, ErrAttachmentNotFound, err)
+	_, err = sessionRepo.DeleteMessage(ctx, session.ID, "missing")
+	require.Equal(t, ErrMessageNotFound, err)
+
+	// A message can only be deleted through the session it belongs to
+	message, err := sessionRepo.InsertMessage(ctx, session.ID, models.InsertMessage{})
+	require.Nil(t, err)
+	other, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	_, err = sessionRepo.DeleteMessage(ctx, other.ID, message.ID)
+	require.Equal(t, ErrMessageNotFound, err)
+	_, err = sessionRepo.DeleteMessage(ctx, session.ID, message.ID)
+	require.Nil(t, err)
+	_, err = sessionRepo.DeleteMessage(ctx, session.ID, message.ID)
+	require.Equal(t, ErrMessageNotFound, err)
+
+	deleted, err := sessionRepo.Delete(ctx, session.ID)
+	require.Nil(t, err)
+	require.Empty(t, deleted)
+	_, err = sessionRepo.Delete(ctx, session.ID)
+	require.Equal(t, ErrSessionNotFound, err)
+	_, err = sessionRepo.Find(ctx, session.ID)
+	require.Equal(t, ErrSessionNotFound, err)
+
+	_, err = attachmentRepo.Find(ctx, "missing")
+	require.Equal(t, ErrAttachmentNotFound, err)
+	require.Equal(t, ErrAttachmentNotFound, attachmentRepo.Delete(ctx, "missing"))
+}
+
+func testContractOrdering(t *testing.T, sessionRepo SessionRepository, attachmentRepo AttachmentRepository) {
+	ctx := context.Background()
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	require.Zero(t, session.Seq)
+	// Messages inserted within the same millisecond keep their order
+	ids := make([]string, 3)
+	for idx := range ids {
+		message, err := sessionRepo.InsertMessage(ctx, session.ID, models.InsertMessage{})
+		require.Nil(t, err)
+		require.Equal(t, int64(idx+1), message.Seq)
+		ids[idx] = message.ID
+	}
+	res, err := sessionRepo.Find(ctx, session.ID)
+	require.Nil(t, err)
+	require.Equal(t, int64(3), res.Seq)
+	require.Equal(t, 3, len(res.Messages))
+	for idx, message := range res.Messages {
+		require.Equal(t, ids[len(ids)-1-idx], message.ID)
+	}
+
+	// Deletions advance the sequence too, and numbers are never reused
+	seq, err := sessionRepo.DeleteMessage(ctx, session.ID, ids[2])
+	require.Nil(t, err)
+	require.Equal(t, int64(4), seq)
+	message, err := sessionRepo.InsertMessage(ctx, session.ID, models.InsertMessage{})
+	require.Nil(t, err)
+	require.Equal(t, int64(5), message.Seq)
+
+	// Sequences are kept per session
+	other, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	message, err = sessionRepo.InsertMessage(ctx, other.ID, models.InsertMessage{})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), message.Seq)
+}
+
+func testContractDeleteCascade(t *testing.T, sessionRepo SessionRepository, attachmentRepo AttachmentRepository) {
+	ctx := context.Background()
+	owned, err := attachmentRepo.Create(ctx, models.CreateAttachment{})
+	require.Nil(t, err)
+	shared, err := attachmentRepo.Create(ctx, models.CreateAttachment{})
+	require.Nil(t, err)
+	unused, err := attachmentRepo.Create(ctx, models.CreateAttachment{})
+	require.Nil(t, err)
+
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	other, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	for _, attachmentID := range []string{owned.ID, owned.ID, shared.ID, ""} {
+		insert := models.InsertMessage{}
+		insert.AttachmentID = attachmentID
+		_, err := sessionRepo.InsertMessage(ctx, session.ID, insert)
+		require.Nil(t, err)
+	}
+	insert := models.InsertMessage{}
+	insert.AttachmentID = shared.ID
+	_, err = sessionRepo.InsertMessage(ctx, other.ID, insert)
+	require.Nil(t, err)
+
+	// Only attachments left without any message are removed
+	deleted, err := sessionRepo.Delete(ctx, session.ID)
+	require.Nil(t, err)
+	require.Equal(t, []string{owned.ID}, deleted)
+	_, err = attachmentRepo.Find(ctx, owned.ID)
+	require.Equal(t, ErrAttachmentNotFound, err)
+	_, err = attachmentRepo.Find(ctx, shared.ID)
+	require.Nil(t, err)
+	_, err = attachmentRepo.Find(ctx, unused.ID)
+	require.Nil(t, err)
+
+	res, err := sessionRepo.Find(ctx, other.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(res.Messages))
+
+	deleted, err = sessionRepo.Delete(ctx, other.ID)
+	require.Nil(t, err)
+	require.Equal(t, []string{shared.ID}, deleted)
+}
+
+func testContractDeleteMany(t *testing.T, sessionRepo SessionRepository, attachmentRepo AttachmentRepository) {
+	ctx := context.Background()
+	n, err := sessionRepo.DeleteMany(ctx, []string{})
+	require.Nil(t, err)
+	require.Equal(t, 0, n)
+
+	ids := make([]string, 3)
+	for idx := range ids {
+		session, err := sessionRepo.Create(ctx)
+		require.Nil(t, err)
+		_, err = sessionRepo.InsertMessage(ctx, session.ID, models.InsertMessage{})
+		require.Nil(t, err)
+		ids[idx] = session.ID
+	}
+	n, err = sessionRepo.DeleteMany(ctx, []string{ids[0], ids[1], "missing"})
+	require.Nil(t, err)
+	require.Equal(t, 2, n)
+	_, err = sessionRepo.Find(ctx, ids[0])
+	require.Equal(t, ErrSessionNotFound, err)
+	res, err := sessionRepo.Find(ctx, ids[2])
+	require.Nil(t, err)
+	require.Equal(t, 1, len(res.Messages))
+
+	n, err = attachmentRepo.DeleteMany(ctx, []string{})
+	require.Nil(t, err)
+	require.Equal(t, 0, n)
+	a, err := attachmentRepo.Create(ctx, models.CreateAttachment{})
+	require.Nil(t, err)
+	b, err := attachmentRepo.Create(ctx, models.CreateAttachment{})
+	require.Nil(t, err)
+	n, err = attachmentRepo.DeleteMany(ctx, []string{a.ID, b.ID, "missing"})
+	require.Nil(t, err)
+	require.Equal(t, 2, n)
+	n, err = attachmentRepo.DeleteMany(ctx, []string{a.ID})
+	require.Nil(t, err)
+	require.Equal(t, 0, n)
+}
+
+func testContractSearch(t *testing.T, sessionRepo SessionRepository, attachmentRepo AttachmentRepository) {
+	ctx := context.Background()
+	attachment, err := attachmentRepo.Create(ctx, models.NewCreateAttachment("Report_2020.pdf", "file", "application/pdf"))
+	require.Nil(t, err)
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	other, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+
+	insert := func(id string, body string, attachmentID string, sensitive bool) models.Message {
+		// Timestamps have millisecond precision
+		time.Sleep(2 * time.Millisecond)
+		arg := models.InsertMessage{}
+		arg.Body = body
+		arg.AttachmentID = attachmentID
+		arg.Sensitive = sensitive
+		message, err := sessionRepo.InsertMessage(ctx, id, arg)
+		require.Nil(t, err)
+		return message
+	}
+	first := insert(session.ID, "The monthly report is ready", "", false)
+	second := insert(session.ID, "", attachment.ID, false)
+	insert(session.ID, "Secret report password", "", true)
+	insert(session.ID, "Unrelated, 100% sure", "", false)
+	insert(other.ID, "Another report", "", false)
+
+	query := models.SearchQuery{
+		Terms: models.ParseSearchTerms("REPORT"),
+		Limit: models.DefaultSearchLimit,
+	}
+	res, err := sessionRepo.Search(ctx, session.ID, query)
+	require.Nil(t, err)
+	require.Equal(t, 2, res.Total)
+	require.Equal(t, 2, len(res.Matches))
+	require.Equal(t, second.ID, res.Matches[0].Message.ID)
+	require.Equal(t, []models.HighlightSegment{
+		{Text: "Report", Match: true},
+		{Text: "_2020.pdf"},
+	}, res.Matches[0].AttachmentName)
+	require.Equal(t, first.ID, res.Matches[1].Message.ID)
+	require.Equal(t, []models.HighlightSegment{
+		{Text: "The monthly "},
+		{Text: "report", Match: true},
+		{Text: " is ready"},
+	}, res.Matches[1].Body)
+
+	// Every term has to match the same field
+	query.Terms = models.ParseSearchTerms("monthly ready")
+	res, err = sessionRepo.Search(ctx, session.ID, query)
+	require.Nil(t, err)
+	require.Equal(t, 1, res.Total)
+	query.Terms = models.ParseSearchTerms("monthly pdf")
+	res, err = sessionRepo.Search(ctx, session.ID, query)
+	require.Nil(t, err)
+	require.Zero(t, res.Total)
+	require.Empty(t, res.Matches)
+
+	// Wildcards are matched literally
+	query.Terms = models.ParseSearchTerms("_2020")
+	res, err = sessionRepo.Search(ctx, session.ID, query)
+	require.Nil(t, err)
+	require.Equal(t, 1, res.Total)
+	query.Terms = models.ParseSearchTerms("0%")
+	res, err = sessionRepo.Search(ctx, session.ID, query)
+	require.Nil(t, err)
+	require.Equal(t, 1, res.Total)
+	// Terms match within words, in bodies as in attachment names
+	query.Terms = models.ParseSearchTerms("onthly")
+	res, err = sessionRepo.Search(ctx, session.ID, query)
+	require.Nil(t, err)
+	require.Equal(t, 1, res.Total)
+	require.Equal(t, first.ID, res.Matches[0].Message.ID)
+	require.Equal(t, []models.HighlightSegment{
+		{Text: "The m"},
+		{Text: "onthly", Match: true},
+		{Text: " report is ready"},
+	}, res.Matches[0].Body)
+
+	query.Terms = models.ParseSearchTerms("report")
+	query.IncludeSensitive = true
+	res, err = sessionRepo.Search(ctx, session.ID, query)
+	require.Nil(t, err)
+	require.Equal(t, 3, res.Total)
+
+	query.Offset = 1
+	query.Limit = 1
+	res, err = sessionRepo.Search(ctx, session.ID, query)
+	require.Nil(t, err)
+	require.Equal(t, 3, res.Total)
+	require.Equal(t, 1, len(res.Matches))
+	require.Equal(t, second.ID, res.Matches[0].Message.ID)
+	query.Offset = 3
+	res, err = sessionRepo.Search(ctx, session.ID, query)
+	require.Nil(t, err)
+	require.Equal(t, 3, res.Total)
+	require.Empty(t, res.Matches)
+
+	_, err = sessionRepo.Search(ctx, "missing", query)
+	require.Equal(t, ErrSessionNotFound, err)
+}
+
+func testContractFindBefore(t *testing.T, sessionRepo SessionRepository, attachmentRepo AttachmentRepository) {
+	ctx := context.Background()
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	created := contractTime(session.CreatedAt)
+
+	// The deadline is exclusive
+	sessions, err := sessionRepo.FindBefore(ctx, created)
+	require.Nil(t, err)
+	require.Empty(t, sessions)
+
+	sessions, err = sessionRepo.FindBefore(ctx, created.Add(time.Millisecond))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(sessions))
+	require.Equal(t, session.ID, sessions[0].ID)
+	require.Equal(t, session.CreatedAt, sessions[0].CreatedAt)
+}
+
+func testContractOrphans(t *testing.T, sessionRepo SessionRepository, attachmentRepo AttachmentRepository) {
+	ctx := context.Background()
+	referenced, err := attachmentRepo.Create(ctx, models.CreateAttachment{})
+	require.Nil(t, err)
+	orphan, err := attachmentRepo.Create(ctx, models.CreateAttachment{})
+	require.Nil(t, err)
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	insert := models.InsertMessage{}
+	insert.AttachmentID = referenced.ID
+	message, err := sessionRepo.InsertMessage(ctx, session.ID, insert)
+	require.Nil(t, err)
+
+	orphans, err := attachmentRepo.FindOrphansBefore(ctx, contractTime(orphan.CreatedAt))
+	require.Nil(t, err)
+	require.Empty(t, orphans)
+
+	deadline := contractTime(orphan.CreatedAt).Add(time.Millisecond)
+	orphans, err = attachmentRepo.FindOrphansBefore(ctx, deadline)
+	require.Nil(t, err)
+	require.Equal(t, []string{orphan.ID}, contractAttachmentIDs(orphans))
+
+	// Attachments lose their last reference when the message is deleted
+	_, err = sessionRepo.DeleteMessage(ctx, session.ID, message.ID)
+	require.Nil(t, err)
+	orphans, err = attachmentRepo.FindOrphansBefore(ctx, deadline)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{referenced.ID, orphan.ID}, contractAttachmentIDs(orphans))
+}
+
+func testContractReleased(t *testing.T, sessionRepo SessionRepository, attachmentRepo AttachmentRepository) {
+	ctx := context.Background()
+	shared, err := attachmentRepo.Create(ctx, models.CreateAttachment{})
+	require.Nil(t, err)
+	owned, err := attachmentRepo.Create(ctx, models.CreateAttachment{})
+	require.Nil(t, err)
+	expiring, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	kept, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	for _, insert := range []struct {
+		sessionId    string
+		attachmentId string
+	}{
+		{expiring.ID, shared.ID},
+		{expiring.ID, owned.ID},
+		{kept.ID, shared.ID},
+	} {
+		message := models.InsertMessage{}
+		message.AttachmentID = insert.attachmentId
+		_, err := sessionRepo.InsertMessage(ctx, insert.sessionId, message)
+		require.Nil(t, err)
+	}
+	deadline := contractTime(owned.CreatedAt).Add(time.Millisecond)
+
+	released, err := attachmentRepo.FindReleasedBefore(ctx, deadline, []string{expiring.ID})
+	require.Nil(t, err)
+	// The kept session still shows the shared attachment
+	require.Equal(t, []string{owned.ID}, contractAttachmentIDs(released))
+	released, err = attachmentRepo.FindReleasedBefore(ctx, deadline, []string{expiring.ID, kept.ID})
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{shared.ID, owned.ID}, contractAttachmentIDs(released))
+
+	// Deleting the expiring session leaves the same orphans
+	_, err = sessionRepo.DeleteMany(ctx, []string{expiring.ID})
+	require.Nil(t, err)
+	orphans, err := attachmentRepo.FindOrphansBefore(ctx, deadline)
+	require.Nil(t, err)
+	require.Equal(t, []string{owned.ID}, contractAttachmentIDs(orphans))
+}
+
+func testContractConcurrentInserts(t *testing.T, sessionRepo SessionRepository, attachmentRepo AttachmentRepository) {
+	ctx := context.Background()
+	const count = 20
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := sessionRepo.InsertMessage(ctx, session.ID, models.InsertMessage{})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.Nil(t, err)
+	}
+
+	res, err := sessionRepo.Find(ctx, session.ID)
+	require.Nil(t, err)
+	require.Equal(t, count, len(res.Messages))
+	require.Equal(t, int64(count), res.Seq)
+	seen := make(map[string]bool)
+	for idx, message := range res.Messages {
+		require.False(t, seen[message.ID])
+		seen[message.ID] = true
+		require.Equal(t, int64(count-idx), message.Seq)
+	}
+}
+
+func testContractCancelled(t *testing.T, sessionRepo SessionRepository, attachmentRepo AttachmentRepository) {
+	session, err := sessionRepo.Create(context.Background())
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = sessionRepo.Find(ctx, session.ID)
+	require.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	_, err = sessionRepo.InsertMessage(ctx, session.ID, models.InsertMessage{})
+	require.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	_, err = attachmentRepo.Create(ctx, models.CreateAttachment{})
+	require.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+
+	res, err := sessionRepo.Find(context.Background(), session.ID)
+	require.Nil(t, err)
+	require.Empty(t, res.Messages)
+}
+
+func contractTime(ts int64) time.Time {
+	return time.Unix(0, ts*int64(time.Millisecond))
+}
+
+func contractAttachmentIDs(attachments []models.Attachment) []string {
+	ids := make([]string, len(attachments))
+	for idx, attachment := range attachments {
+		ids[idx] = attachment.ID
+	}
+	return ids
+}
+
+// outboxFactory returns an empty pair of repositories sharing a database.
+type outboxFactory func(t *testing.T) (SessionRepository, OutboxRepository)
+
+// runOutboxContract checks every change of the sessions records its event,
+// and the entries are claimed and delivered the same way by each backend.
+func runOutboxContract(t *testing.T, factory outboxFactory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, sessionRepo SessionRepository, outboxRepo OutboxRepository)
+	}{
+		{"Record", testOutboxRecord},
+		{"Claim", testOutboxClaim},
+		{"Deliver", testOutboxDeliver},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionRepo, outboxRepo := factory(t)
+			tt.test(t, sessionRepo, outboxRepo)
+		})
+	}
+}
+
+func testOutboxRecord(t *testing.T, sessionRepo SessionRepository, outboxRepo OutboxRepository) {
+	ctx := context.Background()
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	insert := models.InsertMessage{}
+	insert.Body = "hello"
+	message, err := sessionRepo.InsertMessage(ctx, session.ID, insert)
+	require.Nil(t, err)
+	seq, err := sessionRepo.DeleteMessage(ctx, session.ID, message.ID)
+	require.Nil(t, err)
+	_, err = sessionRepo.Delete(ctx, session.ID)
+	require.Nil(t, err)
+	other, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	_, err = sessionRepo.DeleteMany(ctx, []string{other.ID, "missing"})
+	require.Nil(t, err)
+
+	// Failed changes record nothing
+	_, err = sessionRepo.InsertMessage(ctx, "missing", insert)
+	require.Equal(t, ErrSessionNotFound, err)
+
+	entries, err := outboxRepo.Claim(ctx, time.Now().Add(time.Minute), 100)
+	require.Nil(t, err)
+	recorded := contractOutboxEvents(t, entries)
+	require.Equal(t, []string{
+		events.EventSessionCreated,
+		events.EventMessageInserted,
+		events.EventMessageDeleted,
+		events.EventSessionDeleted,
+		events.EventSessionCreated,
+		events.EventSessionDeleted,
+	}, contractEventNames(recorded))
+
+	require.Equal(t, session.ID, recorded[0].SessionID)
+	inserted, ok := recorded[1].Value.(events.MessageInsert)
+	require.True(t, ok)
+	require.Equal(t, message.ID, inserted.Message.ID)
+	require.Equal(t, "hello", inserted.Message.Body)
+	require.Equal(t, message.Seq, recorded[1].Seq)
+	require.Equal(t, events.MessageDelete{
+		SessionID: session.ID,
+		MessageID: message.ID,
+	}, recorded[2].Value)
+	require.Equal(t, seq, recorded[2].Seq)
+	require.Equal(t, events.SessionDelete(session.ID), recorded[3].Value)
+	require.Equal(t, other.ID, recorded[5].SessionID)
+	for idx, entry := range entries {
+		require.Equal(t, recorded[idx].ID, entry.ID)
+	}
+}
+
+func testOutboxClaim(t *testing.T, sessionRepo SessionRepository, outboxRepo OutboxRepository) {
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := sessionRepo.Create(ctx)
+		require.Nil(t, err)
+	}
+	first, err := outboxRepo.Claim(ctx, time.Now().Add(time.Minute), 2)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(first))
+
+	// Claimed entries are left to their relay
+	second, err := outboxRepo.Claim(ctx, time.Now().Add(time.Minute), 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(second))
+	none, err := outboxRepo.Claim(ctx, time.Now().Add(time.Minute), 10)
+	require.Nil(t, err)
+	require.Empty(t, none)
+
+	// Expired claims are taken over, in the recorded order
+	_, err = sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	expired, err := outboxRepo.Claim(ctx, time.Now().Add(-time.Second), 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(expired))
+	again, err := outboxRepo.Claim(ctx, time.Now().Add(time.Minute), 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(again))
+	require.Equal(t, expired[0].ID, again[0].ID)
+	require.Less(t, second[0].Position, again[0].Position)
+
+	// Released entries are claimed again right away
+	require.Nil(t, outboxRepo.Release(ctx, []string{first[1].ID}))
+	released, err := outboxRepo.Claim(ctx, time.Now().Add(time.Minute), 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(released))
+	require.Equal(t, first[1].ID, released[0].ID)
+}
+
+func testOutboxDeliver(t *testing.T, sessionRepo SessionRepository, outboxRepo OutboxRepository) {
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		_, err := sessionRepo.Create(ctx)
+		require.Nil(t, err)
+	}
+	require.Nil(t, outboxRepo.MarkDelivered(ctx, []string{}))
+	entries, err := outboxRepo.Claim(ctx, time.Now().Add(-time.Second), 10)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(entries))
+	require.Nil(t, outboxRepo.MarkDelivered(ctx, []string{entries[0].ID, "missing"}))
+
+	// Delivered entries are never claimed again
+	pending, err := outboxRepo.Claim(ctx, time.Now().Add(time.Minute), 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(pending))
+	require.Equal(t, entries[1].ID, pending[0].ID)
+
+	n, err := outboxRepo.DeleteDelivered(ctx, time.Now().Add(-time.Minute))
+	require.Nil(t, err)
+	require.Equal(t, 0, n)
+	n, err = outboxRepo.DeleteDelivered(ctx, time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+
+	// Pending entries are kept
+	require.Nil(t, outboxRepo.MarkDelivered(ctx, []string{entries[1].ID}))
+	n, err = outboxRepo.DeleteDelivered(ctx, time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+}
+
+func contractOutboxEvents(t *testing.T, entries []models.OutboxEntry) []events.SessionEvent {
+	recorded := make([]events.SessionEvent, len(entries))
+	for idx, entry := range entries {
+		event, err := events.DecodePubSubEvent(events.JSONCodec, []byte(entry.Payload))
+		require.Nil(t, err)
+		recorded[idx] = event.SessionEvent
+	}
+	return recorded
+}
+
+func contractEventNames(recorded []events.SessionEvent) []string {
+	names := make([]string, len(recorded))
+	for idx, event := range recorded {
+		names[idx] = event.Event
+	}
+	return names
+}
+
+type leaseFactory func(t *testing.T) LeaseRepository
+
+// runLeaseContract checks a lease is held by a single holder at a time with
+// each backend.
+func runLeaseContract(t *testing.T, factory leaseFactory) {
+	t.Run("Acquire", func(t *testing.T) {
+		ctx := context.Background()
+		leaseRepo := factory(t)
+		ok, err := leaseRepo.Acquire(ctx, "job", "first", time.Now().Add(time.Minute))
+		require.Nil(t, err)
+		require.True(t, ok)
+
+		// The holder extends its lease, others wait for it to expire
+		ok, err = leaseRepo.Acquire(ctx, "job", "second", time.Now().Add(time.Minute))
+		require.Nil(t, err)
+		require.False(t, ok)
+		ok, err = leaseRepo.Acquire(ctx, "job", "first", time.Now().Add(-time.Second))
+		require.Nil(t, err)
+		require.True(t, ok)
+		ok, err = leaseRepo.Acquire(ctx, "job", "second", time.Now().Add(time.Minute))
+		require.Nil(t, err)
+		require.True(t, ok)
+		ok, err = leaseRepo.Acquire(ctx, "job", "first", time.Now().Add(time.Minute))
+		require.Nil(t, err)
+		require.False(t, ok)
+
+		// Leases of other names are independent
+		ok, err = leaseRepo.Acquire(ctx, "other", "first", time.Now().Add(time.Minute))
+		require.Nil(t, err)
+		require.True(t, ok)
+	})
+}
+
+type cronRunFactory func(t *testing.T) CronRunRepository
+
+// runCronRunContract checks the history of the cron jobs is kept the same
+// way by each backend.
+func runCronRunContract(t *testing.T, factory cronRunFactory) {
+	t.Run("History", func(t *testing.T) {
+		ctx := context.Background()
+		runRepo := factory(t)
+		first, err := runRepo.Start(ctx, "cleanup", models.CronTriggerSchedule)
+		require.Nil(t, err)
+		require.NotEmpty(t, first.ID)
+		require.Equal(t, models.CronRunRunning, first.Outcome)
+		first.EndedAt = models.Timestamp()
+		first.Outcome = models.CronRunSucceeded
+		first.Counts = map[string]int64{"sessions": 2}
+		require.Nil(t, runRepo.Finish(ctx, first))
+		time.Sleep(2 * time.Millisecond)
+		second, err := runRepo.Start(ctx, "cleanup", models.CronTriggerRequest)
+		require.Nil(t, err)
+		_, err = runRepo.Start(ctx, "other", models.CronTriggerRequest)
+		require.Nil(t, err)
+
+		missing := first
+		missing.ID = "missing"
+		require.Equal(t, ErrCronRunNotFound, runRepo.Finish(ctx, missing))
+
+		// The latest runs of the job come first
+		runs, err := runRepo.FindRecent(ctx, "cleanup", 10)
+		require.Nil(t, err)
+		require.Equal(t, []models.CronRun{second, first}, runs)
+		runs, err = runRepo.FindRecent(ctx, "cleanup", 1)
+		require.Nil(t, err)
+		require.Equal(t, []models.CronRun{second}, runs)
+
+		n, err := runRepo.DeleteBefore(ctx, time.Now().Add(-time.Minute))
+		require.Nil(t, err)
+		require.Equal(t, 0, n)
+		n, err = runRepo.DeleteBefore(ctx, time.Now().Add(time.Minute))
+		require.Nil(t, err)
+		require.Equal(t, 3, n)
+	})
+}
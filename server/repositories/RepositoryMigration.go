@@ -1,5 +1,285 @@
 package repositories
 
-type RepositoryMigration interface {
-	Migrate() error
+import (
+	"air-sync/models"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	migrationLockTTL      = 10 * time.Minute
+	migrationLockInterval = 1 * time.Second
+)
+
+var (
+	ErrMigrationNotFound = errors.New("Migration not found")
+	ErrMigrationLocked   = errors.New("Migration lock is held by another instance")
+)
+
+// Migration is a single versioned schema change. Versions are applied in
+// increasing order, and reverted in decreasing order.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+	Down        func(ctx context.Context) error
+}
+
+type MigrationRecord struct {
+	Version     int
+	Description string
+	AppliedAt   int64
+}
+
+type MigrationStatus struct {
+	Version     int
+	Description string
+	// AppliedAt is zero for pending migrations
+	AppliedAt int64
+	// Unknown is set for applied migrations missing from this build
+	Unknown bool
+}
+
+// MigrationStore records the applied migrations of a database, and provides
+// the lock guarding instances from migrating concurrently.
+type MigrationStore interface {
+	Setup(ctx context.Context) error
+	// Lock tries to take the lock for owner, returning false when another
+	// owner holds an unexpired lock. The owner takes it again to renew it.
+	Lock(ctx context.Context, owner string, until time.Time) (bool, error)
+	Unlock(ctx context.Context, owner string) error
+	Applied(ctx context.Context) ([]MigrationRecord, error)
+	Insert(ctx context.Context, record MigrationRecord) error
+	Remove(ctx context.Context, version int) error
+}
+
+type Migrator struct {
+	store      MigrationStore
+	migrations []Migration
+	lockTTL    time.Duration
+}
+
+func NewMigrator(store MigrationStore, migrations ...Migration) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for idx, migration := range sorted {
+		if migration.Version <= 0 || (idx > 0 && sorted[idx-1].Version == migration.Version) {
+			panic(fmt.Sprintf("Invalid migration version: %d", migration.Version))
+		}
+	}
+	return &Migrator{
+		store:      store,
+		migrations: sorted,
+		lockTTL:    migrationLockTTL,
+	}
+}
+
+// Latest returns the version of the newest known migration.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) <= 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.store.Setup(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{
+			Version:     migration.Version,
+			Description: migration.Description,
+		}
+		if record, ok := applied[migration.Version]; ok {
+			status.AppliedAt = record.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range applied {
+		statuses = append(statuses, MigrationStatus{
+			Version:     record.Version,
+			Description: record.Description,
+			AppliedAt:   record.AppliedAt,
+			Unknown:     true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// Up applies the pending migrations up to and including the target version,
+// or every pending migration when target isn't positive.
+func (m *Migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	if target <= 0 {
+		target = m.Latest()
+	}
+	done := make([]Migration, 0)
+	err := m.withLock(ctx, func() error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if migration.Version > target {
+				break
+			} else if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			m.log(migration).Info("Applying migration")
+			if err := migration.Up(ctx); err != nil {
+				return fmt.Errorf("Migration %d failed: %w", migration.Version, err)
+			}
+			err := m.store.Insert(ctx, MigrationRecord{
+				Version:     migration.Version,
+				Description: migration.Description,
+				AppliedAt:   models.Timestamp(),
+			})
+			if err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the applied migrations newer than the target version.
+func (m *Migrator) Down(ctx context.Context, target int) ([]Migration, error) {
+	done := make([]Migration, 0)
+	err := m.withLock(ctx, func() error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(applied))
+		for version := range applied {
+			if version > target {
+				versions = append(versions, version)
+			}
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+		for _, version := range versions {
+			migration, ok := m.find(version)
+			if !ok {
+				return fmt.Errorf("Can't revert migration %d: %w", version, ErrMigrationNotFound)
+			}
+			m.log(migration).Info("Reverting migration")
+			if err := migration.Down(ctx); err != nil {
+				return fmt.Errorf("Reverting migration %d failed: %w", version, err)
+			}
+			if err := m.store.Remove(ctx, version); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func() error) error {
+	if err := m.store.Setup(ctx); err != nil {
+		return err
+	}
+	owner := uuid.NewV4().String()
+	for waited := false; ; waited = true {
+		ok, err := m.store.Lock(ctx, owner, time.Now().Add(m.lockTTL))
+		if err != nil {
+			return err
+		} else if ok {
+			break
+		}
+		if !waited {
+			log.Info("Waiting for another instance to finish migrating")
+		}
+		select {
+		case <-time.After(migrationLockInterval):
+		case <-ctx.Done():
+			return ErrMigrationLocked
+		}
+	}
+	// Migrations may take longer than the lock lasts, so it is renewed
+	// until they are done
+	renewing, stop := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.renewLock(renewing, owner)
+	}()
+	defer func() {
+		stop()
+		wg.Wait()
+		// The lock is released even when the migration was cancelled
+		if err := m.store.Unlock(context.Background(), owner); err != nil {
+			log.Error(err)
+		}
+	}()
+	return fn()
+}
+
+func (m *Migrator) renewLock(ctx context.Context, owner string) {
+	ticker := time.NewTicker(m.lockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ok, err := m.store.Lock(ctx, owner, time.Now().Add(m.lockTTL))
+			if err != nil && ctx.Err() == nil {
+				log.Errorf("Failed to renew the migration lock: %v", err)
+			} else if err == nil && !ok {
+				log.Error("Migration lock was taken over by another instance")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]MigrationRecord, error) {
+	records, err := m.store.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]MigrationRecord, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+func (m *Migrator) log(migration Migration) *log.Entry {
+	return log.WithFields(log.Fields{
+		"version":     migration.Version,
+		"description": migration.Description,
+	})
 }
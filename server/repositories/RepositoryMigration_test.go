@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryMigrator(t *testing.T) {
+	testMigrator(t, NewMemoryMigrationStore())
+}
+
+func TestSqlMigrator(t *testing.T) {
+	testMigrator(t, NewSqlMigrationStore(openSqliteTestDB(t)))
+}
+
+func testMigrator(t *testing.T, store MigrationStore) {
+	ctx := context.Background()
+	steps := make([]string, 0)
+	migration := func(version int) Migration {
+		return Migration{
+			Version:     version,
+			Description: "Test migration",
+			Up: func(ctx context.Context) error {
+				steps = append(steps, "up", string(rune('0'+version)))
+				return nil
+			},
+			Down: func(ctx context.Context) error {
+				steps = append(steps, "down", string(rune('0'+version)))
+				return nil
+			},
+		}
+	}
+	migrator := NewMigrator(store, migration(3), migration(1), migration(2))
+	require.Equal(t, 3, migrator.Latest())
+
+	statuses, err := migrator.Status(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(statuses))
+	for idx, status := range statuses {
+		require.Equal(t, idx+1, status.Version)
+		require.Zero(t, status.AppliedAt)
+	}
+
+	done, err := migrator.Up(ctx, 2)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(done))
+	done, err = migrator.Up(ctx, 0)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(done))
+	done, err = migrator.Up(ctx, 0)
+	require.Nil(t, err)
+	require.Empty(t, done)
+	require.Equal(t, []string{"up", "1", "up", "2", "up", "3"}, steps)
+
+	statuses, err = migrator.Status(ctx)
+	require.Nil(t, err)
+	for _, status := range statuses {
+		require.NotZero(t, status.AppliedAt)
+	}
+
+	steps = steps[:0]
+	done, err = migrator.Down(ctx, 1)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(done))
+	require.Equal(t, []string{"down", "3", "down", "2"}, steps)
+
+	// A failing migration stops without being recorded
+	failing := migration(2)
+	failing.Up = func(ctx context.Context) error {
+		return errors.New("failed")
+	}
+	_, err = NewMigrator(store, migration(1), failing, migration(3)).Up(ctx, 0)
+	require.NotNil(t, err)
+	statuses, err = migrator.Status(ctx)
+	require.Nil(t, err)
+	require.NotZero(t, statuses[0].AppliedAt)
+	require.Zero(t, statuses[1].AppliedAt)
+
+	// Applied migrations missing from the build can't be reverted
+	_, err = migrator.Up(ctx, 0)
+	require.Nil(t, err)
+	older := NewMigrator(store, migration(1), migration(2))
+	statuses, err = older.Status(ctx)
+	require.Nil(t, err)
+	require.True(t, statuses[2].Unknown)
+	_, err = older.Down(ctx, 0)
+	require.True(t, errors.Is(err, ErrMigrationNotFound))
+
+	// Instances wait for the lock held by another one
+	ok, err := store.Lock(ctx, "other", time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	require.True(t, ok)
+	ok, err = store.Lock(ctx, "another", time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	require.False(t, ok)
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = migrator.Down(timeout, 0)
+	require.Equal(t, ErrMigrationLocked, err)
+	require.Nil(t, store.Unlock(ctx, "other"))
+
+	// Expired locks are taken over
+	ok, err = store.Lock(ctx, "other", time.Now().Add(-time.Second))
+	require.Nil(t, err)
+	require.True(t, ok)
+	done, err = migrator.Down(ctx, 0)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(done))
+
+	// Owners renew their lock
+	ok, err = store.Lock(ctx, "other", time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	require.True(t, ok)
+	ok, err = store.Lock(ctx, "other", time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Nil(t, store.Unlock(ctx, "other"))
+
+	// Migrations outlasting the lock keep it
+	slow := migration(1)
+	slow.Up = func(ctx context.Context) error {
+		time.Sleep(300 * time.Millisecond)
+		ok, err := store.Lock(ctx, "other", time.Now().Add(time.Minute))
+		require.Nil(t, err)
+		require.False(t, ok)
+		return nil
+	}
+	migrator = NewMigrator(store, slow)
+	migrator.lockTTL = 100 * time.Millisecond
+	done, err = migrator.Up(ctx, 0)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(done))
+}
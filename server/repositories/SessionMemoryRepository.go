@@ -0,0 +1,205 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"context"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+type SessionMemoryRepository struct {
+	*MemoryRepository
+}
+
+var _ SessionRepository = (*SessionMemoryRepository)(nil)
+
+func NewSessionMemoryRepository(db *MemoryDatabase) *SessionMemoryRepository {
+	return &SessionMemoryRepository{NewMemoryRepository(db)}
+}
+
+func (r *SessionMemoryRepository) Create(ctx context.Context) (models.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return models.EmptySession, err
+	}
+	session := models.NewSession()
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if err := r.recordEvent(sessionCreatedEvent(session)); err != nil {
+		return models.EmptySession, err
+	}
+	r.db.sessions[session.ID] = models.Session{
+		ID:        session.ID,
+		CreatedAt: session.CreatedAt,
+	}
+	return session, nil
+}
+
+func (r *SessionMemoryRepository) Find(ctx context.Context, id string) (models.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return models.EmptySession, err
+	}
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	session, ok := r.db.sessions[id]
+	if !ok {
+		return models.EmptySession, ErrSessionNotFound
+	}
+	stored := r.db.messages[id]
+	messages := make([]models.Message, len(stored))
+	for idx, message := range stored {
+		messages[idx] = r.withAttachment(message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Seq > messages[j].Seq
+	})
+	session.Messages = messages
+	return session, nil
+}
+
+func (r *SessionMemoryRepository) FindBefore(ctx context.Context, t time.Time) ([]models.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	deadline := models.FromTime(t)
+	sessions := make([]models.Session, 0)
+	for _, session := range r.db.sessions {
+		if session.CreatedAt < deadline {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (r *SessionMemoryRepository) Search(ctx context.Context, id string, query models.SearchQuery) (models.SearchResult, error) {
+	session, err := r.Find(ctx, id)
+	if err != nil {
+		return models.SearchResult{}, err
+	}
+	matches := make([]models.SearchMatch, 0)
+	for _, message := range session.Messages {
+		if message.Sensitive && !query.IncludeSensitive {
+			continue
+		}
+		if models.MatchesSearchTerms(message.Body, query.Terms) ||
+			models.MatchesSearchTerms(message.AttachmentName, query.Terms) {
+			matches = append(matches, models.NewSearchMatch(message, query.Terms))
+		}
+	}
+	return pageSearchMatches(matches, query), nil
+}
+
+func (r *SessionMemoryRepository) InsertMessage(ctx context.Context, id string, arg models.InsertMessage) (models.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return models.EmptyMessage, err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	session, ok := r.db.sessions[id]
+	if !ok {
+		return models.EmptyMessage, ErrSessionNotFound
+	}
+	if arg.AttachmentID != "" {
+		if _, ok := r.db.attachments[arg.AttachmentID]; !ok {
+			return models.EmptyMessage, ErrAttachmentNotFound
+		}
+	}
+	session.Seq++
+	message := models.Message{
+		BaseMessage: arg.BaseMessage,
+		ID:          uuid.NewV4().String(),
+		Seq:         session.Seq,
+		CreatedAt:   models.Timestamp(),
+	}
+	inserted := r.withAttachment(message)
+	if err := r.recordEvent(messageInsertedEvent(id, inserted)); err != nil {
+		return models.EmptyMessage, err
+	}
+	r.db.sessions[id] = session
+	r.db.messages[id] = append(r.db.messages[id], message)
+	return inserted, nil
+}
+
+func (r *SessionMemoryRepository) DeleteMessage(ctx context.Context, id string, messageID string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	messages := r.db.messages[id]
+	for idx, message := range messages {
+		if message.ID != messageID {
+			continue
+		}
+		session := r.db.sessions[id]
+		session.Seq++
+		if err := r.recordEvent(messageDeletedEvent(id, messageID, session.Seq)); err != nil {
+			return 0, err
+		}
+		r.db.messages[id] = append(messages[:idx:idx], messages[idx+1:]...)
+		r.db.sessions[id] = session
+		return session.Seq, nil
+	}
+	return 0, ErrMessageNotFound
+}
+
+func (r *SessionMemoryRepository) Delete(ctx context.Context, id string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if _, ok := r.db.sessions[id]; !ok {
+		return nil, ErrSessionNotFound
+	}
+	if err := r.recordEvent(sessionDeletedEvent(id)); err != nil {
+		return nil, err
+	}
+	messages := r.db.messages[id]
+	delete(r.db.messages, id)
+	delete(r.db.sessions, id)
+	deleted := make([]string, 0)
+	for _, message := range messages {
+		attachmentID := message.AttachmentID
+		if _, ok := r.db.attachments[attachmentID]; !ok || r.isAttachmentReferenced(attachmentID) {
+			continue
+		}
+		delete(r.db.attachments, attachmentID)
+		deleted = append(deleted, attachmentID)
+	}
+	return deleted, nil
+}
+
+func (r *SessionMemoryRepository) DeleteMany(ctx context.Context, ids []string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	n := 0
+	for _, id := range ids {
+		if _, ok := r.db.sessions[id]; !ok {
+			delete(r.db.messages, id)
+			continue
+		}
+		if err := r.recordEvent(sessionDeletedEvent(id)); err != nil {
+			return n, err
+		}
+		delete(r.db.messages, id)
+		delete(r.db.sessions, id)
+		n++
+	}
+	return n, nil
+}
+
+// withAttachment must be called with the database lock held.
+func (r *SessionMemoryRepository) withAttachment(message models.Message) models.Message {
+	if attachment, ok := r.db.attachments[message.AttachmentID]; ok {
+		message.AttachmentType = attachment.Type
+		message.AttachmentName = attachment.Name
+	}
+	return message
+}
@@ -4,10 +4,14 @@ import (
 	"air-sync/models"
 	mongoModels "air-sync/models/mongo"
 	"context"
+	"errors"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -17,7 +21,6 @@ const (
 
 type SessionMongoRepository struct {
 	*MongoRepository
-	context     context.Context
 	sessions    *mongo.Collection
 	messages    *mongo.Collection
 	attachments *mongo.Collection
@@ -34,51 +37,30 @@ type mongoSessionQuery struct {
 }
 
 var _ SessionRepository = (*SessionMongoRepository)(nil)
-var _ RepositoryMigration = (*SessionMongoRepository)(nil)
 
-func NewSessionMongoRepository(ctx context.Context, opts MongoOptions) *SessionMongoRepository {
+func NewSessionMongoRepository(opts MongoOptions) *SessionMongoRepository {
 	return &SessionMongoRepository{
 		MongoRepository: NewMongoRepository(opts),
-		context:         ctx,
 		sessions:        opts.Database.Collection(MongoSessionCollection),
 		messages:        opts.Database.Collection(MongoMessageCollection),
 		attachments:     opts.Database.Collection(MongoAttachmentCollection),
 	}
 }
 
-func (r *SessionMongoRepository) Migrate() error {
-	{
-		_, err := r.sessions.Indexes().CreateMany(r.context, []mongo.IndexModel{
-			{Keys: bson.M{"id": "hashed"}},
-			{Keys: bson.M{"created_at": 1}},
-		})
-		if err != nil {
-			return err
-		}
-	}
-	{
-		_, err := r.messages.Indexes().CreateMany(r.context, []mongo.IndexModel{
-			{Keys: bson.M{"id": "hashed"}},
-			{Keys: bson.M{"session_id": "hashed"}},
-			{Keys: bson.M{"attachment_id": "hashed"}},
-			{Keys: bson.M{"created_at": 1}},
-		})
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (r *SessionMongoRepository) Create() (models.Session, error) {
+func (r *SessionMongoRepository) Create(ctx context.Context) (models.Session, error) {
 	session := mongoModels.NewSession()
-	_, err := r.sessions.InsertOne(r.context, session)
 	messages := make([]models.Message, 0)
+	err := r.transaction(ctx, func(ctx context.Context) error {
+		if _, err := r.sessions.InsertOne(ctx, session); err != nil {
+			return err
+		}
+		return r.recordEvent(ctx, sessionCreatedEvent(mongoModels.ToSessionModel(session, messages)))
+	})
 	return mongoModels.ToSessionModel(session, messages), err
 }
 
-func (r *SessionMongoRepository) Find(id string) (models.Session, error) {
-	cur, err := r.sessions.Aggregate(r.context, bson.A{
+func (r *SessionMongoRepository) Find(ctx context.Context, id string) (models.Session, error) {
+	cur, err := r.sessions.Aggregate(ctx, bson.A{
 		bson.M{"$match": bson.M{"id": id}},
 		bson.M{"$lookup": bson.M{
 			"from": MongoMessageCollection,
@@ -87,7 +69,7 @@ func (r *SessionMongoRepository) Find(id string) (models.Session, error) {
 				bson.M{"$match": bson.M{"$expr": bson.M{
 					"$eq": bson.A{"$session_id", "$$session_id"},
 				}}},
-				bson.M{"$sort": bson.M{"created_at": -1}},
+				bson.M{"$sort": bson.M{"seq": -1}},
 				bson.M{"$lookup": bson.M{
 					"from":         MongoAttachmentCollection,
 					"localField":   "attachment_id",
@@ -105,9 +87,9 @@ func (r *SessionMongoRepository) Find(id string) (models.Session, error) {
 	if err != nil {
 		return models.EmptySession, err
 	}
-	defer cur.Close(r.context)
-	if !cur.Next(r.context) {
-		return models.EmptySession, ErrSessionNotFound
+	defer cur.Close(ctx)
+	if !cur.Next(ctx) {
+		return models.EmptySession, mongoCursorError(cur, ErrSessionNotFound)
 	}
 	session := mongoSessionQuery{}
 	if err := cur.Decode(&session); err != nil {
@@ -120,112 +102,292 @@ func (r *SessionMongoRepository) Find(id string) (models.Session, error) {
 	return mongoModels.ToSessionModel(session.Session, messages), nil
 }
 
-func (r *SessionMongoRepository) FindBefore(t time.Time) ([]models.Session, error) {
+func (r *SessionMongoRepository) FindBefore(ctx context.Context, t time.Time) ([]models.Session, error) {
 	sessions := make([]models.Session, 0)
-	cur, err := r.sessions.Find(r.context, bson.M{
+	cur, err := r.sessions.Find(ctx, bson.M{
 		"created_at": bson.M{"$lt": models.FromTime(t)},
 	})
 	if err != nil {
 		return sessions, err
 	}
-	defer cur.Close(r.context)
-	for cur.Next(r.context) {
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
 		session := mongoModels.Session{}
 		if err := cur.Decode(&session); err != nil {
 			return sessions, err
 		}
 		sessions = append(sessions, mongoModels.ToSessionModel(session, nil))
 	}
-	return sessions, nil
+	return sessions, cur.Err()
+}
+
+func (r *SessionMongoRepository) Search(ctx context.Context, id string, query models.SearchQuery) (models.SearchResult, error) {
+	n, err := r.sessions.CountDocuments(ctx, bson.M{"id": id})
+	if err != nil {
+		return models.SearchResult{}, err
+	} else if n <= 0 {
+		return models.SearchResult{}, ErrSessionNotFound
+	}
+	match := bson.M{"session_id": id}
+	if !query.IncludeSensitive {
+		match["sensitive"] = false
+	}
+	body := mongoSearchCondition("body", query.Terms)
+	cur, err := r.messages.Aggregate(ctx, bson.A{
+		// Only the messages with an attachment are left to match by name
+		bson.M{"$match": bson.M{"$and": bson.A{match, bson.M{"$or": bson.A{
+			body,
+			bson.M{"attachment_id": bson.M{"$gt": ""}},
+		}}}}},
+		bson.M{"$lookup": bson.M{
+			"from":         MongoAttachmentCollection,
+			"localField":   "attachment_id",
+			"foreignField": "id",
+			"as":           "attachment",
+		}},
+		bson.M{"$unwind": bson.M{
+			"path":                       "$attachment",
+			"preserveNullAndEmptyArrays": true,
+		}},
+		bson.M{"$match": bson.M{"$or": bson.A{
+			body,
+			mongoSearchCondition("attachment.name", query.Terms),
+		}}},
+		bson.M{"$sort": bson.M{"seq": -1}},
+		bson.M{"$facet": bson.M{
+			"total": bson.A{bson.M{"$count": "count"}},
+			"messages": bson.A{
+				bson.M{"$skip": query.Offset},
+				bson.M{"$limit": query.Limit},
+			},
+		}},
+	})
+	if err != nil {
+		return models.SearchResult{}, err
+	}
+	defer cur.Close(ctx)
+	if !cur.Next(ctx) {
+		return models.SearchResult{}, mongoCursorError(cur, ErrSessionNotFound)
+	}
+	page := struct {
+		Total []struct {
+			Count int `bson:"count"`
+		} `bson:"total"`
+		Messages []mongoMessageQuery `bson:"messages"`
+	}{}
+	if err := cur.Decode(&page); err != nil {
+		return models.SearchResult{}, err
+	}
+	result := models.SearchResult{
+		Offset:  query.Offset,
+		Limit:   query.Limit,
+		Matches: make([]models.SearchMatch, len(page.Messages)),
+	}
+	if len(page.Total) > 0 {
+		result.Total = page.Total[0].Count
+	}
+	for idx, message := range page.Messages {
+		model := mongoModels.ToMessageModel(message.Message, message.Attachment)
+		result.Matches[idx] = models.NewSearchMatch(model, query.Terms)
+	}
+	return result, nil
+}
+
+// mongoSearchCondition matches a field containing every term, ignoring case.
+func mongoSearchCondition(field string, terms []string) bson.M {
+	conds := make(bson.A, len(terms))
+	for idx, term := range terms {
+		conds[idx] = bson.M{field: primitive.Regex{
+			Pattern: regexp.QuoteMeta(term),
+			Options: "i",
+		}}
+	}
+	return bson.M{"$and": conds}
 }
 
-func (r *SessionMongoRepository) InsertMessage(id string, arg models.InsertMessage) (models.Message, error) {
-	cur, err := r.sessions.Find(r.context, bson.M{"id": id})
+func (r *SessionMongoRepository) InsertMessage(ctx context.Context, id string, arg models.InsertMessage) (models.Message, error) {
+	cur, err := r.sessions.Find(ctx, bson.M{"id": id})
 	if err != nil {
 		return models.EmptyMessage, err
 	}
-	defer cur.Close(r.context)
-	if !cur.TryNext(r.context) {
-		return models.EmptyMessage, ErrSessionNotFound
+	defer cur.Close(ctx)
+	if !cur.TryNext(ctx) {
+		return models.EmptyMessage, mongoCursorError(cur, ErrSessionNotFound)
 	}
 	attachment := mongoModels.EmptyAttachment
 	if arg.AttachmentID != "" {
-		res, err := r.FindOneAttachment(arg.AttachmentID)
+		res, err := r.FindOneAttachment(ctx, arg.AttachmentID)
 		if err != nil {
 			return models.EmptyMessage, err
 		}
 		attachment = res
 	}
 	message := mongoModels.FromInsertMessageModel(id, arg)
-	if _, err := r.messages.InsertOne(r.context, message); err != nil {
+	err = r.transaction(ctx, func(ctx context.Context) error {
+		seq, err := r.nextSeq(ctx, id)
+		if err != nil {
+			return err
+		}
+		message.Seq = seq
+		if _, err := r.messages.InsertOne(ctx, message); err != nil {
+			return err
+		}
+		return r.recordEvent(ctx, messageInsertedEvent(id, mongoModels.ToMessageModel(message, attachment)))
+	})
+	if err != nil {
 		return models.EmptyMessage, err
 	}
-	return mongoModels.ToMessageModel(message, attachment), err
+	return mongoModels.ToMessageModel(message, attachment), nil
 }
 
-func (r *SessionMongoRepository) DeleteMessage(id string, messageID string) error {
-	res, err := r.messages.DeleteOne(r.context, bson.M{"id": messageID, "session_id": id})
-	if err != nil {
-		return err
-	} else if res.DeletedCount <= 0 {
-		return ErrMessageNotFound
+func (r *SessionMongoRepository) DeleteMessage(ctx context.Context, id string, messageID string) (int64, error) {
+	seq := int64(0)
+	err := r.transaction(ctx, func(ctx context.Context) error {
+		res, err := r.messages.DeleteOne(ctx, bson.M{"id": messageID, "session_id": id})
+		if err != nil {
+			return err
+		} else if res.DeletedCount <= 0 {
+			return ErrMessageNotFound
+		}
+		seq, err = r.nextSeq(ctx, id)
+		if err != nil {
+			return err
+		}
+		return r.recordEvent(ctx, messageDeletedEvent(id, messageID, seq))
+	})
+	return seq, err
+}
+
+// nextSeq atomically advances the sequence number of the session.
+func (r *SessionMongoRepository) nextSeq(ctx context.Context, id string) (int64, error) {
+	session := mongoModels.Session{}
+	err := r.sessions.FindOneAndUpdate(
+		ctx,
+		bson.M{"id": id},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, ErrSessionNotFound
 	}
-	return nil
+	return session.Seq, err
 }
 
-func (r *SessionMongoRepository) FindOneAttachment(id string) (mongoModels.Attachment, error) {
-	cur, err := r.attachments.Find(r.context, bson.M{"id": id})
+func (r *SessionMongoRepository) FindOneAttachment(ctx context.Context, id string) (mongoModels.Attachment, error) {
+	cur, err := r.attachments.Find(ctx, bson.M{"id": id})
 	if err != nil {
 		return mongoModels.EmptyAttachment, err
 	}
-	defer cur.Close(r.context)
-	if !cur.Next(r.context) {
-		return mongoModels.EmptyAttachment, ErrAttachmentNotFound
+	defer cur.Close(ctx)
+	if !cur.Next(ctx) {
+		return mongoModels.EmptyAttachment, mongoCursorError(cur, ErrAttachmentNotFound)
 	}
 	attachment := mongoModels.Attachment{}
 	return attachment, cur.Decode(&attachment)
 }
 
-func (r *SessionMongoRepository) FindAttachments(ids bson.A) (map[string]mongoModels.Attachment, error) {
+func (r *SessionMongoRepository) FindAttachments(ctx context.Context, ids bson.A) (map[string]mongoModels.Attachment, error) {
 	resultMap := make(map[string]mongoModels.Attachment)
-	cur, err := r.attachments.Find(r.context, bson.M{"id": bson.M{"$in": ids}})
+	cur, err := r.attachments.Find(ctx, bson.M{"id": bson.M{"$in": ids}})
 	if err != nil {
 		return resultMap, err
 	}
-	defer cur.Close(r.context)
-	for cur.Next(r.context) {
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
 		attachment := mongoModels.Attachment{}
 		if err := cur.Decode(&attachment); err != nil {
 			return resultMap, err
 		}
 		resultMap[attachment.ID] = attachment
 	}
-	return resultMap, nil
+	return resultMap, cur.Err()
 }
 
-func (r *SessionMongoRepository) Delete(id string) error {
-	_, err := r.messages.DeleteMany(r.context, bson.M{"session_id": id})
-	if err != nil {
-		return err
-	}
-	res, err := r.sessions.DeleteOne(r.context, bson.M{"id": id})
+func (r *SessionMongoRepository) Delete(ctx context.Context, id string) ([]string, error) {
+	var deleted []string
+	err := r.transaction(ctx, func(ctx context.Context) error {
+		res, err := r.sessions.DeleteOne(ctx, bson.M{"id": id})
+		if err != nil {
+			return err
+		} else if res.DeletedCount <= 0 {
+			return ErrSessionNotFound
+		}
+		if err := r.recordEvent(ctx, sessionDeletedEvent(id)); err != nil {
+			return err
+		}
+		referenced, err := r.messages.Distinct(ctx, "attachment_id", bson.M{
+			"session_id":    id,
+			"attachment_id": bson.M{"$nin": bson.A{nil, ""}},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := r.messages.DeleteMany(ctx, bson.M{"session_id": id}); err != nil {
+			return err
+		}
+		deleted = make([]string, 0)
+		if len(referenced) <= 0 {
+			return nil
+		}
+		// Attachments may be shared with messages of other sessions
+		shared, err := r.messages.Distinct(ctx, "attachment_id", bson.M{
+			"attachment_id": bson.M{"$in": referenced},
+		})
+		if err != nil {
+			return err
+		}
+		orphans, err := r.attachments.Distinct(ctx, "id", bson.M{
+			"id": bson.M{"$in": referenced, "$nin": shared},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := r.attachments.DeleteMany(ctx, bson.M{"id": bson.M{"$in": orphans}}); err != nil {
+			return err
+		}
+		for _, v := range orphans {
+			if id, ok := v.(string); ok {
+				deleted = append(deleted, id)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return err
-	} else if res.DeletedCount <= 0 {
-		return ErrSessionNotFound
+		return nil, err
 	}
-	return nil
+	return deleted, nil
 }
 
-func (r *SessionMongoRepository) DeleteMany(ids []string) (int, error) {
-	_, err := r.messages.DeleteMany(r.context, bson.M{
-		"session_id": bson.M{"$in": ids},
-	})
-	if err != nil {
-		return 0, err
-	}
-	res, err := r.sessions.DeleteMany(r.context, bson.M{
-		"id": bson.M{"$in": ids},
+func (r *SessionMongoRepository) DeleteMany(ctx context.Context, ids []string) (int, error) {
+	n := 0
+	err := r.transaction(ctx, func(ctx context.Context) error {
+		existing, err := r.sessions.Distinct(ctx, "id", bson.M{
+			"id": bson.M{"$in": ids},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = r.messages.DeleteMany(ctx, bson.M{
+			"session_id": bson.M{"$in": ids},
+		})
+		if err != nil {
+			return err
+		}
+		res, err := r.sessions.DeleteMany(ctx, bson.M{
+			"id": bson.M{"$in": ids},
+		})
+		if err != nil {
+			return err
+		}
+		n = int(res.DeletedCount)
+		for _, v := range existing {
+			if id, ok := v.(string); ok {
+				if err := r.recordEvent(ctx, sessionDeletedEvent(id)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
 	})
-	return int(res.DeletedCount), err
+	return n, err
 }
@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"air-sync/models"
+	"context"
 	"errors"
 	"time"
 )
@@ -12,11 +13,37 @@ var (
 )
 
 type SessionRepository interface {
-	Create() (models.Session, error)
-	Find(id string) (models.Session, error)
-	FindBefore(t time.Time) ([]models.Session, error)
-	InsertMessage(id string, model models.InsertMessage) (models.Message, error)
-	DeleteMessage(id string, messageId string) error
-	Delete(id string) error
-	DeleteMany(ids []string) (int, error)
+	Create(ctx context.Context) (models.Session, error)
+	Find(ctx context.Context, id string) (models.Session, error)
+	FindBefore(ctx context.Context, t time.Time) ([]models.Session, error)
+	// Search matches the messages whose body or attachment name contains
+	// every term, ignoring case, on every backend.
+	Search(ctx context.Context, id string, query models.SearchQuery) (models.SearchResult, error)
+	// InsertMessage takes the next sequence number of the session for the
+	// message, which DeleteMessage also advances and returns.
+	InsertMessage(ctx context.Context, id string, model models.InsertMessage) (models.Message, error)
+	DeleteMessage(ctx context.Context, id string, messageId string) (int64, error)
+	// Delete removes the session and its messages, along with the attachments
+	// no longer referenced by any message. It returns the IDs of the removed
+	// attachments so their stored objects can be deleted too.
+	Delete(ctx context.Context, id string) ([]string, error)
+	DeleteMany(ctx context.Context, ids []string) (int, error)
+}
+
+// pageSearchMatches returns the requested page of all the matches.
+func pageSearchMatches(matches []models.SearchMatch, query models.SearchQuery) models.SearchResult {
+	result := models.SearchResult{
+		Total:   len(matches),
+		Offset:  query.Offset,
+		Limit:   query.Limit,
+		Matches: make([]models.SearchMatch, 0),
+	}
+	if query.Offset < len(matches) {
+		end := query.Offset + query.Limit
+		if end > len(matches) {
+			end = len(matches)
+		}
+		result.Matches = matches[query.Offset:end]
+	}
+	return result
 }
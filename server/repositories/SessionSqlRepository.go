@@ -3,70 +3,250 @@ package repositories
 import (
 	"air-sync/models"
 	"air-sync/models/orm"
+	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var sqlLikeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
 type SessionSqlRepository struct {
 	*SqlRepository
 }
 
 var _ SessionRepository = (*SessionSqlRepository)(nil)
-var _ RepositoryMigration = (*SessionSqlRepository)(nil)
 
 func NewSessionSqlRepository(db *gorm.DB) *SessionSqlRepository {
 	return &SessionSqlRepository{NewSqlRepository(db)}
 }
 
-func (r *SessionSqlRepository) Migrate() error {
-	if err := r.db.AutoMigrate(orm.Session{}); err != nil {
-		return err
-	}
-	if err := r.db.AutoMigrate(orm.Message{}); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (r *SessionSqlRepository) Create() (models.Session, error) {
+func (r *SessionSqlRepository) Create(ctx context.Context) (models.Session, error) {
 	session := orm.NewSession()
-	err := r.db.Create(&session).Error
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(&session).Error; err != nil {
+			return err
+		}
+		return r.recordEvent(tx, sessionCreatedEvent(orm.ToSessionModel(session)))
+	})
 	return orm.ToSessionModel(session), r.sessionCrudError(err)
 }
 
-func (r *SessionSqlRepository) Find(id string) (models.Session, error) {
+func (r *SessionSqlRepository) Find(ctx context.Context, id string) (models.Session, error) {
 	session := orm.Session{}
-	err := r.db.First(&session, id).Error
-	return orm.ToSessionModel(session), r.sessionCrudError(err)
+	db := r.db.WithContext(ctx).
+		Preload("Messages", func(db *gorm.DB) *gorm.DB {
+			return db.Order("seq DESC")
+		}).
+		Preload("Messages.Attachment")
+	if err := r.findOne(db, &session, id, ErrSessionNotFound); err != nil {
+		return models.EmptySession, err
+	}
+	return orm.ToSessionModel(session), nil
 }
 
-func (r *SessionSqlRepository) FindBefore(t time.Time) ([]models.Session, error) {
-	return nil, ErrNotImplemented
+func (r *SessionSqlRepository) FindBefore(ctx context.Context, t time.Time) ([]models.Session, error) {
+	records := make([]orm.Session, 0)
+	err := r.db.WithContext(ctx).Where("created_at < ?", models.FromTime(t)).Find(&records).Error
+	if err != nil {
+		return make([]models.Session, 0), err
+	}
+	sessions := make([]models.Session, len(records))
+	for idx, session := range records {
+		sessions[idx] = orm.ToSessionModel(session)
+	}
+	return sessions, nil
 }
 
-func (r *SessionSqlRepository) InsertMessage(id string, arg models.InsertMessage) (models.Message, error) {
+func (r *SessionSqlRepository) Search(ctx context.Context, id string, query models.SearchQuery) (models.SearchResult, error) {
+	db := r.db.WithContext(ctx)
+	if err := r.findOne(db, &orm.Session{}, id, ErrSessionNotFound); err != nil {
+		return models.SearchResult{}, err
+	}
+	matching := func(db *gorm.DB) *gorm.DB {
+		db = db.Model(orm.Message{}).
+			Joins("LEFT JOIN attachments ON attachments.id = messages.attachment_id").
+			Where("messages.session_id = ?", id)
+		if !query.IncludeSensitive {
+			db = db.Where("messages.sensitive = ?", false)
+		}
+		body, bodyArgs := sqlSearchCondition("messages.body", query.Terms)
+		name, nameArgs := sqlSearchCondition("attachments.name", query.Terms)
+		return db.Where("("+body+") OR ("+name+")", append(bodyArgs, nameArgs...)...)
+	}
+	total := int64(0)
+	if err := db.Scopes(matching).Count(&total).Error; err != nil {
+		return models.SearchResult{}, err
+	}
+	records := make([]orm.Message, 0)
+	err := db.Scopes(matching).
+		Select("messages.*").
+		Preload("Attachment").
+		Order("messages.seq DESC").
+		Offset(query.Offset).
+		Limit(query.Limit).
+		Find(&records).Error
+	if err != nil {
+		return models.SearchResult{}, err
+	}
+	matches := make([]models.SearchMatch, len(records))
+	for idx, message := range records {
+		matches[idx] = models.NewSearchMatch(orm.ToMessageModel(message), query.Terms)
+	}
+	return models.SearchResult{
+		Total:   int(total),
+		Offset:  query.Offset,
+		Limit:   query.Limit,
+		Matches: matches,
+	}, nil
+}
+
+func (r *SessionSqlRepository) InsertMessage(ctx context.Context, id string, arg models.InsertMessage) (models.Message, error) {
 	message := orm.FromInsertMessageModel(id, arg)
-	err := r.db.Create(&message).Error
-	return orm.ToMessageModel(message), r.messageCrudError(err)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		seq, err := r.nextSeq(tx, id)
+		if err != nil {
+			return err
+		}
+		message.Seq = seq
+		if arg.AttachmentID != "" {
+			err := r.findOne(tx, &message.Attachment, arg.AttachmentID, ErrAttachmentNotFound)
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Omit(clause.Associations).Create(&message).Error; err != nil {
+			return err
+		}
+		return r.recordEvent(tx, messageInsertedEvent(id, orm.ToMessageModel(message)))
+	})
+	if err != nil {
+		return models.EmptyMessage, r.messageCrudError(err)
+	}
+	return orm.ToMessageModel(message), nil
 }
 
-func (r *SessionSqlRepository) DeleteMessage(id string, messageID string) error {
-	err := r.db.Delete(orm.Message{
-		ID:        messageID,
-		SessionID: id,
-	}).Error
-	return r.messageCrudError(err)
+func (r *SessionSqlRepository) DeleteMessage(ctx context.Context, id string, messageID string) (int64, error) {
+	seq := int64(0)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ? AND session_id = ?", messageID, id).Delete(orm.Message{})
+		if res.Error != nil {
+			return r.messageCrudError(res.Error)
+		} else if res.RowsAffected <= 0 {
+			return ErrMessageNotFound
+		}
+		var err error
+		seq, err = r.nextSeq(tx, id)
+		if err != nil {
+			return err
+		}
+		return r.recordEvent(tx, messageDeletedEvent(id, messageID, seq))
+	})
+	return seq, err
 }
 
-func (r *SessionSqlRepository) Delete(id string) error {
-	err := r.db.Delete(orm.Session{}, id).Error
-	return r.sessionCrudError(err)
+func (r *SessionSqlRepository) Delete(ctx context.Context, id string) ([]string, error) {
+	deleted := make([]string, 0)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ?", id).Delete(orm.Session{})
+		if res.Error != nil {
+			return r.sessionCrudError(res.Error)
+		} else if res.RowsAffected <= 0 {
+			return ErrSessionNotFound
+		}
+		if err := r.recordEvent(tx, sessionDeletedEvent(id)); err != nil {
+			return err
+		}
+		referenced := make([]string, 0)
+		err := tx.Model(orm.Message{}).
+			Distinct().
+			Where("session_id = ? AND attachment_id <> ''", id).
+			Pluck("attachment_id", &referenced).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("session_id = ?", id).Delete(orm.Message{}).Error; err != nil {
+			return err
+		}
+		if len(referenced) <= 0 {
+			return nil
+		}
+		// Attachments may be shared with messages of other sessions
+		err = tx.Model(orm.Attachment{}).
+			Where("id IN ?", referenced).
+			Where("NOT EXISTS (?)", r.db.
+				Model(orm.Message{}).
+				Select("1").
+				Where("messages.attachment_id = attachments.id"),
+			).
+			Pluck("id", &deleted).Error
+		if err != nil || len(deleted) <= 0 {
+			return err
+		}
+		return tx.Where("id IN ?", deleted).Delete(orm.Attachment{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func (r *SessionSqlRepository) DeleteMany(ctx context.Context, ids []string) (int, error) {
+	if len(ids) <= 0 {
+		return 0, nil
+	}
+	n := 0
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		existing := make([]string, 0)
+		if err := tx.Model(orm.Session{}).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("session_id IN ?", ids).Delete(orm.Message{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("id IN ?", ids).Delete(orm.Session{})
+		if res.Error != nil {
+			return res.Error
+		}
+		n = int(res.RowsAffected)
+		for _, id := range existing {
+			if err := r.recordEvent(tx, sessionDeletedEvent(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, err
 }
 
-func (r *SessionSqlRepository) DeleteMany(ids []string) (int, error) {
-	return 0, ErrNotImplemented
+// nextSeq advances the sequence number of the session, holding its row lock
+// until the transaction ends.
+func (r *SessionSqlRepository) nextSeq(tx *gorm.DB, id string) (int64, error) {
+	res := tx.Model(orm.Session{}).Where("id = ?", id).UpdateColumn("seq", gorm.Expr("seq + 1"))
+	if res.Error != nil {
+		return 0, res.Error
+	} else if res.RowsAffected <= 0 {
+		return 0, ErrSessionNotFound
+	}
+	session := orm.Session{}
+	if err := tx.Select("seq").Where("id = ?", id).Take(&session).Error; err != nil {
+		return 0, r.sessionCrudError(err)
+	}
+	return session.Seq, nil
+}
+
+// sqlSearchCondition matches a column containing every term, ignoring case.
+func sqlSearchCondition(column string, terms []string) (string, []interface{}) {
+	conds := make([]string, len(terms))
+	args := make([]interface{}, len(terms))
+	for idx, term := range terms {
+		conds[idx] = "LOWER(" + column + ") LIKE ? ESCAPE '\\'"
+		args[idx] = "%" + sqlLikeEscaper.Replace(term) + "%"
+	}
+	return strings.Join(conds, " AND "), args
 }
 
 func (r *SessionSqlRepository) sessionCrudError(err error) error {
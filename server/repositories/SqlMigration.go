@@ -0,0 +1,270 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const sqlMigrationLockID = "migrations"
+
+type SqlMigrationStore struct {
+	db *gorm.DB
+}
+
+type sqlMigrationRecord struct {
+	Version     int `gorm:"primaryKey;autoIncrement:false"`
+	Description string
+	AppliedAt   int64
+}
+
+var _ MigrationStore = (*SqlMigrationStore)(nil)
+
+func (sqlMigrationRecord) TableName() string {
+	return "schema_migrations"
+}
+
+func NewSqlMigrationStore(db *gorm.DB) *SqlMigrationStore {
+	return &SqlMigrationStore{db}
+}
+
+// NewSqlMigrator migrates the tables through snapshots of their models
+// declared within each migration, so later changes to the models in the orm
+// package never change what an earlier migration does. Within a function
+// they still get the table and index names gorm gives those models.
+func NewSqlMigrator(db *gorm.DB) *Migrator {
+	return NewMigrator(
+		NewSqlMigrationStore(db),
+		Migration{
+			Version:     1,
+			Description: "Create session, message and attachment tables",
+			Up: func(ctx context.Context) error {
+				type session struct {
+					ID        string `gorm:"primaryKey"`
+					CreatedAt int64  `gorm:"autoCreateTime:milli;index"`
+				}
+				type message struct {
+					ID           string `gorm:"primaryKey"`
+					SessionID    string `gorm:"not null;index"`
+					Sensitive    bool   `gorm:"not null"`
+					Body         string
+					AttachmentID string `gorm:"index"`
+					CreatedAt    int64  `gorm:"autoCreateTime:milli;index"`
+				}
+				type attachment struct {
+					ID        string `gorm:"primaryKey"`
+					Type      string `gorm:"not null"`
+					Mime      string `gorm:"not null"`
+					Name      string `gorm:"not null"`
+					Encoding  string
+					CreatedAt int64 `gorm:"autoCreateTime:milli;index"`
+				}
+				return db.WithContext(ctx).AutoMigrate(session{}, message{}, attachment{})
+			},
+			Down: func(ctx context.Context) error {
+				return db.WithContext(ctx).Migrator().DropTable("messages", "sessions", "attachments")
+			},
+		},
+		Migration{
+			Version:     2,
+			Description: "Add session sequence numbers to messages",
+			Up: func(ctx context.Context) error {
+				type session struct {
+					ID        string `gorm:"primaryKey"`
+					Seq       int64  `gorm:"not null;default:0"`
+					CreatedAt int64  `gorm:"autoCreateTime:milli;index"`
+				}
+				type message struct {
+					ID           string `gorm:"primaryKey"`
+					SessionID    string `gorm:"not null;index"`
+					Sensitive    bool   `gorm:"not null"`
+					Body         string
+					AttachmentID string `gorm:"index"`
+					Seq          int64  `gorm:"not null;default:0;index"`
+					CreatedAt    int64  `gorm:"autoCreateTime:milli;index"`
+				}
+				migrator := db.WithContext(ctx).Migrator()
+				if err := migrator.AutoMigrate(session{}, message{}); err != nil {
+					return err
+				}
+				// Columns added to existing tables don't get their indexes, and
+				// SQLite loses them all when reverting rebuilds the tables
+				indexes := []struct {
+					model  interface{}
+					fields []string
+				}{
+					{session{}, []string{"CreatedAt"}},
+					{message{}, []string{"SessionID", "AttachmentID", "Seq", "CreatedAt"}},
+				}
+				for _, index := range indexes {
+					for _, field := range index.fields {
+						if migrator.HasIndex(index.model, field) {
+							continue
+						}
+						if err := migrator.CreateIndex(index.model, field); err != nil {
+							return err
+						}
+					}
+				}
+				return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+					// Existing messages are numbered in the order they were created
+					err := tx.Exec(`UPDATE messages SET seq = (
+						SELECT COUNT(*) FROM messages m
+						WHERE m.session_id = messages.session_id AND (
+							m.created_at < messages.created_at OR
+							(m.created_at = messages.created_at AND m.id <= messages.id)
+						)
+					)`).Error
+					if err != nil {
+						return err
+					}
+					return tx.Exec(`UPDATE sessions SET seq = (
+						SELECT COALESCE(MAX(seq), 0) FROM messages
+						WHERE messages.session_id = sessions.id
+					)`).Error
+				})
+			},
+			Down: func(ctx context.Context) error {
+				type session struct {
+					Seq int64
+				}
+				type message struct {
+					Seq int64 `gorm:"index"`
+				}
+				migrator := db.WithContext(ctx).Migrator()
+				if migrator.HasIndex(message{}, "Seq") {
+					if err := migrator.DropIndex(message{}, "Seq"); err != nil {
+						return err
+					}
+				}
+				if err := migrator.DropColumn(message{}, "Seq"); err != nil {
+					return err
+				}
+				return migrator.DropColumn(session{}, "Seq")
+			},
+		},
+		Migration{
+			Version:     3,
+			Description: "Create outbox table",
+			Up: func(ctx context.Context) error {
+				type outboxEntry struct {
+					ID           string `gorm:"primaryKey"`
+					SessionID    string `gorm:"not null"`
+					Payload      string `gorm:"not null"`
+					Position     int64  `gorm:"not null;index"`
+					CreatedAt    int64  `gorm:"autoCreateTime:milli"`
+					ClaimedBy    string
+					ClaimedUntil int64 `gorm:"not null;default:0"`
+					DeliveredAt  int64 `gorm:"not null;default:0;index"`
+				}
+				return db.WithContext(ctx).AutoMigrate(outboxEntry{})
+			},
+			Down: func(ctx context.Context) error {
+				return db.WithContext(ctx).Migrator().DropTable("outbox_entries")
+			},
+		},
+		Migration{
+			Version:     4,
+			Description: "Create lease table",
+			Up: func(ctx context.Context) error {
+				type lease struct {
+					Name      string `gorm:"primaryKey"`
+					Holder    string `gorm:"not null"`
+					ExpiresAt int64  `gorm:"not null"`
+				}
+				return db.WithContext(ctx).AutoMigrate(lease{})
+			},
+			Down: func(ctx context.Context) error {
+				return db.WithContext(ctx).Migrator().DropTable("leases")
+			},
+		},
+		Migration{
+			Version:     5,
+			Description: "Create cron run table",
+			Up: func(ctx context.Context) error {
+				type cronRun struct {
+					ID        string `gorm:"primaryKey"`
+					Job       string `gorm:"not null;index:idx_cron_runs_job_started,priority:1"`
+					Trigger   string `gorm:"not null"`
+					StartedAt int64  `gorm:"not null;index:idx_cron_runs_job_started,priority:2;index"`
+					EndedAt   int64  `gorm:"not null;default:0"`
+					Outcome   string `gorm:"not null"`
+					Error     string
+					Counts    string
+				}
+				return db.WithContext(ctx).AutoMigrate(cronRun{})
+			},
+			Down: func(ctx context.Context) error {
+				return db.WithContext(ctx).Migrator().DropTable("cron_runs")
+			},
+		},
+	)
+}
+
+func (s *SqlMigrationStore) Setup(ctx context.Context) error {
+	// Instances may start at once, so the tables are created without the
+	// check-then-create race of AutoMigrate
+	db := s.db.WithContext(ctx)
+	err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`).Error
+	if err != nil {
+		return err
+	}
+	return db.Exec(`CREATE TABLE IF NOT EXISTS schema_migration_locks (
+		id TEXT PRIMARY KEY,
+		owner TEXT NOT NULL,
+		locked_until BIGINT NOT NULL
+	)`).Error
+}
+
+func (s *SqlMigrationStore) Lock(ctx context.Context, owner string, until time.Time) (bool, error) {
+	db := s.db.WithContext(ctx)
+	res := db.Exec(
+		"INSERT INTO schema_migration_locks (id, owner, locked_until) VALUES (?, ?, ?) ON CONFLICT (id) DO NOTHING",
+		sqlMigrationLockID, owner, models.FromTime(until),
+	)
+	if res.Error != nil {
+		return false, res.Error
+	} else if res.RowsAffected > 0 {
+		return true, nil
+	}
+	// Take over the lock if its owner didn't release it in time, or renew it
+	res = db.Exec(
+		"UPDATE schema_migration_locks SET owner = ?, locked_until = ? WHERE id = ? AND (locked_until < ? OR owner = ?)",
+		owner, models.FromTime(until), sqlMigrationLockID, models.Timestamp(), owner,
+	)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *SqlMigrationStore) Unlock(ctx context.Context, owner string) error {
+	return s.db.WithContext(ctx).Exec(
+		"DELETE FROM schema_migration_locks WHERE id = ? AND owner = ?",
+		sqlMigrationLockID, owner,
+	).Error
+}
+
+func (s *SqlMigrationStore) Applied(ctx context.Context) ([]MigrationRecord, error) {
+	rows := make([]sqlMigrationRecord, 0)
+	if err := s.db.WithContext(ctx).Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	records := make([]MigrationRecord, len(rows))
+	for idx, row := range rows {
+		records[idx] = MigrationRecord(row)
+	}
+	return records, nil
+}
+
+func (s *SqlMigrationStore) Insert(ctx context.Context, record MigrationRecord) error {
+	row := sqlMigrationRecord(record)
+	return s.db.WithContext(ctx).Create(&row).Error
+}
+
+func (s *SqlMigrationStore) Remove(ctx context.Context, version int) error {
+	return s.db.WithContext(ctx).Where("version = ?", version).Delete(sqlMigrationRecord{}).Error
+}
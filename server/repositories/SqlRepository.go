@@ -1,6 +1,9 @@
 package repositories
 
 import (
+	"air-sync/models/events"
+	"air-sync/models/orm"
+
 	"gorm.io/gorm"
 )
 
@@ -11,3 +14,25 @@ type SqlRepository struct {
 func NewSqlRepository(db *gorm.DB) *SqlRepository {
 	return &SqlRepository{db}
 }
+
+// findOne loads the record with the given ID into dest, returning notFound
+// when there is none. Unlike First, it doesn't log missing records as errors.
+func (r *SqlRepository) findOne(db *gorm.DB, dest interface{}, id string, notFound error) error {
+	res := db.Where("id = ?", id).Limit(1).Find(dest)
+	if res.Error != nil {
+		return res.Error
+	} else if res.RowsAffected <= 0 {
+		return notFound
+	}
+	return nil
+}
+
+// recordEvent adds the event to the outbox within the transaction.
+func (r *SqlRepository) recordEvent(tx *gorm.DB, event events.SessionEvent) error {
+	entry, err := newOutboxEntry(event)
+	if err != nil {
+		return err
+	}
+	record := orm.FromOutboxEntryModel(entry)
+	return tx.Create(&record).Error
+}
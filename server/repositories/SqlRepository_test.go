@@ -0,0 +1,52 @@
+package repositories
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestSqlRepository(t *testing.T) {
+	runRepositoryContract(t, func(t *testing.T) (SessionRepository, AttachmentRepository) {
+		db := openSqliteTestDB(t)
+		_, err := NewSqlMigrator(db).Up(context.Background(), 0)
+		require.Nil(t, err)
+		return NewSessionSqlRepository(db), NewAttachmentSqlRepository(db)
+	})
+	runOutboxContract(t, func(t *testing.T) (SessionRepository, OutboxRepository) {
+		db := openSqliteTestDB(t)
+		_, err := NewSqlMigrator(db).Up(context.Background(), 0)
+		require.Nil(t, err)
+		return NewSessionSqlRepository(db), NewOutboxSqlRepository(db)
+	})
+	runLeaseContract(t, func(t *testing.T) LeaseRepository {
+		db := openSqliteTestDB(t)
+		_, err := NewSqlMigrator(db).Up(context.Background(), 0)
+		require.Nil(t, err)
+		return NewLeaseSqlRepository(db)
+	})
+	runCronRunContract(t, func(t *testing.T) CronRunRepository {
+		db := openSqliteTestDB(t)
+		_, err := NewSqlMigrator(db).Up(context.Background(), 0)
+		require.Nil(t, err)
+		return NewCronRunSqlRepository(db)
+	})
+}
+
+func openSqliteTestDB(t *testing.T) *gorm.DB {
+	dsn := filepath.Join(t.TempDir(), "airsync.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+	})
+	require.Nil(t, err)
+	sqlDB, err := db.DB()
+	require.Nil(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
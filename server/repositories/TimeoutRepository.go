@@ -0,0 +1,132 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"context"
+	"time"
+)
+
+// SessionTimeoutRepository bounds every call to the wrapped repository with
+// a deadline, so a slow database can't hold a request forever.
+type SessionTimeoutRepository struct {
+	repo    SessionRepository
+	timeout time.Duration
+}
+
+// AttachmentTimeoutRepository bounds every call to the wrapped repository
+// with a deadline, so a slow database can't hold a request forever.
+type AttachmentTimeoutRepository struct {
+	repo    AttachmentRepository
+	timeout time.Duration
+}
+
+var _ SessionRepository = (*SessionTimeoutRepository)(nil)
+var _ AttachmentRepository = (*AttachmentTimeoutRepository)(nil)
+
+// NewSessionTimeoutRepository wraps the repository, unless the timeout is
+// zero.
+func NewSessionTimeoutRepository(repo SessionRepository, timeout time.Duration) SessionRepository {
+	if timeout <= 0 {
+		return repo
+	}
+	return &SessionTimeoutRepository{repo, timeout}
+}
+
+// NewAttachmentTimeoutRepository wraps the repository, unless the timeout is
+// zero.
+func NewAttachmentTimeoutRepository(repo AttachmentRepository, timeout time.Duration) AttachmentRepository {
+	if timeout <= 0 {
+		return repo
+	}
+	return &AttachmentTimeoutRepository{repo, timeout}
+}
+
+func (r *SessionTimeoutRepository) Create(ctx context.Context) (models.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.Create(ctx)
+}
+
+func (r *SessionTimeoutRepository) Find(ctx context.Context, id string) (models.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.Find(ctx, id)
+}
+
+func (r *SessionTimeoutRepository) FindBefore(ctx context.Context, t time.Time) ([]models.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.FindBefore(ctx, t)
+}
+
+func (r *SessionTimeoutRepository) Search(ctx context.Context, id string, query models.SearchQuery) (models.SearchResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.Search(ctx, id, query)
+}
+
+func (r *SessionTimeoutRepository) InsertMessage(ctx context.Context, id string, model models.InsertMessage) (models.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.InsertMessage(ctx, id, model)
+}
+
+func (r *SessionTimeoutRepository) DeleteMessage(ctx context.Context, id string, messageId string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.DeleteMessage(ctx, id, messageId)
+}
+
+func (r *SessionTimeoutRepository) Delete(ctx context.Context, id string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.Delete(ctx, id)
+}
+
+func (r *SessionTimeoutRepository) DeleteMany(ctx context.Context, ids []string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.DeleteMany(ctx, ids)
+}
+
+func (r *AttachmentTimeoutRepository) Create(ctx context.Context, arg models.CreateAttachment) (models.Attachment, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.Create(ctx, arg)
+}
+
+func (r *AttachmentTimeoutRepository) Find(ctx context.Context, id string) (models.Attachment, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.Find(ctx, id)
+}
+
+func (r *AttachmentTimeoutRepository) FindAll(ctx context.Context) ([]models.Attachment, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.FindAll(ctx)
+}
+
+func (r *AttachmentTimeoutRepository) FindOrphansBefore(ctx context.Context, t time.Time) ([]models.Attachment, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.FindOrphansBefore(ctx, t)
+}
+
+func (r *AttachmentTimeoutRepository) FindReleasedBefore(ctx context.Context, t time.Time, sessionIds []string) ([]models.Attachment, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.FindReleasedBefore(ctx, t, sessionIds)
+}
+
+func (r *AttachmentTimeoutRepository) Delete(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.Delete(ctx, id)
+}
+
+func (r *AttachmentTimeoutRepository) DeleteMany(ctx context.Context, ids []string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.repo.DeleteMany(ctx, ids)
+}
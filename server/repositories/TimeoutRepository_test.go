@@ -0,0 +1,41 @@
+package repositories
+
+import (
+	"air-sync/models"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// slowSessionRepository stands for a database which never answers
+type slowSessionRepository struct {
+	SessionRepository
+}
+
+func (r *slowSessionRepository) Find(ctx context.Context, id string) (models.Session, error) {
+	<-ctx.Done()
+	return models.Session{}, ctx.Err()
+}
+
+func TestSessionTimeoutRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewSessionTimeoutRepository(&slowSessionRepository{}, 50*time.Millisecond)
+
+	start := time.Now()
+	_, err := repo.Find(ctx, "session")
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// The caller's own deadline still applies when it's sooner
+	repo = NewSessionTimeoutRepository(&slowSessionRepository{}, time.Hour)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = repo.Find(cancelled, "session")
+	require.Equal(t, context.Canceled, err)
+
+	// Without a timeout the repository isn't wrapped
+	memory := NewSessionMemoryRepository(NewMemoryDatabase())
+	require.Equal(t, memory, NewSessionTimeoutRepository(memory, 0))
+}
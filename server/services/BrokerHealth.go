@@ -0,0 +1,187 @@
+package services
+
+import (
+	"air-sync/models/events"
+	"air-sync/util"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type BrokerState string
+
+const (
+	BrokerStateConnected BrokerState = "connected"
+	// Degraded means the broker is subscribed, but publishing fails
+	BrokerStateDegraded     BrokerState = "degraded"
+	BrokerStateReconnecting BrokerState = "reconnecting"
+	BrokerStateDisconnected BrokerState = "disconnected"
+)
+
+const (
+	// The brokers remember the IDs of the events seen within the window, so
+	// an event is neither published back nor received twice. Signed
+	// envelopes older than the IDs remembered are rejected as stale.
+	brokerSeenEventsSize = 4096
+	brokerSeenEventsTTL  = 10 * time.Minute
+
+	brokerBackoffMin = 500 * time.Millisecond
+	brokerBackoffMax = 30 * time.Second
+)
+
+// Reasons the received envelopes are rejected for
+const (
+	BrokerRejectMalformed        = "malformed"
+	BrokerRejectUnsigned         = "unsigned"
+	BrokerRejectUnknownKey       = "unknown_key"
+	BrokerRejectInvalidSignature = "invalid_signature"
+	BrokerRejectStale            = "stale"
+	BrokerRejectReplayed         = "replayed"
+)
+
+type BrokerHealth struct {
+	Service EventService  `json:"service"`
+	State   BrokerState   `json:"state"`
+	Error   string        `json:"error,omitempty"`
+	Since   time.Time     `json:"since"`
+	Metrics BrokerMetrics `json:"metrics"`
+}
+
+// BrokerMetrics counts the events received from the other instances, and
+// the envelopes rejected by reason.
+type BrokerMetrics struct {
+	Received int64            `json:"received"`
+	Rejected map[string]int64 `json:"rejected,omitempty"`
+}
+
+type HealthReporter interface {
+	Health() BrokerHealth
+}
+
+func (h BrokerHealth) Healthy() bool {
+	return h.State == BrokerStateConnected
+}
+
+// brokerHealth keeps the health of a broker, and is embedded by each of them.
+type brokerHealth struct {
+	mu     sync.Mutex
+	health BrokerHealth
+}
+
+func newBrokerHealth(service EventService) brokerHealth {
+	return brokerHealth{
+		health: BrokerHealth{
+			Service: service,
+			State:   BrokerStateDisconnected,
+			Since:   time.Now(),
+		},
+	}
+}
+
+func (h *brokerHealth) Health() BrokerHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	health := h.health
+	if h.health.Metrics.Rejected != nil {
+		health.Metrics.Rejected = make(map[string]int64, len(h.health.Metrics.Rejected))
+		for reason, n := range h.health.Metrics.Rejected {
+			health.Metrics.Rejected[reason] = n
+		}
+	}
+	return health
+}
+
+func (h *brokerHealth) setHealth(state BrokerState, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.update(state, err)
+}
+
+// reportPublish degrades a connected broker when publishing fails, and
+// recovers it once publishing succeeds again.
+func (h *brokerHealth) reportPublish(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil && h.health.State == BrokerStateConnected {
+		h.update(BrokerStateDegraded, err)
+	} else if err == nil && h.health.State == BrokerStateDegraded {
+		h.update(BrokerStateConnected, nil)
+	}
+}
+
+func (h *brokerHealth) reportReceived() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.health.Metrics.Received++
+}
+
+// reportRejected counts the envelope rejected with the error, returning
+// the error.
+func (h *brokerHealth) reportRejected(err error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.health.Metrics.Rejected == nil {
+		h.health.Metrics.Rejected = make(map[string]int64)
+	}
+	h.health.Metrics.Rejected[brokerRejectReason(err)]++
+	return err
+}
+
+func brokerRejectReason(err error) string {
+	switch {
+	case errors.Is(err, events.ErrUnsignedEvent):
+		return BrokerRejectUnsigned
+	case errors.Is(err, events.ErrUnknownEventKey):
+		return BrokerRejectUnknownKey
+	case errors.Is(err, events.ErrInvalidSignature):
+		return BrokerRejectInvalidSignature
+	case errors.Is(err, events.ErrStaleEvent):
+		return BrokerRejectStale
+	case errors.Is(err, ErrReplayedEvent):
+		return BrokerRejectReplayed
+	default:
+		return BrokerRejectMalformed
+	}
+}
+
+func (h *brokerHealth) update(state BrokerState, err error) {
+	h.health.Error = ""
+	if err != nil {
+		h.health.Error = err.Error()
+	}
+	if h.health.State == state {
+		return
+	}
+	logger := log.WithFields(log.Fields{
+		"service": h.health.Service,
+		"from":    h.health.State,
+		"to":      state,
+	})
+	if state == BrokerStateConnected {
+		logger.Info("Event broker state changed")
+	} else {
+		logger.Warn("Event broker state changed")
+	}
+	h.health.State = state
+	h.health.Since = time.Now()
+}
+
+func newSeenEvents() *util.SeenSet {
+	return util.NewSeenSet(brokerSeenEventsSize, brokerSeenEventsTTL)
+}
+
+// envelopeKey identifies a signed envelope. The outbox relay publishing an
+// event again signs a new envelope, while a replay repeats the same one.
+func envelopeKey(event events.PubSubSessionEvent) string {
+	return fmt.Sprintf("%s@%d", event.ID, event.SentAt)
+}
+
+func newBrokerBackoff() util.Backoff {
+	return util.Backoff{
+		Min: brokerBackoffMin,
+		Max: brokerBackoffMax,
+	}
+}
@@ -1,121 +1,478 @@
 package services
 
 import (
-	"air-sync/models/events"
+	"air-sync/models"
 	repos "air-sync/repositories"
 	"air-sync/storages"
-	"air-sync/util/pubsub"
+	"context"
+	"errors"
 	"fmt"
+	"regexp"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	CronJobCleanup = "cleanup"
+
+	DefaultCronJobTimeout   = 10 * time.Minute
+	DefaultCronRunRetention = 30 * 24 * time.Hour
+	// DefaultCronJobHistory is how many runs of each job are listed
+	DefaultCronJobHistory = 10
+
+	// cronJobsRoute lists the jobs, so no job may take its name
+	cronJobsRoute = "jobs"
+)
+
+var cronJobNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// CronJobCounts are the numbers of items a run went through, by kind.
+type CronJobCounts map[string]int64
+
+// CronJobReport is what a run tells about itself. The counts are recorded
+// with the run, the whole report goes back to whoever requested it.
+type CronJobReport interface {
+	Counts() CronJobCounts
+}
+
+func (c CronJobCounts) Counts() CronJobCounts {
+	return c
+}
+
+type CronJob struct {
+	// Name is also the route the job is requested on
+	Name string
+	// Schedule is the cron expression the job runs on within the server.
+	// When empty, it only runs when requested.
+	Schedule string
+	// Timeout cancels the runs taking longer
+	Timeout time.Duration
+	// Interval is the least time after a successful run before another
+	// one may be requested
+	Interval time.Duration
+	Run      func(ctx context.Context) (CronJobReport, error)
+	// DryRun reports what Run would do without changing anything. Jobs
+	// without it can't be dry run.
+	DryRun func(ctx context.Context) (CronJobReport, error)
+}
+
+// CronJobStatus shows a job and its latest runs to the operators.
+type CronJobStatus struct {
+	Name     string           `json:"name"`
+	Schedule string           `json:"schedule,omitempty"`
+	Timeout  string           `json:"timeout"`
+	DryRun   bool             `json:"dry_run"`
+	Running  bool             `json:"running"`
+	Runs     []models.CronRun `json:"runs"`
+}
+
+type cronJobEntry struct {
+	CronJob
+	running     bool
+	nextRequest time.Time
+}
+
 type CronJobOptions struct {
-	GracePeriod          time.Duration
+	GracePeriod time.Duration
+	// CleanupSchedule is the cron expression the cleanup runs on within the
+	// server. When empty, it only runs when requested.
+	CleanupSchedule      string
+	Scheduler            CronSchedulerOptions
 	SessionRepository    repos.SessionRepository
 	AttachmentRepository repos.AttachmentRepository
-	Publisher            *pubsub.Publisher
+	RunRepository        repos.CronRunRepository
+	Relay                *OutboxRelayService
 	Storage              storages.Storage
 }
 
+// CronJobService keeps the registry of the cron jobs, running them on
+// request or on their schedule and recording each run.
 type CronJobService struct {
 	sessionRepo    repos.SessionRepository
 	attachmentRepo repos.AttachmentRepository
-	topic          *pubsub.Topic
+	runRepo        repos.CronRunRepository
+	relay          *OutboxRelayService
 	storage        storages.Storage
-	nextRun        time.Time
-	interval       time.Duration
-	gracePeriod    time.Duration
-	mu             sync.Mutex
+	scheduler      *CronScheduler
+	jobs           map[string]*cronJobEntry
+	// names keeps the jobs in the order they were registered
+	names       []string
+	gracePeriod time.Duration
+	mu          sync.Mutex
+	initialized bool
 }
 
 var _ Initializer = (*CronJobService)(nil)
 
-func NewCronJobService(opts CronJobOptions) *CronJobService {
-	return &CronJobService{
+func NewCronJobService(ctx context.Context, opts CronJobOptions) *CronJobService {
+	s := &CronJobService{
 		sessionRepo:    opts.SessionRepository,
 		attachmentRepo: opts.AttachmentRepository,
-		topic:          opts.Publisher.Topic(events.EventSession),
+		runRepo:        opts.RunRepository,
+		relay:          opts.Relay,
 		storage:        opts.Storage,
-		nextRun:        time.Unix(0, 0),
-		interval:       1 * time.Hour,
+		scheduler:      NewCronScheduler(ctx, opts.Scheduler),
+		jobs:           make(map[string]*cronJobEntry),
+		names:          make([]string, 0),
 		gracePeriod:    opts.GracePeriod,
+		initialized:    false,
 	}
+	// The name is valid and not taken yet
+	_ = s.Register(CronJob{
+		Name:     CronJobCleanup,
+		Schedule: opts.CleanupSchedule,
+		Interval: time.Hour,
+		Run:      s.RunCleanupJob,
+		DryRun:   s.DryRunCleanupJob,
+	})
+	return s
 }
 
 func (s *CronJobService) Initialize() error {
+	if s.initialized {
+		return ErrAlreadyInitialized
+	}
 	log.Infof("Initialize cron job cleanup service with grace period: %s", s.gracePeriod.String())
+	for _, name := range s.names {
+		name := name
+		job := s.jobs[name]
+		if job.Schedule == "" {
+			continue
+		}
+		err := s.scheduler.Schedule(name, job.Schedule, func(ctx context.Context) error {
+			_, _, err := s.RunJob(ctx, name, models.CronTriggerSchedule)
+			return err
+		})
+		if err != nil {
+			s.scheduler.Stop()
+			return err
+		}
+	}
+	s.initialized = true
 	return nil
 }
 
 func (s *CronJobService) Deinitialize() {
-	// Do nothing
+	if !s.initialized {
+		log.Error(ErrNotInitialized)
+		return
+	}
+	s.scheduler.Stop()
+	s.initialized = false
 }
 
-func (s *CronJobService) RunCleanupJob() error {
+// Register adds a job to the registry, which must be done before
+// initializing the service.
+func (s *CronJobService) Register(job CronJob) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if time.Now().Before(s.nextRun) {
-		dt := s.nextRun.UTC().Format(time.RFC3339)
-		return NewCronRequestError("No cleanup job run until %s", dt)
+	if s.initialized {
+		return ErrAlreadyInitialized
 	}
-	deadline := time.Now().Add(-1 * s.gracePeriod)
-	{
-		s.log("Deleting old sessions")
-		sessions, err := s.sessionRepo.FindBefore(deadline)
-		if err != nil {
-			return err
+	if !cronJobNamePattern.MatchString(job.Name) || job.Name == cronJobsRoute {
+		return fmt.Errorf("Invalid cron job name: %q", job.Name)
+	}
+	if _, ok := s.jobs[job.Name]; ok {
+		return fmt.Errorf("Cron job already registered: %s", job.Name)
+	}
+	if job.Timeout <= 0 {
+		job.Timeout = DefaultCronJobTimeout
+	}
+	s.jobs[job.Name] = &cronJobEntry{CronJob: job}
+	s.names = append(s.names, job.Name)
+	return nil
+}
+
+// JobNames returns the names of the registered jobs.
+func (s *CronJobService) JobNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.names...)
+}
+
+// Jobs returns every job with up to limit of its latest runs.
+func (s *CronJobService) Jobs(ctx context.Context, limit int) ([]CronJobStatus, error) {
+	s.mu.Lock()
+	statuses := make([]CronJobStatus, len(s.names))
+	for idx, name := range s.names {
+		job := s.jobs[name]
+		statuses[idx] = CronJobStatus{
+			Name:     name,
+			Schedule: job.Schedule,
+			Timeout:  job.Timeout.String(),
+			DryRun:   job.DryRun != nil,
+			Running:  job.running,
 		}
-		sessionIds := make([]string, len(sessions))
-		for idx, session := range sessions {
-			sessionIds[idx] = session.ID
+	}
+	s.mu.Unlock()
+	for idx := range statuses {
+		runs, err := s.runRepo.FindRecent(ctx, statuses[idx].Name, limit)
+		if err != nil {
+			return nil, err
 		}
-		n, err := s.sessionRepo.DeleteMany(sessionIds)
+		statuses[idx].Runs = runs
+	}
+	return statuses, nil
+}
+
+// RunJob runs the named job unless it's running already, recording the run.
+// Requested runs also wait for the interval of the job after the last
+// successful run.
+func (s *CronJobService) RunJob(ctx context.Context, name string, trigger string) (models.CronRun, CronJobReport, error) {
+	job, err := s.begin(name, trigger == models.CronTriggerRequest)
+	if err != nil {
+		return models.CronRun{}, nil, err
+	}
+	run, err := s.runRepo.Start(ctx, name, trigger)
+	if err != nil {
+		s.end(job, false)
+		return run, nil, err
+	}
+	s.log("Running %s job", name)
+	jobCtx, cancel := context.WithTimeout(ctx, job.Timeout)
+	report, err := job.Run(jobCtx)
+	cancel()
+	run.EndedAt = models.Timestamp()
+	if report != nil {
+		run.Counts = report.Counts()
+	}
+	run.Outcome = models.CronRunSucceeded
+	if err != nil {
+		run.Outcome = models.CronRunFailed
+		run.Error = err.Error()
+	}
+	// The outcome is recorded even when the request went away meanwhile
+	if err := s.runRepo.Finish(context.Background(), run); err != nil {
+		log.Error(err)
+	}
+	s.end(job, err == nil)
+	return run, report, err
+}
+
+// DryRunJob reports what the named job would do. Nothing is recorded, but
+// the dry run doesn't overlap with runs of the job either.
+func (s *CronJobService) DryRunJob(ctx context.Context, name string) (CronJobReport, error) {
+	job, err := s.begin(name, false)
+	if err != nil {
+		return nil, err
+	}
+	defer s.end(job, false)
+	if job.DryRun == nil {
+		return nil, NewCronRequestError("The %s job has no dry run", name)
+	}
+	s.log("Dry running %s job", name)
+	jobCtx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+	return job.DryRun(jobCtx)
+}
+
+// begin marks the job as running. When throttled, it also waits for the
+// interval after the last successful run.
+func (s *CronJobService) begin(name string, throttled bool) (*cronJobEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[name]
+	if !ok {
+		return nil, ErrCronJobNotFound
+	}
+	if job.running {
+		return nil, NewCronRequestError("The %s job is already running", name)
+	}
+	if throttled && time.Now().Before(job.nextRequest) {
+		dt := job.nextRequest.UTC().Format(time.RFC3339)
+		return nil, NewCronRequestError("No %s job run until %s", name, dt)
+	}
+	job.running = true
+	return job, nil
+}
+
+func (s *CronJobService) end(job *cronJobEntry, succeeded bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.running = false
+	if succeeded {
+		job.nextRequest = time.Now().Add(job.Interval)
+	}
+}
+
+// CleanupReport tells what a cleanup removed, or would remove on a dry run.
+type CleanupReport struct {
+	DryRun      bool   `json:"dry_run"`
+	GracePeriod string `json:"grace_period"`
+	Sessions    int64  `json:"sessions"`
+	// Messages are only counted on dry runs
+	Messages    int64 `json:"messages"`
+	Attachments int64 `json:"attachments"`
+	// Bytes is the storage taken by the attachments
+	Bytes int64 `json:"bytes"`
+	// CronRuns are left out of dry runs
+	CronRuns int64 `json:"cron_runs"`
+	// Ages spreads the sessions and attachments by age, youngest first
+	Ages []CleanupAgeBucket `json:"ages"`
+}
+
+// CleanupAgeBucket counts the items at least as old as its age and younger
+// than the next bucket.
+type CleanupAgeBucket struct {
+	Age         string `json:"age"`
+	Sessions    int64  `json:"sessions"`
+	Attachments int64  `json:"attachments"`
+}
+
+var cleanupAgeBuckets = []struct {
+	name string
+	age  time.Duration
+}{
+	{"0h", 0},
+	{"1h", time.Hour},
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"90d", 90 * 24 * time.Hour},
+	{"365d", 365 * 24 * time.Hour},
+}
+
+func newCleanupReport(gracePeriod time.Duration, dryRun bool) *CleanupReport {
+	ages := make([]CleanupAgeBucket, len(cleanupAgeBuckets))
+	for idx, bucket := range cleanupAgeBuckets {
+		ages[idx].Age = bucket.name
+	}
+	return &CleanupReport{
+		DryRun:      dryRun,
+		GracePeriod: gracePeriod.String(),
+		Ages:        ages,
+	}
+}
+
+func (r *CleanupReport) Counts() CronJobCounts {
+	return CronJobCounts{
+		"sessions":    r.Sessions,
+		"messages":    r.Messages,
+		"attachments": r.Attachments,
+		"bytes":       r.Bytes,
+		"cron_runs":   r.CronRuns,
+	}
+}
+
+func (r *CleanupReport) bucket(now time.Time, createdAt int64) *CleanupAgeBucket {
+	age := now.Sub(time.Unix(0, createdAt*int64(time.Millisecond)))
+	idx := len(cleanupAgeBuckets) - 1
+	for idx > 0 && age < cleanupAgeBuckets[idx].age {
+		idx--
+	}
+	return &r.Ages[idx]
+}
+
+// RunCleanupJob deletes the sessions older than the grace period and the
+// attachments no message references anymore, along with the old runs.
+func (s *CronJobService) RunCleanupJob(ctx context.Context) (CronJobReport, error) {
+	return s.cleanup(ctx, false)
+}
+
+// DryRunCleanupJob reports what RunCleanupJob would delete.
+func (s *CronJobService) DryRunCleanupJob(ctx context.Context) (CronJobReport, error) {
+	return s.cleanup(ctx, true)
+}
+
+func (s *CronJobService) cleanup(ctx context.Context, dryRun bool) (*CleanupReport, error) {
+	report := newCleanupReport(s.gracePeriod, dryRun)
+	deleted := "Deleted"
+	if dryRun {
+		deleted = "Would delete"
+	}
+	now := time.Now()
+	deadline := now.Add(-1 * s.gracePeriod)
+	sessionIds := make([]string, 0)
+	{
+		s.log("Finding old sessions")
+		sessions, err := s.sessionRepo.FindBefore(ctx, deadline)
 		if err != nil {
-			return err
+			return report, err
 		}
-		for _, id := range sessionIds {
-			s.topic.Publish(events.CreateSessionEvent(
-				id, events.EventSessionDeleted,
-				events.SessionDelete(id), nil,
-			))
+		for _, session := range sessions {
+			if dryRun {
+				// Sessions are listed without their messages, which are only
+				// looked up to report how many would go
+				session, err = s.sessionRepo.Find(ctx, session.ID)
+				if errors.Is(err, repos.ErrSessionNotFound) {
+					continue
+				} else if err != nil {
+					return report, err
+				}
+				report.Messages += int64(len(session.Messages))
+			}
+			sessionIds = append(sessionIds, session.ID)
+			report.bucket(now, session.CreatedAt).Sessions++
 		}
-		s.log("Deleted %d session(s)", n)
+		n := len(sessionIds)
+		if dryRun {
+			s.log("%s %d session(s) with %d message(s)", deleted, n, report.Messages)
+		} else {
+			if n, err = s.sessionRepo.DeleteMany(ctx, sessionIds); err != nil {
+				return report, err
+			}
+			if s.relay != nil {
+				s.relay.Notify()
+			}
+			s.log("%s %d session(s)", deleted, n)
+		}
+		report.Sessions = int64(n)
 	}
 	{
-		s.log("Deleting orphan attachments")
-		attachments, err := s.attachmentRepo.FindOrphansBefore(deadline)
-		if err != nil {
-			return err
+		s.log("Finding orphan attachments")
+		var attachments []models.Attachment
+		var err error
+		if dryRun {
+			// The attachments only the old sessions show are orphans once
+			// they are gone, while those kept sessions show too stay
+			attachments, err = s.attachmentRepo.FindReleasedBefore(ctx, deadline, sessionIds)
+		} else {
+			attachments, err = s.attachmentRepo.FindOrphansBefore(ctx, deadline)
 		}
-		attachmentIds := make([]string, len(attachments))
-		for idx, attachment := range attachments {
-			attachmentIds[idx] = attachment.ID
+		if err != nil {
+			return report, err
 		}
-		for _, id := range attachmentIds {
-			if id == "" {
+		attachmentIds := make([]string, 0, len(attachments))
+		for _, attachment := range attachments {
+			if attachment.ID == "" {
+				continue
+			}
+			attachmentIds = append(attachmentIds, attachment.ID)
+			report.bucket(now, attachment.CreatedAt).Attachments++
+			size, err := s.storage.Size(ctx, attachment.ID)
+			if errors.Is(err, storages.ErrObjectNotFound) {
 				continue
+			} else if err != nil {
+				return report, err
 			}
-			exists, err := s.storage.Exists(id)
-			if err != nil {
-				return err
-			} else if !exists {
+			report.Bytes += size
+			if dryRun {
 				continue
 			}
-			if err := s.storage.Delete(id); err != nil {
-				return err
+			if err := s.storage.Delete(ctx, attachment.ID); err != nil {
+				return report, err
+			}
+		}
+		n := len(attachmentIds)
+		if !dryRun {
+			if n, err = s.attachmentRepo.DeleteMany(ctx, attachmentIds); err != nil {
+				return report, err
 			}
 		}
-		n, err := s.attachmentRepo.DeleteMany(attachmentIds)
+		report.Attachments = int64(n)
+		s.log("%s %d attachment(s) taking %d byte(s)", deleted, n, report.Bytes)
+	}
+	if !dryRun {
+		n, err := s.runRepo.DeleteBefore(ctx, time.Now().Add(-DefaultCronRunRetention))
 		if err != nil {
-			return err
+			return report, err
 		}
-		s.log("Deleted %d attachment(s)", n)
+		report.CronRuns = int64(n)
+		s.log("Deleted %d cron run(s)", n)
 	}
-	s.nextRun = time.Now().Add(s.interval)
-	return nil
+	return report, nil
 }
 
 func (s *CronJobService) log(format string, a ...interface{}) {
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"air-sync/models"
+	repos "air-sync/repositories"
+	"air-sync/storages"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronJobService(t *testing.T) {
+	ctx := context.Background()
+	db := repos.NewMemoryDatabase()
+	sessionRepo := repos.NewSessionMemoryRepository(db)
+	cron := NewCronJobService(ctx, CronJobOptions{
+		// Deletes the sessions created right before
+		GracePeriod:          -time.Second,
+		SessionRepository:    sessionRepo,
+		AttachmentRepository: repos.NewAttachmentMemoryRepository(db),
+		RunRepository:        repos.NewCronRunMemoryRepository(db),
+		Relay:                NewOutboxRelayService(ctx, OutboxRelayOptions{}),
+	})
+	failing := errors.New("failing")
+	require.Nil(t, cron.Register(CronJob{
+		Name: "flaky",
+		Run: func(ctx context.Context) (CronJobReport, error) {
+			return CronJobCounts{"items": 1}, failing
+		},
+	}))
+	require.NotNil(t, cron.Register(CronJob{Name: CronJobCleanup}))
+	require.NotNil(t, cron.Register(CronJob{Name: "jobs"}))
+	require.Nil(t, cron.Initialize())
+	defer cron.Deinitialize()
+	require.Equal(t, ErrAlreadyInitialized, cron.Register(CronJob{Name: "late"}))
+	require.Equal(t, []string{CronJobCleanup, "flaky"}, cron.JobNames())
+
+	_, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	run, report, err := cron.RunJob(ctx, CronJobCleanup, models.CronTriggerRequest)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), report.(*CleanupReport).Sessions)
+	require.Equal(t, models.CronRunSucceeded, run.Outcome)
+	require.Equal(t, int64(1), run.Counts["sessions"])
+
+	// Requests wait for the interval after a successful run
+	_, _, err = cron.RunJob(ctx, CronJobCleanup, models.CronTriggerRequest)
+	require.IsType(t, CronRequestError{}, err)
+	_, _, err = cron.RunJob(ctx, CronJobCleanup, models.CronTriggerSchedule)
+	require.Nil(t, err)
+
+	// Failed runs are recorded and may be retried right away
+	for i := 0; i < 2; i++ {
+		run, _, err = cron.RunJob(ctx, "flaky", models.CronTriggerRequest)
+		require.Equal(t, failing, err)
+		require.Equal(t, models.CronRunFailed, run.Outcome)
+	}
+	_, _, err = cron.RunJob(ctx, "missing", models.CronTriggerRequest)
+	require.Equal(t, ErrCronJobNotFound, err)
+	_, err = cron.DryRunJob(ctx, "flaky")
+	require.IsType(t, CronRequestError{}, err)
+
+	jobs, err := cron.Jobs(ctx, 10)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(jobs))
+	require.Equal(t, CronJobCleanup, jobs[0].Name)
+	require.Equal(t, 2, len(jobs[0].Runs))
+	require.Equal(t, models.CronTriggerSchedule, jobs[0].Runs[0].Trigger)
+	require.Equal(t, DefaultCronJobTimeout.String(), jobs[1].Timeout)
+	require.Equal(t, 2, len(jobs[1].Runs))
+	require.Equal(t, "failing", jobs[1].Runs[0].Error)
+	require.Equal(t, int64(1), jobs[1].Runs[0].Counts["items"])
+	require.NotZero(t, jobs[1].Runs[0].EndedAt)
+
+	// Runs of the same job don't overlap
+	started := make(chan struct{})
+	done := make(chan struct{})
+	slow := NewCronJobService(ctx, CronJobOptions{RunRepository: repos.NewCronRunMemoryRepository(db)})
+	require.Nil(t, slow.Register(CronJob{
+		Name: "slow",
+		Run: func(ctx context.Context) (CronJobReport, error) {
+			close(started)
+			<-done
+			return nil, nil
+		},
+	}))
+	go slow.RunJob(ctx, "slow", models.CronTriggerSchedule)
+	<-started
+	_, _, err = slow.RunJob(ctx, "slow", models.CronTriggerSchedule)
+	require.IsType(t, CronRequestError{}, err)
+	close(done)
+	require.Eventually(t, func() bool {
+		jobs, err := slow.Jobs(ctx, 10)
+		return err == nil && !jobs[1].Running && jobs[1].Runs[0].Outcome == models.CronRunSucceeded
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCronJobServiceCleanupDryRun(t *testing.T) {
+	ctx := context.Background()
+	db := repos.NewMemoryDatabase()
+	sessionRepo := repos.NewSessionMemoryRepository(db)
+	attachmentRepo := repos.NewAttachmentMemoryRepository(db)
+	runRepo := repos.NewCronRunMemoryRepository(db)
+	storage := storages.NewMemoryStorage()
+	cron := NewCronJobService(ctx, CronJobOptions{
+		GracePeriod:          -time.Second,
+		SessionRepository:    sessionRepo,
+		AttachmentRepository: attachmentRepo,
+		RunRepository:        runRepo,
+		Storage:              storage,
+	})
+
+	attach := func(body string) string {
+		attachment, err := attachmentRepo.Create(ctx, models.NewCreateAttachment("file", "file", "text/plain"))
+		require.Nil(t, err)
+		w, err := storage.Write(ctx, attachment.ID)
+		require.Nil(t, err)
+		_, err = w.Write([]byte(body))
+		require.Nil(t, err)
+		require.Nil(t, w.Close())
+		return attachment.ID
+	}
+	shown, orphan := attach("abc"), attach("defgh")
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	for _, message := range []models.BaseMessage{{Body: "hello"}, {AttachmentID: shown}} {
+		_, err = sessionRepo.InsertMessage(ctx, session.ID, models.InsertMessage{BaseMessage: message})
+		require.Nil(t, err)
+	}
+	_, err = sessionRepo.Create(ctx)
+	require.Nil(t, err)
+
+	expected := func(dryRun bool) *CleanupReport {
+		report := newCleanupReport(-time.Second, dryRun)
+		report.Sessions = 2
+		if dryRun {
+			report.Messages = 2
+		}
+		report.Attachments = 2
+		report.Bytes = 8
+		report.Ages[0].Sessions = 2
+		report.Ages[0].Attachments = 2
+		return report
+	}
+	report, err := cron.DryRunJob(ctx, CronJobCleanup)
+	require.Nil(t, err)
+	require.Equal(t, expected(true), report)
+	// Nothing was deleted or recorded
+	_, err = sessionRepo.Find(ctx, session.ID)
+	require.Nil(t, err)
+	names, err := storages.List(ctx, storage)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{shown, orphan}, names)
+	runs, err := runRepo.FindRecent(ctx, CronJobCleanup, 10)
+	require.Nil(t, err)
+	require.Empty(t, runs)
+
+	run, report, err := cron.RunJob(ctx, CronJobCleanup, models.CronTriggerCommand)
+	require.Nil(t, err)
+	require.Equal(t, expected(false), report)
+	require.Equal(t, int64(8), run.Counts["bytes"])
+	names, err = storages.List(ctx, storage)
+	require.Nil(t, err)
+	require.Empty(t, names)
+}
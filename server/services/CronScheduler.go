@@ -0,0 +1,139 @@
+package services
+
+import (
+	repos "air-sync/repositories"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/robfig/cron/v3"
+)
+
+type CronLease string
+
+const (
+	CronLeaseRepository CronLease = "repository"
+	CronLeaseRedis      CronLease = "redis"
+	// CronLeaseNone runs the scheduled jobs on every instance
+	CronLeaseNone CronLease = "none"
+
+	cronLeasePrefix = "cron:"
+)
+
+// ParseCronLease checks the lease is one of the known ones.
+func ParseCronLease(v string) (CronLease, error) {
+	switch lease := CronLease(v); lease {
+	case CronLeaseRepository, CronLeaseRedis, CronLeaseNone:
+		return lease, nil
+	}
+	return "", fmt.Errorf("Unknown cron lease: %q", v)
+}
+
+type CronSchedulerOptions struct {
+	// Lease keeps the other instances from running a job at the same time.
+	// Without it, every instance runs every job.
+	Lease repos.LeaseRepository
+	// Holder names the instance in the leases
+	Holder string
+}
+
+// CronScheduler runs jobs on cron schedules. The instance which takes the
+// lease of a run holds it until the next run is due, so the instances whose
+// clocks are slightly off skip the run instead of repeating it.
+type CronScheduler struct {
+	context context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	lease  repos.LeaseRepository
+	holder string
+}
+
+func NewCronScheduler(ctx context.Context, opts CronSchedulerOptions) *CronScheduler {
+	ctx, cancel := context.WithCancel(ctx)
+	holder := opts.Holder
+	if holder == "" {
+		holder = uuid.NewV4().String()
+	}
+	return &CronScheduler{
+		context: ctx,
+		cancel:  cancel,
+		lease:   opts.Lease,
+		holder:  holder,
+	}
+}
+
+// ParseCronSchedule parses a standard cron expression of five fields, or a
+// descriptor such as @daily or @every 1h.
+func ParseCronSchedule(expr string) (cron.Schedule, error) {
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cron schedule %q: %w", expr, err)
+	}
+	return schedule, nil
+}
+
+// Schedule runs the job on the schedule until the scheduler is stopped.
+func (s *CronScheduler) Schedule(name string, expr string, run func(ctx context.Context) error) error {
+	schedule, err := ParseCronSchedule(expr)
+	if err != nil {
+		return err
+	}
+	log.Infof("Cron: scheduling %s job: %s", name, expr)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.loop(name, schedule, run)
+	}()
+	return nil
+}
+
+// Stop waits for the running jobs, which are cancelled, to return.
+func (s *CronScheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *CronScheduler) loop(name string, schedule cron.Schedule, run func(ctx context.Context) error) {
+	next := schedule.Next(time.Now())
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.context.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if s.acquire(name, schedule.Next(next)) {
+			log.Infof("Cron: running scheduled %s job", name)
+			if err := run(s.context); err != nil {
+				s.handleError(fmt.Errorf("Scheduled %s job failed: %w", name, err))
+			}
+		}
+		// Runs missed while the job ran are skipped
+		next = schedule.Next(time.Now())
+	}
+}
+
+func (s *CronScheduler) acquire(name string, until time.Time) bool {
+	if s.lease == nil {
+		return true
+	}
+	ok, err := s.lease.Acquire(s.context, cronLeasePrefix+name, s.holder, until)
+	if err != nil {
+		s.handleError(err)
+		return false
+	}
+	if !ok {
+		log.Infof("Cron: scheduled %s job is run by another instance", name)
+	}
+	return ok
+}
+
+func (s *CronScheduler) handleError(err error) {
+	log.Error(err)
+}
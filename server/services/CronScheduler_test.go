@@ -0,0 +1,114 @@
+package services
+
+import (
+	repos "air-sync/repositories"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	{
+		schedule, err := ParseCronSchedule("30 3 * * *")
+		require.Nil(t, err)
+		now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.Local)
+		require.Equal(t, time.Date(2021, 1, 2, 3, 30, 0, 0, time.Local), schedule.Next(now))
+	}
+	{
+		_, err := ParseCronSchedule("@daily")
+		require.Nil(t, err)
+	}
+	{
+		_, err := ParseCronSchedule("* * *")
+		require.NotNil(t, err)
+	}
+}
+
+func TestParseCronLease(t *testing.T) {
+	for _, lease := range []CronLease{CronLeaseRepository, CronLeaseRedis, CronLeaseNone} {
+		res, err := ParseCronLease(string(lease))
+		require.Nil(t, err)
+		require.Equal(t, lease, res)
+	}
+	for _, v := range []string{"", "Redis", "mongo"} {
+		_, err := ParseCronLease(v)
+		require.NotNil(t, err, v)
+	}
+}
+
+func TestCronScheduler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lease := repos.NewLeaseMemoryRepository(repos.NewMemoryDatabase())
+
+	mu := sync.Mutex{}
+	runs := make([]time.Time, 0)
+	holders := make(map[string]int)
+	for i := 0; i < 3; i++ {
+		holder := fmt.Sprintf("instance-%d", i)
+		scheduler := NewCronScheduler(ctx, CronSchedulerOptions{
+			Lease:  lease,
+			Holder: holder,
+		})
+		err := scheduler.Schedule("test", "@every 1s", func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs = append(runs, time.Now())
+			holders[holder]++
+			return nil
+		})
+		require.Nil(t, err)
+		defer scheduler.Stop()
+	}
+	time.Sleep(2500 * time.Millisecond)
+
+	// Each run is done by a single instance
+	mu.Lock()
+	defer mu.Unlock()
+	require.GreaterOrEqual(t, len(runs), 2)
+	require.LessOrEqual(t, len(runs), 3)
+	for idx := 1; idx < len(runs); idx++ {
+		require.Greater(t, int64(runs[idx].Sub(runs[idx-1])), int64(500*time.Millisecond))
+	}
+	require.NotEmpty(t, holders)
+}
+
+// Runs against a local server such as the redis service of
+// docker/airsync/docker-compose.yml
+func TestRedisLeaseService(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("Requires REDIS_ADDR env to test")
+		return
+	}
+	ctx := context.Background()
+	lease := NewRedisLeaseService(ctx, RedisOptions{Addrs: []string{addr}})
+	require.Nil(t, lease.Initialize())
+	defer lease.Deinitialize()
+	name := fmt.Sprintf("test:%d", time.Now().UnixNano())
+
+	ok, err := lease.Acquire(ctx, name, "first", time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	require.True(t, ok)
+	ok, err = lease.Acquire(ctx, name, "second", time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	require.False(t, ok)
+	ok, err = lease.Acquire(ctx, name, "first", time.Now().Add(10*time.Second))
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	// Redis expires the lease when it's due
+	ttl, err := lease.client.PTTL(ctx, redisLeasePrefix+name).Result()
+	require.Nil(t, err)
+	require.Greater(t, int64(ttl), int64(5*time.Second))
+	require.LessOrEqual(t, int64(ttl), int64(10*time.Second))
+	require.Nil(t, lease.client.Del(ctx, redisLeasePrefix+name).Err())
+	ok, err = lease.Acquire(ctx, name, "second", time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	require.True(t, ok)
+}
@@ -8,6 +8,9 @@ import (
 var (
 	ErrAlreadyInitialized = errors.New("Service already initialized")
 	ErrNotInitialized     = errors.New("Service not yet initialized")
+	ErrCronJobNotFound    = errors.New("Cron job not found")
+	// ErrReplayedEvent rejects a signed envelope received already
+	ErrReplayedEvent = errors.New("Event envelope was replayed")
 )
 
 type CronRequestError struct {
@@ -2,8 +2,11 @@ package services
 
 import (
 	"air-sync/models/events"
+	"air-sync/util"
 	"air-sync/util/pubsub"
 	"context"
+	"fmt"
+	"net/url"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -12,42 +15,94 @@ import (
 type EventService string
 
 const (
-	EventServiceRedis  EventService = "redis"
-	EventServicePubSub EventService = "pubsub"
+	EventServiceRedis        EventService = "redis"
+	EventServiceRedisStreams EventService = "redis-streams"
+	EventServicePubSub       EventService = "pubsub"
+	EventServiceMongo        EventService = "mongo"
+	EventServiceNats         EventService = "nats"
+	EventServicePostgres     EventService = "postgres"
+	// EventServiceLocal only publishes the events within the instance
+	EventServiceLocal EventService = "local"
 )
 
-type RedisOptions struct {
-	Addr     string
-	Password string
+// RedisStreamsOptions are connected to with the Redis options of the broker.
+type RedisStreamsOptions struct {
+	Stream     string
+	MaxLen     int64
+	InstanceID string
 }
 
 type GooglePubSubOptions struct {
-	ProjectID      string
-	TopicID        string
-	SubscriptionID string
+	ProjectID              string
+	TopicID                string
+	SubscriptionPrefix     string
+	SubscriptionExpiration time.Duration
+}
+
+type MongoEventOptions struct {
+	URL        *url.URL
+	Database   string
+	InstanceID string
+	Retention  time.Duration
+}
+
+type NatsOptions struct {
+	URL             string
+	CredentialsFile string
+	TLSCAFile       string
+	TLSCertFile     string
+	TLSKeyFile      string
+	JetStream       bool
+	Stream          string
+	Durable         string
+	MaxAge          time.Duration
+}
+
+type PostgresEventOptions struct {
+	DSN string
 }
 
 type EventBrokerOptions struct {
 	Service      EventService
 	Redis        RedisOptions
+	RedisStreams RedisStreamsOptions
 	GooglePubSub GooglePubSubOptions
+	Mongo        MongoEventOptions
+	Nats         NatsOptions
+	Postgres     PostgresEventOptions
+	// SigningKeys signs the envelopes sent through Redis, Redis streams and
+	// Pub/Sub, which any client of the server could otherwise inject events into
+	SigningKeys *events.Keyring
+}
+
+// EventPublisher is implemented by the brokers to publish an event to the
+// other instances, returning once it's accepted so it can be retried
+// otherwise.
+type EventPublisher interface {
+	PublishEvent(event events.SessionEvent) error
 }
 
 type EventBrokerService struct {
 	EventBrokerOptions
-	context     context.Context
-	pub         *pubsub.Publisher
-	broker      interface{}
+	context context.Context
+	pub     *pubsub.Publisher
+	broker  interface{}
+	// local holds the events published within the instance by PublishEvent
+	local       *util.SeenSet
+	since       time.Time
 	initialized bool
 }
 
 var _ Initializer = (*EventBrokerService)(nil)
+var _ HealthReporter = (*EventBrokerService)(nil)
+var _ EventPublisher = (*EventBrokerService)(nil)
 
 func NewEventBrokerService(ctx context.Context, opts EventBrokerOptions) *EventBrokerService {
 	return &EventBrokerService{
 		EventBrokerOptions: opts,
 		context:            ctx,
 		pub:                pubsub.NewPublisher(),
+		local:              newSeenEvents(),
 		initialized:        false,
 	}
 }
@@ -56,21 +111,61 @@ func (s *EventBrokerService) Initialize() error {
 	if s.initialized {
 		return ErrAlreadyInitialized
 	}
+	if s.SigningKeys != nil && s.Service != EventServiceRedis && s.Service != EventServiceRedisStreams && s.Service != EventServicePubSub {
+		return fmt.Errorf("Event signing keys are only used by the %s, %s and %s event services, not %s", EventServiceRedis, EventServiceRedisStreams, EventServicePubSub, s.Service)
+	}
 	s.pub.Topic(events.EventSession).Subscribe().
 		ForEachAsync(s.context, s.handleSessionEvent, s.handleError)
 	switch s.Service {
 	case EventServiceRedis:
 		s.broker = NewRedisBrokerService(s.context, RedisBrokerOptions{
 			Publisher: s.pub,
-			Addr:      s.Redis.Addr,
-			Password:  s.Redis.Password,
+			Redis:     s.Redis,
+			Keys:      s.SigningKeys,
+		})
+	case EventServiceRedisStreams:
+		s.broker = NewRedisStreamsBrokerService(s.context, RedisStreamsBrokerOptions{
+			Publisher:  s.pub,
+			Redis:      s.Redis,
+			Stream:     s.RedisStreams.Stream,
+			MaxLen:     s.RedisStreams.MaxLen,
+			InstanceID: s.RedisStreams.InstanceID,
+			Keys:       s.SigningKeys,
 		})
 	case EventServicePubSub:
 		s.broker = NewGooglePubSubBrokerService(s.context, GooglePubSubBrokerOptions{
-			Publisher:      s.pub,
-			ProjectID:      s.GooglePubSub.ProjectID,
-			TopicID:        s.GooglePubSub.TopicID,
-			SubscriptionID: s.GooglePubSub.SubscriptionID,
+			Publisher:              s.pub,
+			ProjectID:              s.GooglePubSub.ProjectID,
+			TopicID:                s.GooglePubSub.TopicID,
+			SubscriptionPrefix:     s.GooglePubSub.SubscriptionPrefix,
+			SubscriptionExpiration: s.GooglePubSub.SubscriptionExpiration,
+			Keys:                   s.SigningKeys,
+		})
+	case EventServiceMongo:
+		s.broker = NewMongoBrokerService(s.context, MongoBrokerOptions{
+			Publisher:  s.pub,
+			URL:        s.Mongo.URL,
+			Database:   s.Mongo.Database,
+			InstanceID: s.Mongo.InstanceID,
+			Retention:  s.Mongo.Retention,
+		})
+	case EventServiceNats:
+		s.broker = NewNatsBrokerService(s.context, NatsBrokerOptions{
+			Publisher:       s.pub,
+			URL:             s.Nats.URL,
+			CredentialsFile: s.Nats.CredentialsFile,
+			TLSCAFile:       s.Nats.TLSCAFile,
+			TLSCertFile:     s.Nats.TLSCertFile,
+			TLSKeyFile:      s.Nats.TLSKeyFile,
+			JetStream:       s.Nats.JetStream,
+			Stream:          s.Nats.Stream,
+			Durable:         s.Nats.Durable,
+			MaxAge:          s.Nats.MaxAge,
+		})
+	case EventServicePostgres:
+		s.broker = NewPostgresBrokerService(s.context, PostgresBrokerOptions{
+			Publisher: s.pub,
+			DSN:       s.Postgres.DSN,
 		})
 	}
 	if v, ok := s.broker.(Initializer); ok {
@@ -78,6 +173,7 @@ func (s *EventBrokerService) Initialize() error {
 			return err
 		}
 	}
+	s.since = time.Now()
 	s.initialized = true
 	return nil
 }
@@ -97,6 +193,33 @@ func (s *EventBrokerService) Publisher() *pubsub.Publisher {
 	return s.pub
 }
 
+// PublishEvent publishes the event to the other instances through the
+// broker, and within the instance. The event is published locally even when
+// the broker fails, but only once however often it's retried.
+func (s *EventBrokerService) PublishEvent(event events.SessionEvent) error {
+	var err error
+	if v, ok := s.broker.(EventPublisher); ok {
+		err = v.PublishEvent(event)
+	}
+	if s.local.Add(event.ID) {
+		s.pub.Topic(events.EventSession).Publish(event)
+	}
+	return err
+}
+
+// Health reports the state of the broker, which is always connected when
+// the events are only published locally.
+func (s *EventBrokerService) Health() BrokerHealth {
+	if v, ok := s.broker.(HealthReporter); ok {
+		return v.Health()
+	}
+	return BrokerHealth{
+		Service: EventServiceLocal,
+		State:   BrokerStateConnected,
+		Since:   s.since,
+	}
+}
+
 func (s *EventBrokerService) handleSessionEvent(v interface{}) error {
 	event, ok := v.(events.SessionEvent)
 	if !ok {
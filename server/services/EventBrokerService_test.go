@@ -0,0 +1,29 @@
+package services
+
+import (
+	"air-sync/models/events"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventBrokerServiceSigningKeys(t *testing.T) {
+	keys, err := events.ParseKeyring("current:secret")
+	require.Nil(t, err)
+	// Brokers which don't sign their envelopes refuse the keys
+	for _, service := range []EventService{
+		EventServiceLocal, EventServiceNats, EventServiceMongo, EventServicePostgres,
+	} {
+		broker := NewEventBrokerService(context.Background(), EventBrokerOptions{
+			Service:     service,
+			SigningKeys: keys,
+		})
+		require.NotNil(t, broker.Initialize(), service)
+	}
+	broker := NewEventBrokerService(context.Background(), EventBrokerOptions{
+		Service: EventServiceLocal,
+	})
+	require.Nil(t, broker.Initialize())
+	broker.Deinitialize()
+}
@@ -2,28 +2,54 @@ package services
 
 import (
 	"air-sync/models/events"
+	"air-sync/util"
 	"air-sync/util/pubsub"
 	"context"
+	"os"
 	"sync"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
-	"github.com/vmihailenco/msgpack/v5"
 
 	log "github.com/sirupsen/logrus"
 
 	. "cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Pub/Sub doesn't expire subscriptions inactive for less than a day
+	DefaultPubSubSubscriptionExpiration = 24 * time.Hour
+	// DefaultPubSubEmulatorProject is used with the emulator when no
+	// project is set, as the emulator accepts any of them
+	DefaultPubSubEmulatorProject = "airsync-local"
+
+	pubSubAckDeadline       = 10 * time.Second
+	pubSubMessageRetention  = 10 * time.Minute
+	pubSubSubscriptionClean = 10 * time.Second
 )
 
 type GooglePubSubBrokerOptions struct {
-	Publisher      *pubsub.Publisher
-	ProjectID      string
-	TopicID        string
-	SubscriptionID string
+	Publisher *pubsub.Publisher
+	ProjectID string
+	TopicID   string
+	// SubscriptionPrefix starts the ID of the subscription created for the
+	// instance, which defaults to the topic ID
+	SubscriptionPrefix string
+	// SubscriptionExpiration deletes the subscription of an instance which
+	// stopped without deleting it, once inactive for that long
+	SubscriptionExpiration time.Duration
+	// Keys signs the published envelopes, and rejects the received ones not
+	// signed with them. Without keys, envelopes go unsigned.
+	Keys *events.Keyring
 }
 
 type GooglePubSubBrokerService struct {
+	brokerHealth
 	context context.Context
-	mu      sync.Mutex
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
 
 	pub *pubsub.Publisher
 
@@ -31,26 +57,60 @@ type GooglePubSubBrokerService struct {
 	projectID      string
 	topicID        string
 	subscriptionID string
+	expiration     time.Duration
 
 	client *Client
 	topic  *Topic
 	sub    *Subscription
 
-	lastEventID string
+	keys *events.Keyring
+	seen *util.SeenSet
+	// deliveries holds the IDs of the messages received, and envelopes the
+	// signed envelopes, telling replays apart from redeliveries and events
+	// published again
+	deliveries *util.SeenSet
+	envelopes  *util.SeenSet
+	backoff    util.Backoff
 
 	initialized bool
 }
 
 var _ Initializer = (*GooglePubSubBrokerService)(nil)
+var _ HealthReporter = (*GooglePubSubBrokerService)(nil)
+var _ EventPublisher = (*GooglePubSubBrokerService)(nil)
 
 func NewGooglePubSubBrokerService(ctx context.Context, opts GooglePubSubBrokerOptions) *GooglePubSubBrokerService {
+	ctx, cancel := context.WithCancel(ctx)
+	clientID := uuid.NewV1().String()
+	projectID := opts.ProjectID
+	if projectID == "" && os.Getenv("PUBSUB_EMULATOR_HOST") != "" {
+		projectID = DefaultPubSubEmulatorProject
+	}
+	prefix := opts.SubscriptionPrefix
+	if prefix == "" {
+		prefix = opts.TopicID
+	}
+	expiration := opts.SubscriptionExpiration
+	if expiration <= 0 {
+		expiration = DefaultPubSubSubscriptionExpiration
+	}
 	return &GooglePubSubBrokerService{
-		context:        ctx,
-		pub:            opts.Publisher,
-		clientID:       uuid.NewV1().String(),
-		projectID:      opts.ProjectID,
-		topicID:        opts.TopicID,
-		subscriptionID: opts.SubscriptionID,
+		brokerHealth: newBrokerHealth(EventServicePubSub),
+		context:      ctx,
+		cancel:       cancel,
+		pub:          opts.Publisher,
+		clientID:     clientID,
+		projectID:    projectID,
+		topicID:      opts.TopicID,
+		// Each instance reads its own subscription, as Pub/Sub spreads the
+		// messages of a subscription across its readers
+		subscriptionID: prefix + "-" + clientID,
+		expiration:     expiration,
+		keys:           opts.Keys,
+		seen:           newSeenEvents(),
+		deliveries:     newSeenEvents(),
+		envelopes:      newSeenEvents(),
+		backoff:        newBrokerBackoff(),
 	}
 }
 
@@ -66,9 +126,17 @@ func (s *GooglePubSubBrokerService) Initialize() error {
 	}
 	s.client = client
 	log.Infof("Publishing to Google Cloud Pub/Sub: %s", s.topicID)
-	s.topic = client.Topic(s.topicID)
+	if err := s.ensureTopic(); err != nil {
+		client.Close()
+		return err
+	}
 	log.Infof("Subscribing to Google Cloud Pub/Sub: %s", s.subscriptionID)
-	s.sub = client.Subscription(s.subscriptionID)
+	if err := s.createSubscription(); err != nil {
+		s.topic.Stop()
+		client.Close()
+		return err
+	}
+	s.setHealth(BrokerStateConnected, nil)
 
 	s.handlePublishingAsync()
 	s.handleSubscriptionAsync()
@@ -82,9 +150,58 @@ func (s *GooglePubSubBrokerService) Deinitialize() {
 		log.Error(ErrNotInitialized)
 		return
 	}
+	s.cancel()
+	s.wg.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), pubSubSubscriptionClean)
+	defer cancel()
+	log.Infof("Deleting Google Cloud Pub/Sub subscription: %s", s.subscriptionID)
+	if err := s.sub.Delete(ctx); err != nil {
+		log.Error(err)
+	}
+	s.topic.Stop()
+	if err := s.client.Close(); err != nil {
+		log.Error(err)
+	}
+	s.setHealth(BrokerStateDisconnected, nil)
 	s.initialized = false
 }
 
+// ensureTopic creates the topic unless it exists.
+func (s *GooglePubSubBrokerService) ensureTopic() error {
+	topic := s.client.Topic(s.topicID)
+	exists, err := topic.Exists(s.context)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		log.Infof("Creating Google Cloud Pub/Sub topic: %s", s.topicID)
+		_, err := s.client.CreateTopic(s.context, s.topicID)
+		// Another instance may have created it meanwhile
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return err
+		}
+	}
+	// The events of a session are delivered in the order they're published
+	topic.EnableMessageOrdering = true
+	s.topic = topic
+	return nil
+}
+
+func (s *GooglePubSubBrokerService) createSubscription() error {
+	sub, err := s.client.CreateSubscription(s.context, s.subscriptionID, SubscriptionConfig{
+		Topic:                 s.topic,
+		AckDeadline:           pubSubAckDeadline,
+		RetentionDuration:     pubSubMessageRetention,
+		ExpirationPolicy:      s.expiration,
+		EnableMessageOrdering: true,
+	})
+	if err != nil {
+		return err
+	}
+	s.sub = sub
+	return nil
+}
+
 func (s *GooglePubSubBrokerService) handlePublishingAsync() {
 	s.pub.Topic(events.EventSession).Subscribe().
 		ForEachAsync(s.context, s.handlePublishing, s.handleError)
@@ -95,22 +212,38 @@ func (s *GooglePubSubBrokerService) handlePublishing(v interface{}) error {
 	if !ok {
 		return nil
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if event.ID == s.lastEventID {
+	// Events received from Pub/Sub are published locally too
+	if !s.seen.Add(event.ID) {
 		return nil
 	}
-	s.lastEventID = event.ID
-	b, err := msgpack.Marshal(events.PubSubSessionEvent{
+	return s.publish(event)
+}
+
+func (s *GooglePubSubBrokerService) PublishEvent(event events.SessionEvent) error {
+	if err := s.publish(event); err != nil {
+		return err
+	}
+	s.seen.Add(event.ID)
+	return nil
+}
+
+func (s *GooglePubSubBrokerService) publish(event events.SessionEvent) error {
+	b, err := events.EncodeSignedPubSubEvent(events.MsgpackCodec, s.keys, events.PubSubSessionEvent{
 		SessionEvent: event,
 		ClientID:     s.clientID,
 	})
 	if err != nil {
 		return err
 	}
-	res := s.topic.Publish(s.context, &Message{Data: b})
+	res := s.topic.Publish(s.context, &Message{
+		Data:        b,
+		OrderingKey: event.SessionID,
+	})
 	id, err := res.Get(s.context)
+	s.reportPublish(err)
 	if err != nil {
+		// Publishing is paused for the ordering key after a failure
+		s.topic.ResumePublish(event.SessionID)
 		return err
 	}
 	log.WithFields(log.Fields{
@@ -122,11 +255,39 @@ func (s *GooglePubSubBrokerService) handlePublishing(v interface{}) error {
 	return nil
 }
 
+// handleSubscriptionAsync keeps receiving, starting over with an increasing
+// delay whenever receiving fails.
 func (s *GooglePubSubBrokerService) handleSubscriptionAsync() {
+	s.wg.Add(1)
 	go func() {
-		err := s.handleSubscription()
-		if err != nil {
+		defer s.wg.Done()
+		for {
+			// Receive only returns without error once the context is done
+			err := s.handleSubscription()
+			if s.context.Err() != nil {
+				return
+			}
 			s.handleError(err)
+			s.setHealth(BrokerStateReconnecting, err)
+			if !s.backoff.Wait(s.context) {
+				return
+			}
+			// Make sure the subscription is reachable again before reporting
+			// the broker as connected
+			exists, err := s.sub.Exists(s.context)
+			if err != nil {
+				continue
+			}
+			if !exists {
+				// The events published since it expired are lost
+				log.Warnf("Recreating expired Google Cloud Pub/Sub subscription: %s", s.subscriptionID)
+				if err := s.createSubscription(); err != nil {
+					s.handleError(err)
+					continue
+				}
+			}
+			s.backoff.Reset()
+			s.setHealth(BrokerStateConnected, nil)
 		}
 	}()
 }
@@ -142,25 +303,32 @@ func (s *GooglePubSubBrokerService) handleSubscription() error {
 }
 
 func (s *GooglePubSubBrokerService) handleSubscriptionMessage(msg *Message) error {
-	event := events.PubSubSessionEvent{}
-	if err := msgpack.Unmarshal(msg.Data, &event); err != nil {
-		return err
+	// Pub/Sub delivers at least once, and redelivers the same message
+	if !s.deliveries.Add(msg.ID) {
+		return nil
+	}
+	// Envelopes older than the window of seen events could be replayed
+	event, err := events.DecodeVerifiedPubSubEvent(events.MsgpackCodec, s.keys, msg.Data, s.seen.Window())
+	if err != nil {
+		return s.reportRejected(err)
 	}
 	if event.ClientID == s.clientID {
 		return nil
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if event.ID == s.lastEventID {
+	// A replay publishes the same envelope again as another message
+	if s.keys != nil && !s.envelopes.Add(envelopeKey(event)) {
+		return s.reportRejected(ErrReplayedEvent)
+	}
+	if !s.seen.Add(event.ID) {
 		return nil
 	}
-	s.lastEventID = event.ID
 	log.WithFields(log.Fields{
 		"id":        msg.ID,
 		"event_id":  event.ID,
 		"event":     event.Event,
 		"timestamp": event.Timestamp,
 	}).Info("Google Cloud Pub/Sub received event")
+	s.reportReceived()
 	s.pub.Topic(events.EventSession).Publish(event.SessionEvent)
 	return nil
 }
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"air-sync/models/events"
+	"air-sync/util/pubsub"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	gpubsub "cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/stretchr/testify/require"
+)
+
+// Runs against an in-process fake of Pub/Sub, reached the way the emulator is
+func TestGooglePubSubBrokerService(t *testing.T) {
+	srv := pstest.NewServer()
+	defer srv.Close()
+	os.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+	defer os.Unsetenv("PUBSUB_EMULATOR_HOST")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newBroker := func(pub *pubsub.Publisher) *GooglePubSubBrokerService {
+		broker := NewGooglePubSubBrokerService(ctx, GooglePubSubBrokerOptions{
+			Publisher: pub,
+			TopicID:   "airsync-events",
+		})
+		require.Nil(t, broker.Initialize())
+		return broker
+	}
+	receive := func(ch <-chan interface{}) events.SessionEvent {
+		select {
+		case v := <-ch:
+			event, ok := v.(events.SessionEvent)
+			require.True(t, ok)
+			return event
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "Timed out waiting for the event")
+			return events.SessionEvent{}
+		}
+	}
+
+	client, err := gpubsub.NewClient(ctx, DefaultPubSubEmulatorProject)
+	require.Nil(t, err)
+	defer client.Close()
+
+	sender := pubsub.NewPublisher()
+	defer newBroker(sender).Deinitialize()
+	// The topic is created by the first instance
+	exists, err := client.Topic("airsync-events").Exists(ctx)
+	require.Nil(t, err)
+	require.True(t, exists)
+
+	// Every instance receives every event
+	receivers := make([]*GooglePubSubBrokerService, 0)
+	channels := make([]<-chan interface{}, 0)
+	for i := 0; i < 2; i++ {
+		pub := pubsub.NewPublisher()
+		receivers = append(receivers, newBroker(pub))
+		sub := pub.Topic(events.EventSession).Subscribe()
+		defer sub.Unsubscribe()
+		channels = append(channels, sub.Channel())
+	}
+	require.NotEqual(t, receivers[0].subscriptionID, receivers[1].subscriptionID)
+
+	event := events.CreateSessionEvent("session", events.EventSessionDeleted, events.SessionDelete("session"), nil)
+	sender.Topic(events.EventSession).Publish(event)
+	for _, ch := range channels {
+		require.Equal(t, event, receive(ch))
+	}
+
+	// The subscription of the instance is deleted with it
+	for _, broker := range receivers {
+		broker.Deinitialize()
+		exists, err := client.Subscription(broker.subscriptionID).Exists(ctx)
+		require.Nil(t, err)
+		require.False(t, exists)
+	}
+}
+
+func TestGooglePubSubBrokerServiceSigning(t *testing.T) {
+	srv := pstest.NewServer()
+	defer srv.Close()
+	os.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+	defer os.Unsetenv("PUBSUB_EMULATOR_HOST")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keys, err := events.ParseKeyring("current:secret")
+	require.Nil(t, err)
+	newBroker := func(pub *pubsub.Publisher) *GooglePubSubBrokerService {
+		broker := NewGooglePubSubBrokerService(ctx, GooglePubSubBrokerOptions{
+			Publisher: pub,
+			TopicID:   "airsync-events",
+			Keys:      keys,
+		})
+		require.Nil(t, broker.Initialize())
+		return broker
+	}
+	sender := pubsub.NewPublisher()
+	defer newBroker(sender).Deinitialize()
+	receiver := pubsub.NewPublisher()
+	broker := newBroker(receiver)
+	defer broker.Deinitialize()
+	sub := receiver.Topic(events.EventSession).Subscribe()
+	defer sub.Unsubscribe()
+
+	// Anyone allowed to publish to the topic may try to inject events
+	client, err := gpubsub.NewClient(ctx, DefaultPubSubEmulatorProject)
+	require.Nil(t, err)
+	defer client.Close()
+	topic := client.Topic("airsync-events")
+	topic.EnableMessageOrdering = true
+	defer topic.Stop()
+	forged := events.PubSubSessionEvent{
+		SessionEvent: events.CreateSessionEvent("session", events.EventSessionDeleted, events.SessionDelete("session"), nil),
+	}
+	unsigned, err := events.EncodePubSubEvent(events.MsgpackCodec, forged)
+	require.Nil(t, err)
+	otherKeys, err := events.ParseKeyring("current:guessed")
+	require.Nil(t, err)
+	guessed, err := events.EncodeSignedPubSubEvent(events.MsgpackCodec, otherKeys, forged)
+	require.Nil(t, err)
+	for _, b := range [][]byte{unsigned, guessed} {
+		res := topic.Publish(ctx, &gpubsub.Message{Data: b, OrderingKey: "session"})
+		_, err := res.Get(ctx)
+		require.Nil(t, err)
+	}
+
+	// Events of a session are received in order, after the forged ones
+	event := events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil)
+	sender.Topic(events.EventSession).Publish(event)
+	select {
+	case v := <-sub.Channel():
+		require.Equal(t, event, v)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "Timed out waiting for the event")
+	}
+	metrics := broker.Health().Metrics
+	require.Equal(t, int64(1), metrics.Received)
+	require.Equal(t, map[string]int64{
+		BrokerRejectUnsigned:         1,
+		BrokerRejectInvalidSignature: 1,
+	}, metrics.Rejected)
+
+	// A captured envelope published again is a replay, while the relay
+	// publishing an event again signs a new envelope
+	relayed := events.PubSubSessionEvent{
+		SessionEvent: events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil),
+		ClientID:     "relay",
+	}
+	signed, err := events.EncodeSignedPubSubEvent(events.MsgpackCodec, keys, relayed)
+	require.Nil(t, err)
+	// Envelopes are signed with millisecond timestamps
+	time.Sleep(2 * time.Millisecond)
+	resigned, err := events.EncodeSignedPubSubEvent(events.MsgpackCodec, keys, relayed)
+	require.Nil(t, err)
+	last := events.PubSubSessionEvent{
+		SessionEvent: events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil),
+		ClientID:     "relay",
+	}
+	// The last event is published in order after the others
+	after, err := events.EncodeSignedPubSubEvent(events.MsgpackCodec, keys, last)
+	require.Nil(t, err)
+	ch := sub.Channel()
+	for _, step := range []struct {
+		envelopes [][]byte
+		expected  string
+	}{
+		{[][]byte{signed, signed, resigned}, relayed.ID},
+		{[][]byte{after}, last.ID},
+	} {
+		for _, b := range step.envelopes {
+			res := topic.Publish(ctx, &gpubsub.Message{Data: b, OrderingKey: "session"})
+			_, err := res.Get(ctx)
+			require.Nil(t, err)
+		}
+		select {
+		case v := <-ch:
+			require.Equal(t, step.expected, v.(events.SessionEvent).ID)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "Timed out waiting for the event")
+		}
+	}
+	metrics = broker.Health().Metrics
+	require.Equal(t, int64(3), metrics.Received)
+	require.Equal(t, map[string]int64{
+		BrokerRejectUnsigned:         1,
+		BrokerRejectInvalidSignature: 1,
+		BrokerRejectReplayed:         1,
+	}, metrics.Rejected)
+}
@@ -2,52 +2,100 @@ package services
 
 import (
 	repos "air-sync/repositories"
+	"context"
 
+	log "github.com/sirupsen/logrus"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
+// Immediate transactions make concurrent writers wait on the busy timeout
+// instead of failing when a read lock can't be upgraded.
+const defaultSqliteDSN = "airsync.db?_busy_timeout=5000&_txlock=immediate"
+
 type GormRepositoryService struct {
+	context              context.Context
 	dialector            gorm.Dialector
+	db                   *gorm.DB
 	sessionRepository    *repos.SessionSqlRepository
 	attachmentRepository *repos.AttachmentSqlRepository
+	outboxRepository     *repos.OutboxSqlRepository
+	leaseRepository      *repos.LeaseSqlRepository
+	cronRunRepository    *repos.CronRunSqlRepository
+	migrator             *repos.Migrator
 	initialized          bool
 }
 
 var _ RepositoryService = (*GormRepositoryService)(nil)
+var _ Initializer = (*GormRepositoryService)(nil)
 
-func NewGormRepositoryService(dialector gorm.Dialector) *GormRepositoryService {
+func NewGormRepositoryService(ctx context.Context, dialector gorm.Dialector) *GormRepositoryService {
 	return &GormRepositoryService{
+		context:     ctx,
 		dialector:   dialector,
 		initialized: false,
 	}
 }
 
+func NewGormDialector(driver DatabaseDriver, dsn string) gorm.Dialector {
+	switch driver {
+	case DatabaseDriverPostgres:
+		return postgres.Open(dsn)
+	default:
+		if dsn == "" {
+			dsn = defaultSqliteDSN
+		}
+		return sqlite.Open(dsn)
+	}
+}
+
 func (s *GormRepositoryService) Initialize() error {
 	if s.initialized {
 		return ErrAlreadyInitialized
 	}
 
-	db, err := gorm.Open(s.dialector, &gorm.Config{})
+	log.Infof("Connecting to SQL database: %s", s.dialector.Name())
+	db, err := gorm.Open(s.dialector, &gorm.Config{
+		// Messages may have no attachment, so the relation can't be enforced
+		DisableForeignKeyConstraintWhenMigrating: true,
+		Logger:                                   logger.Default.LogMode(logger.Warn),
+	})
 	if err != nil {
 		return err
 	}
+	s.db = db
+	log.Infof("Connected to SQL database")
 
-	sessionRepo := repos.NewSessionSqlRepository(db)
-	if err := sessionRepo.Migrate(); err != nil {
-		return err
-	}
-	s.sessionRepository = sessionRepo
-
-	attachmentRepo := repos.NewAttachmentSqlRepository(db)
-	if err := attachmentRepo.Migrate(); err != nil {
-		return err
-	}
-	s.attachmentRepository = attachmentRepo
+	s.sessionRepository = repos.NewSessionSqlRepository(db)
+	s.attachmentRepository = repos.NewAttachmentSqlRepository(db)
+	s.outboxRepository = repos.NewOutboxSqlRepository(db)
+	s.leaseRepository = repos.NewLeaseSqlRepository(db)
+	s.cronRunRepository = repos.NewCronRunSqlRepository(db)
+	s.migrator = repos.NewSqlMigrator(db)
 
 	s.initialized = true
 	return nil
 }
 
+func (s *GormRepositoryService) Deinitialize() {
+	if !s.initialized {
+		log.Error(ErrNotInitialized)
+		return
+	}
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if err := sqlDB.Close(); err != nil {
+		log.Error(err)
+	}
+	s.initialized = false
+}
+
 func (s *GormRepositoryService) SessionRepository() repos.SessionRepository {
 	return s.sessionRepository
 }
@@ -55,3 +103,19 @@ func (s *GormRepositoryService) SessionRepository() repos.SessionRepository {
 func (s *GormRepositoryService) AttachmentRepository() repos.AttachmentRepository {
 	return s.attachmentRepository
 }
+
+func (s *GormRepositoryService) OutboxRepository() repos.OutboxRepository {
+	return s.outboxRepository
+}
+
+func (s *GormRepositoryService) LeaseRepository() repos.LeaseRepository {
+	return s.leaseRepository
+}
+
+func (s *GormRepositoryService) CronRunRepository() repos.CronRunRepository {
+	return s.cronRunRepository
+}
+
+func (s *GormRepositoryService) Migrator() *repos.Migrator {
+	return s.migrator
+}
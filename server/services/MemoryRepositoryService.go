@@ -0,0 +1,74 @@
+package services
+
+import (
+	repos "air-sync/repositories"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type MemoryRepositoryService struct {
+	sessionRepository    *repos.SessionMemoryRepository
+	attachmentRepository *repos.AttachmentMemoryRepository
+	outboxRepository     *repos.OutboxMemoryRepository
+	leaseRepository      *repos.LeaseMemoryRepository
+	cronRunRepository    *repos.CronRunMemoryRepository
+	migrator             *repos.Migrator
+	initialized          bool
+}
+
+var _ RepositoryService = (*MemoryRepositoryService)(nil)
+var _ Initializer = (*MemoryRepositoryService)(nil)
+
+func NewMemoryRepositoryService() *MemoryRepositoryService {
+	return &MemoryRepositoryService{
+		initialized: false,
+	}
+}
+
+func (s *MemoryRepositoryService) Initialize() error {
+	if s.initialized {
+		return ErrAlreadyInitialized
+	}
+	log.Warn("Using in-memory database, all records will be lost on shutdown")
+	db := repos.NewMemoryDatabase()
+	s.sessionRepository = repos.NewSessionMemoryRepository(db)
+	s.attachmentRepository = repos.NewAttachmentMemoryRepository(db)
+	s.outboxRepository = repos.NewOutboxMemoryRepository(db)
+	s.leaseRepository = repos.NewLeaseMemoryRepository(db)
+	s.cronRunRepository = repos.NewCronRunMemoryRepository(db)
+	s.migrator = repos.NewMemoryMigrator()
+	s.initialized = true
+	return nil
+}
+
+func (s *MemoryRepositoryService) Deinitialize() {
+	if !s.initialized {
+		log.Error(ErrNotInitialized)
+		return
+	}
+	s.initialized = false
+}
+
+func (s *MemoryRepositoryService) SessionRepository() repos.SessionRepository {
+	return s.sessionRepository
+}
+
+func (s *MemoryRepositoryService) AttachmentRepository() repos.AttachmentRepository {
+	return s.attachmentRepository
+}
+
+func (s *MemoryRepositoryService) OutboxRepository() repos.OutboxRepository {
+	return s.outboxRepository
+}
+
+func (s *MemoryRepositoryService) LeaseRepository() repos.LeaseRepository {
+	return s.leaseRepository
+}
+
+func (s *MemoryRepositoryService) CronRunRepository() repos.CronRunRepository {
+	return s.cronRunRepository
+}
+
+func (s *MemoryRepositoryService) Migrator() *repos.Migrator {
+	return s.migrator
+}
@@ -0,0 +1,450 @@
+package services
+
+import (
+	"air-sync/models/events"
+	"air-sync/util"
+	"air-sync/util/pubsub"
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	MongoEventCollection       = "session_events"
+	MongoResumeTokenCollection = "event_resume_tokens"
+
+	DefaultMongoEventRetention = time.Hour
+
+	mongoEventTTLIndex = "created_at_ttl"
+)
+
+const (
+	// Returned when an index exists with other options
+	mongoIndexOptionsConflict = 85
+	// Returned when the resume token is no longer in the oplog
+	mongoChangeStreamFatalError = 280
+	mongoChangeStreamHistory    = 286
+	mongoDuplicateKey           = 11000
+)
+
+type MongoBrokerOptions struct {
+	Publisher *pubsub.Publisher
+	URL       *url.URL
+	Database  string
+	// InstanceID names the instance across restarts. When set, the position
+	// in the change stream is stored so a restarted instance receives the
+	// events published while it was down. Reconnecting resumes from the
+	// position either way.
+	InstanceID string
+	Retention  time.Duration
+}
+
+type MongoBrokerService struct {
+	brokerHealth
+	context context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	pub *pubsub.Publisher
+
+	url        *url.URL
+	database   string
+	instanceID string
+	retention  time.Duration
+
+	client *mongo.Client
+	events *mongo.Collection
+	tokens *mongo.Collection
+	stream *mongo.ChangeStream
+	// resumeToken is the position after the last handled change
+	resumeToken bson.Raw
+	tokenMu     sync.Mutex
+
+	clientID string
+	seen     *util.SeenSet
+
+	initialized bool
+}
+
+type mongoEventRecord struct {
+	ID       string `bson:"_id"`
+	ClientID string `bson:"client_id"`
+	// Payload is the JSON encoded event, so values decode the same way as
+	// with the other brokers
+	Payload   string    `bson:"payload"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+type mongoChangeEvent struct {
+	FullDocument mongoEventRecord `bson:"fullDocument"`
+}
+
+var _ Initializer = (*MongoBrokerService)(nil)
+var _ HealthReporter = (*MongoBrokerService)(nil)
+var _ EventPublisher = (*MongoBrokerService)(nil)
+
+func NewMongoBrokerService(ctx context.Context, opts MongoBrokerOptions) *MongoBrokerService {
+	ctx, cancel := context.WithCancel(ctx)
+	clientID := opts.InstanceID
+	if clientID == "" {
+		clientID = uuid.NewV4().String()
+	}
+	retention := opts.Retention
+	if retention <= 0 {
+		retention = DefaultMongoEventRetention
+	}
+	return &MongoBrokerService{
+		brokerHealth: newBrokerHealth(EventServiceMongo),
+		context:      ctx,
+		cancel:       cancel,
+		pub:          opts.Publisher,
+		url:          opts.URL,
+		database:     opts.Database,
+		instanceID:   opts.InstanceID,
+		retention:    retention,
+		clientID:     clientID,
+		seen:         newSeenEvents(),
+		initialized:  false,
+	}
+}
+
+func (s *MongoBrokerService) Initialize() error {
+	if s.initialized {
+		return ErrAlreadyInitialized
+	}
+
+	log.Infof("Connecting to MongoDB event broker: %s", s.url.Host)
+	client, err := mongo.Connect(s.context, options.Client().ApplyURI(s.url.String()))
+	if err != nil {
+		return err
+	}
+	s.client = client
+	if err := client.Ping(s.context, nil); err != nil {
+		s.disconnect()
+		return err
+	}
+	db := client.Database(s.database)
+	s.events = db.Collection(MongoEventCollection)
+	s.tokens = db.Collection(MongoResumeTokenCollection)
+	if err := s.ensureRetention(); err != nil {
+		s.disconnect()
+		return err
+	}
+
+	// Opening the stream up front fails early on deployments without change
+	// streams, such as a standalone server
+	stream, err := s.watch(nil)
+	if err != nil {
+		s.disconnect()
+		return err
+	}
+	s.stream = stream
+	s.setHealth(BrokerStateConnected, nil)
+
+	s.handlePublishingAsync()
+	s.handleSubscriptionAsync()
+	log.Infof("Publishing and subscribing to MongoDB collection: %s", MongoEventCollection)
+
+	s.initialized = true
+	return nil
+}
+
+func (s *MongoBrokerService) Deinitialize() {
+	if !s.initialized {
+		log.Error(ErrNotInitialized)
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+	s.disconnect()
+	s.setHealth(BrokerStateDisconnected, nil)
+	s.initialized = false
+}
+
+// ensureRetention expires the stored events after the retention period.
+func (s *MongoBrokerService) ensureRetention() error {
+	seconds := int32(s.retention / time.Second)
+	_, err := s.events.Indexes().CreateOne(s.context, mongo.IndexModel{
+		Keys: bson.M{"created_at": 1},
+		Options: options.Index().
+			SetName(mongoEventTTLIndex).
+			SetExpireAfterSeconds(seconds),
+	})
+	if !hasMongoErrorCode(err, mongoIndexOptionsConflict) {
+		return err
+	}
+	// The retention changed since the index was created
+	return s.events.Database().RunCommand(s.context, bson.D{
+		{Key: "collMod", Value: MongoEventCollection},
+		{Key: "index", Value: bson.M{
+			"name":               mongoEventTTLIndex,
+			"expireAfterSeconds": seconds,
+		}},
+	}).Err()
+}
+
+// watch opens a change stream of the inserted events, resuming after the
+// token, or the stored one when there is none.
+func (s *MongoBrokerService) watch(token bson.Raw) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": "insert"}}},
+	}
+	if token == nil {
+		var err error
+		if token, err = s.loadResumeToken(); err != nil {
+			return nil, err
+		}
+	}
+	if token == nil {
+		return s.events.Watch(s.context, pipeline)
+	}
+	stream, err := s.events.Watch(s.context, pipeline, options.ChangeStream().SetResumeAfter(token))
+	if hasMongoErrorCode(err, mongoChangeStreamHistory, mongoChangeStreamFatalError) {
+		log.Warnf("MongoDB event broker can't resume, events may have been missed: %v", err)
+		return s.events.Watch(s.context, pipeline)
+	}
+	return stream, err
+}
+
+// lastResumeToken returns the position the stream got to, or the stored one
+// before it got anywhere.
+func (s *MongoBrokerService) lastResumeToken() (bson.Raw, error) {
+	s.tokenMu.Lock()
+	token := s.resumeToken
+	s.tokenMu.Unlock()
+	if token != nil {
+		return token, nil
+	}
+	return s.loadResumeToken()
+}
+
+func (s *MongoBrokerService) setResumeToken(token bson.Raw) {
+	if token == nil {
+		return
+	}
+	s.tokenMu.Lock()
+	defer s.tokenMu.Unlock()
+	s.resumeToken = token
+}
+
+func (s *MongoBrokerService) loadResumeToken() (bson.Raw, error) {
+	if s.instanceID == "" {
+		return nil, nil
+	}
+	record := struct {
+		Token bson.Raw `bson:"token"`
+	}{}
+	err := s.tokens.FindOne(s.context, bson.M{"_id": s.instanceID}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return record.Token, err
+}
+
+func (s *MongoBrokerService) saveResumeToken(token bson.Raw) error {
+	if s.instanceID == "" || token == nil {
+		return nil
+	}
+	_, err := s.tokens.UpdateOne(
+		s.context,
+		bson.M{"_id": s.instanceID},
+		bson.M{"$set": bson.M{
+			"token":      token,
+			"updated_at": time.Now(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *MongoBrokerService) handlePublishingAsync() {
+	s.pub.Topic(events.EventSession).Subscribe().
+		ForEachAsync(s.context, s.handlePublishing, s.handleError)
+}
+
+func (s *MongoBrokerService) handlePublishing(v interface{}) error {
+	event, ok := v.(events.SessionEvent)
+	if !ok {
+		return nil
+	}
+	// Events received from MongoDB are published locally too
+	if !s.seen.Add(event.ID) {
+		return nil
+	}
+	return s.publish(event)
+}
+
+func (s *MongoBrokerService) PublishEvent(event events.SessionEvent) error {
+	if err := s.publish(event); err != nil {
+		return err
+	}
+	s.seen.Add(event.ID)
+	return nil
+}
+
+func (s *MongoBrokerService) publish(event events.SessionEvent) error {
+	b, err := events.EncodePubSubEvent(events.JSONCodec, events.PubSubSessionEvent{
+		SessionEvent: event,
+		ClientID:     s.clientID,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.events.InsertOne(s.context, mongoEventRecord{
+		ID:        event.ID,
+		ClientID:  s.clientID,
+		Payload:   string(b),
+		CreatedAt: time.Now(),
+	})
+	if isMongoDuplicateEvent(err) {
+		// Retried after the event was stored
+		err = nil
+	}
+	s.reportPublish(err)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"id":        event.ID,
+		"event":     event.Event,
+		"timestamp": event.Timestamp,
+	}).Infof("MongoDB published event")
+	return nil
+}
+
+func (s *MongoBrokerService) handleSubscriptionAsync() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.handleSubscription()
+	}()
+}
+
+// handleSubscription tails the change stream, reopening it from the last
+// seen event with an increasing delay whenever it fails. The position is kept
+// in memory, so events written while reconnecting aren't missed even without
+// an instance ID.
+func (s *MongoBrokerService) handleSubscription() {
+	backoff := newBrokerBackoff()
+	stream := s.stream
+	for {
+		if stream != nil {
+			err := s.handleStream(stream)
+			// Streams without changes still move past the idle oplog
+			s.setResumeToken(stream.ResumeToken())
+			stream.Close(context.Background())
+			if s.context.Err() != nil {
+				return
+			}
+			s.handleError(err)
+			s.setHealth(BrokerStateReconnecting, err)
+		}
+		if !backoff.Wait(s.context) {
+			return
+		}
+		token, err := s.lastResumeToken()
+		if err != nil {
+			s.handleError(err)
+			s.setHealth(BrokerStateReconnecting, err)
+			stream = nil
+			continue
+		}
+		res, err := s.watch(token)
+		if err != nil {
+			s.handleError(err)
+			s.setHealth(BrokerStateReconnecting, err)
+			stream = nil
+			continue
+		}
+		backoff.Reset()
+		s.setHealth(BrokerStateConnected, nil)
+		stream = res
+	}
+}
+
+func (s *MongoBrokerService) handleStream(stream *mongo.ChangeStream) error {
+	for stream.Next(s.context) {
+		change := mongoChangeEvent{}
+		if err := stream.Decode(&change); err != nil {
+			return err
+		}
+		if err := s.handleSubscriptionMessage(change.FullDocument); err != nil {
+			s.handleError(err)
+		}
+		token := stream.ResumeToken()
+		s.setResumeToken(token)
+		if err := s.saveResumeToken(token); err != nil {
+			s.handleError(err)
+		}
+	}
+	return stream.Err()
+}
+
+func (s *MongoBrokerService) handleSubscriptionMessage(record mongoEventRecord) error {
+	// Prevent pubsub self-loop
+	if record.ClientID == s.clientID {
+		return nil
+	}
+	event, err := events.DecodePubSubEvent(events.JSONCodec, []byte(record.Payload))
+	if err != nil {
+		return s.reportRejected(err)
+	}
+	if !s.seen.Add(event.ID) {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"id":        event.ID,
+		"event":     event.Event,
+		"timestamp": event.Timestamp,
+	}).Infof("MongoDB received event")
+	s.reportReceived()
+	s.pub.Topic(events.EventSession).Publish(event.SessionEvent)
+	return nil
+}
+
+func (s *MongoBrokerService) disconnect() {
+	if s.client != nil {
+		if err := s.client.Disconnect(context.Background()); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+func (s *MongoBrokerService) handleError(err error) {
+	log.Error(err)
+}
+
+func hasMongoErrorCode(err error, codes ...int32) bool {
+	cmdErr := mongo.CommandError{}
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	for _, code := range codes {
+		if cmdErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func isMongoDuplicateEvent(err error) bool {
+	writeErr := mongo.WriteException{}
+	if !errors.As(err, &writeErr) {
+		return false
+	}
+	for _, e := range writeErr.WriteErrors {
+		if e.Code == mongoDuplicateKey {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"air-sync/models/events"
+	"air-sync/util/pubsub"
+	"context"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Change streams need a replica set, which can be a single local node such
+// as the mongo-replset service of docker/airsync/docker-compose.yml
+func TestMongoBrokerService(t *testing.T) {
+	uri := os.Getenv("MONGODB_REPLSET_URI")
+	if uri == "" {
+		t.Skip("Requires MONGODB_REPLSET_URI env to test")
+		return
+	}
+	u, err := url.Parse(uri)
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	require.Nil(t, err)
+	defer client.Disconnect(ctx)
+	require.Nil(t, client.Database("airsync-broker-test").Drop(ctx))
+
+	newBroker := func(pub *pubsub.Publisher, instanceID string) *MongoBrokerService {
+		broker := NewMongoBrokerService(ctx, MongoBrokerOptions{
+			Publisher:  pub,
+			URL:        u,
+			Database:   "airsync-broker-test",
+			InstanceID: instanceID,
+		})
+		require.Nil(t, broker.Initialize())
+		return broker
+	}
+	receive := func(sub *pubsub.Subscriber) events.SessionEvent {
+		select {
+		case v := <-sub.Channel():
+			event, ok := v.(events.SessionEvent)
+			require.True(t, ok)
+			return event
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "Timed out waiting for the event")
+			return events.SessionEvent{}
+		}
+	}
+
+	sender := pubsub.NewPublisher()
+	defer newBroker(sender, "").Deinitialize()
+	receiver := pubsub.NewPublisher()
+	broker := newBroker(receiver, "receiver")
+	sub := receiver.Topic(events.EventSession).Subscribe()
+	defer sub.Unsubscribe()
+
+	event := events.CreateSessionEvent("session", events.EventSessionDeleted, events.SessionDelete("session"), nil)
+	sender.Topic(events.EventSession).Publish(event)
+	require.Equal(t, event.ID, receive(sub).ID)
+
+	// Events published while the instance is down are received on restart
+	broker.Deinitialize()
+	missed := events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil)
+	sender.Topic(events.EventSession).Publish(missed)
+	time.Sleep(500 * time.Millisecond)
+	broker = newBroker(receiver, "receiver")
+	defer broker.Deinitialize()
+	require.Equal(t, missed.ID, receive(sub).ID)
+
+	// Without an instance ID, reconnecting still resumes from the position
+	// the stream got to
+	anonymous := pubsub.NewPublisher()
+	anonymousBroker := newBroker(anonymous, "")
+	defer anonymousBroker.Deinitialize()
+	anonymousSub := anonymous.Topic(events.EventSession).Subscribe()
+	defer anonymousSub.Unsubscribe()
+	event = events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil)
+	sender.Topic(events.EventSession).Publish(event)
+	require.Equal(t, event.ID, receive(anonymousSub).ID)
+	token, err := anonymousBroker.lastResumeToken()
+	require.Nil(t, err)
+	require.NotNil(t, token)
+	missed = events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil)
+	sender.Topic(events.EventSession).Publish(missed)
+	require.Equal(t, missed.ID, receive(anonymousSub).ID)
+	stream, err := anonymousBroker.watch(token)
+	require.Nil(t, err)
+	defer stream.Close(ctx)
+	require.True(t, stream.Next(ctx))
+	change := mongoChangeEvent{}
+	require.Nil(t, stream.Decode(&change))
+	require.Equal(t, missed.ID, change.FullDocument.ID)
+}
@@ -14,7 +14,6 @@ import (
 type MongoRepositoryOptions struct {
 	URL      *url.URL
 	Database string
-	Recreate bool
 }
 
 type MongoRepositoryService struct {
@@ -24,7 +23,10 @@ type MongoRepositoryService struct {
 	database             string
 	sessionRepository    *repos.SessionMongoRepository
 	attachmentRepository *repos.AttachmentMongoRepository
-	recreate             bool
+	outboxRepository     *repos.OutboxMongoRepository
+	leaseRepository      *repos.LeaseMongoRepository
+	cronRunRepository    *repos.CronRunMongoRepository
+	migrator             *repos.Migrator
 	initialized          bool
 }
 
@@ -32,10 +34,9 @@ var _ RepositoryService = (*MongoRepositoryService)(nil)
 
 func NewMongoRepositoryService(ctx context.Context, opts MongoRepositoryOptions) *MongoRepositoryService {
 	return &MongoRepositoryService{
-		context:     context.Background(),
+		context:     ctx,
 		url:         opts.URL,
 		database:    opts.Database,
-		recreate:    opts.Recreate,
 		initialized: false,
 	}
 }
@@ -60,20 +61,13 @@ func (s *MongoRepositoryService) Initialize() error {
 	db := client.Database(s.database)
 	opts := repos.MongoOptions{
 		Database: db,
-		Recreate: s.recreate,
 	}
-
-	sessionRepo := repos.NewSessionMongoRepository(s.context, opts)
-	if err := sessionRepo.Migrate(); err != nil {
-		return err
-	}
-	s.sessionRepository = sessionRepo
-
-	attachmentRepo := repos.NewAttachmentMongoRepository(s.context, opts)
-	if err := attachmentRepo.Migrate(); err != nil {
-		return err
-	}
-	s.attachmentRepository = attachmentRepo
+	s.sessionRepository = repos.NewSessionMongoRepository(opts)
+	s.attachmentRepository = repos.NewAttachmentMongoRepository(opts)
+	s.outboxRepository = repos.NewOutboxMongoRepository(opts)
+	s.leaseRepository = repos.NewLeaseMongoRepository(opts)
+	s.cronRunRepository = repos.NewCronRunMongoRepository(opts)
+	s.migrator = repos.NewMongoMigrator(opts)
 
 	s.initialized = true
 	return nil
@@ -84,7 +78,7 @@ func (s *MongoRepositoryService) Deinitialize() {
 		log.Error(ErrNotInitialized)
 		return
 	}
-	if err := s.client.Disconnect(s.context); err != nil {
+	if err := s.client.Disconnect(context.Background()); err != nil {
 		log.Error(err)
 	}
 }
@@ -97,9 +91,25 @@ func (s *MongoRepositoryService) AttachmentRepository() repos.AttachmentReposito
 	return s.attachmentRepository
 }
 
+func (s *MongoRepositoryService) OutboxRepository() repos.OutboxRepository {
+	return s.outboxRepository
+}
+
+func (s *MongoRepositoryService) LeaseRepository() repos.LeaseRepository {
+	return s.leaseRepository
+}
+
+func (s *MongoRepositoryService) CronRunRepository() repos.CronRunRepository {
+	return s.cronRunRepository
+}
+
+func (s *MongoRepositoryService) Migrator() *repos.Migrator {
+	return s.migrator
+}
+
 func (s *MongoRepositoryService) disconnect() {
 	if s.client != nil {
-		err := s.client.Disconnect(s.context)
+		err := s.client.Disconnect(context.Background())
 		if err != nil {
 			log.Error(err)
 		}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"air-sync/models/events"
+	"air-sync/util"
+	"air-sync/util/pubsub"
+	"context"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	NatsSubjectPrefix = "airsync.session."
+	DefaultNatsStream = "AIRSYNC"
+	DefaultNatsMaxAge = time.Hour
+
+	natsSessionWildcard = NatsSubjectPrefix + "*"
+	natsReconnectWait   = 2 * time.Second
+	natsDrainTimeout    = 5 * time.Second
+)
+
+type NatsBrokerOptions struct {
+	Publisher *pubsub.Publisher
+	// URL may list several servers separated by commas
+	URL             string
+	CredentialsFile string
+	TLSCAFile       string
+	TLSCertFile     string
+	TLSKeyFile      string
+	// JetStream stores the events in a stream, so that a durable consumer
+	// replays the ones published while the instance was down
+	JetStream bool
+	Stream    string
+	// Durable names the consumer of this instance, so it has to be unique
+	// to each of them
+	Durable string
+	MaxAge  time.Duration
+}
+
+type NatsBrokerService struct {
+	NatsBrokerOptions
+	brokerHealth
+	context context.Context
+	cancel  context.CancelFunc
+
+	pub *pubsub.Publisher
+
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+	sub    *nats.Subscription
+	closed chan struct{}
+
+	clientID string
+	seen     *util.SeenSet
+
+	initialized bool
+}
+
+var _ Initializer = (*NatsBrokerService)(nil)
+var _ HealthReporter = (*NatsBrokerService)(nil)
+var _ EventPublisher = (*NatsBrokerService)(nil)
+
+func NewNatsBrokerService(ctx context.Context, opts NatsBrokerOptions) *NatsBrokerService {
+	if opts.Stream == "" {
+		opts.Stream = DefaultNatsStream
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = DefaultNatsMaxAge
+	}
+	// A durable consumer replays the events of the previous run, which must
+	// still be recognized as our own
+	clientID := opts.Durable
+	if clientID == "" {
+		clientID = uuid.NewV4().String()
+	}
+	return &NatsBrokerService{
+		NatsBrokerOptions: opts,
+		brokerHealth:      newBrokerHealth(EventServiceNats),
+		context:           ctx,
+		pub:               opts.Publisher,
+		clientID:          clientID,
+		seen:              newSeenEvents(),
+		initialized:       false,
+	}
+}
+
+// NatsSessionSubject returns the subject the events of a session are
+// published on.
+func NatsSessionSubject(id string) string {
+	return NatsSubjectPrefix + id
+}
+
+func (s *NatsBrokerService) Initialize() error {
+	if s.initialized {
+		return ErrAlreadyInitialized
+	}
+
+	log.Infof("Connecting to NATS: %s", s.URL)
+	s.closed = make(chan struct{})
+	conn, err := nats.Connect(s.URL, s.connectOptions()...)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	log.Infof("Connected to NATS: %s", conn.ConnectedUrl())
+
+	if s.JetStream {
+		if err := s.setupJetStream(); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	if err := s.subscribe(); err != nil {
+		conn.Close()
+		return err
+	}
+	// Make sure the server knows the subscription before anything is sent
+	if err := conn.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+	ctx, cancel := context.WithCancel(s.context)
+	s.cancel = cancel
+	s.handlePublishingAsync(ctx)
+	log.Infof("Publishing and subscribing to NATS subjects: %s", natsSessionWildcard)
+	s.setHealth(BrokerStateConnected, nil)
+
+	s.initialized = true
+	return nil
+}
+
+func (s *NatsBrokerService) Deinitialize() {
+	if !s.initialized {
+		log.Error(ErrNotInitialized)
+		return
+	}
+	s.cancel()
+	// Draining lets the received events be handled before closing
+	if err := s.conn.Drain(); err != nil {
+		log.Error(err)
+		s.conn.Close()
+	}
+	select {
+	case <-s.closed:
+	case <-time.After(natsDrainTimeout):
+		s.conn.Close()
+	}
+	s.initialized = false
+}
+
+func (s *NatsBrokerService) connectOptions() []nats.Option {
+	opts := []nats.Option{
+		nats.Name("air-sync " + s.clientID),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(natsReconnectWait),
+		nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+			if err != nil {
+				log.Warnf("Disconnected from NATS: %v", err)
+			}
+			// The client resubscribes on its own once reconnected
+			s.setHealth(BrokerStateReconnecting, err)
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			log.Infof("Reconnected to NATS: %s", conn.ConnectedUrl())
+			s.setHealth(BrokerStateConnected, nil)
+		}),
+		nats.ClosedHandler(func(conn *nats.Conn) {
+			s.setHealth(BrokerStateDisconnected, nil)
+			close(s.closed)
+		}),
+		nats.ErrorHandler(func(conn *nats.Conn, sub *nats.Subscription, err error) {
+			s.handleError(err)
+		}),
+	}
+	if s.CredentialsFile != "" {
+		opts = append(opts, nats.UserCredentials(s.CredentialsFile))
+	}
+	if s.TLSCAFile != "" {
+		opts = append(opts, nats.RootCAs(s.TLSCAFile))
+	}
+	if s.TLSCertFile != "" || s.TLSKeyFile != "" {
+		opts = append(opts, nats.ClientCert(s.TLSCertFile, s.TLSKeyFile))
+	}
+	return opts
+}
+
+// setupJetStream creates the stream of session events unless it exists.
+func (s *NatsBrokerService) setupJetStream() error {
+	js, err := s.conn.JetStream()
+	if err != nil {
+		return err
+	}
+	s.js = js
+	if _, err := js.StreamInfo(s.Stream); err == nil {
+		return nil
+	}
+	log.Infof("Creating NATS JetStream stream: %s", s.Stream)
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     s.Stream,
+		Subjects: []string{natsSessionWildcard},
+		MaxAge:   s.MaxAge,
+		Storage:  nats.FileStorage,
+	})
+	return err
+}
+
+func (s *NatsBrokerService) subscribe() error {
+	if s.js == nil {
+		sub, err := s.conn.Subscribe(natsSessionWildcard, s.handleSubscriptionMessage)
+		s.sub = sub
+		return err
+	}
+	opts := []nats.SubOpt{nats.DeliverNew()}
+	if s.Durable != "" {
+		// An existing durable consumer resumes from its last acknowledged event
+		opts = append(opts, nats.Durable(s.Durable))
+	}
+	sub, err := s.js.Subscribe(natsSessionWildcard, s.handleSubscriptionMessage, opts...)
+	s.sub = sub
+	return err
+}
+
+// handlePublishingAsync forwards the local events until ctx is done, so a
+// deinitialized broker stops publishing.
+func (s *NatsBrokerService) handlePublishingAsync(ctx context.Context) {
+	sub := s.pub.Topic(events.EventSession).Subscribe()
+	go func() {
+		defer sub.Unsubscribe()
+		sub.ForEach(ctx, s.handlePublishing, s.handleError)
+	}()
+}
+
+func (s *NatsBrokerService) handlePublishing(v interface{}) error {
+	event, ok := v.(events.SessionEvent)
+	if !ok {
+		return nil
+	}
+	// Events received from NATS are published locally too
+	if !s.seen.Add(event.ID) {
+		return nil
+	}
+	return s.publish(event)
+}
+
+func (s *NatsBrokerService) PublishEvent(event events.SessionEvent) error {
+	if err := s.publish(event); err != nil {
+		return err
+	}
+	s.seen.Add(event.ID)
+	return nil
+}
+
+func (s *NatsBrokerService) publish(event events.SessionEvent) error {
+	b, err := events.EncodePubSubEvent(events.JSONCodec, events.PubSubSessionEvent{
+		SessionEvent: event,
+		ClientID:     s.clientID,
+	})
+	if err != nil {
+		return err
+	}
+	subject := NatsSessionSubject(event.SessionID)
+	if s.js != nil {
+		// The event ID lets JetStream drop duplicates of retried publishes
+		_, err = s.js.Publish(subject, b, nats.MsgId(event.ID))
+	} else {
+		err = s.conn.Publish(subject, b)
+	}
+	s.reportPublish(err)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"id":        event.ID,
+		"event":     event.Event,
+		"subject":   subject,
+		"timestamp": event.Timestamp,
+	}).Infof("NATS published event")
+	return nil
+}
+
+func (s *NatsBrokerService) handleSubscriptionMessage(msg *nats.Msg) {
+	if !strings.HasPrefix(msg.Subject, NatsSubjectPrefix) {
+		return
+	}
+	event, err := events.DecodePubSubEvent(events.JSONCodec, msg.Data)
+	if err != nil {
+		s.handleError(s.reportRejected(err))
+		return
+	}
+	// Prevent pubsub self-loop
+	if event.ClientID == s.clientID {
+		return
+	}
+	// JetStream redelivers the events not acknowledged in time
+	if !s.seen.Add(event.ID) {
+		return
+	}
+	log.WithFields(log.Fields{
+		"id":        event.ID,
+		"event":     event.Event,
+		"subject":   msg.Subject,
+		"timestamp": event.Timestamp,
+	}).Infof("NATS received event")
+	s.reportReceived()
+	s.pub.Topic(events.EventSession).Publish(event.SessionEvent)
+}
+
+func (s *NatsBrokerService) handleError(err error) {
+	log.Error(err)
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"air-sync/models/events"
+	"air-sync/util/pubsub"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Runs against a local server started with JetStream enabled, such as the
+// nats service of docker/airsync/docker-compose.yml
+func TestNatsBrokerService(t *testing.T) {
+	url := os.Getenv("NATS_URL")
+	if url == "" {
+		t.Skip("Requires NATS_URL env to test")
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	receive := func(t *testing.T, sub *pubsub.Subscriber) events.SessionEvent {
+		select {
+		case v := <-sub.Channel():
+			event, ok := v.(events.SessionEvent)
+			require.True(t, ok)
+			return event
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "Timed out waiting for the event")
+			return events.SessionEvent{}
+		}
+	}
+	newBroker := func(t *testing.T, pub *pubsub.Publisher, opts NatsBrokerOptions) *NatsBrokerService {
+		opts.Publisher = pub
+		opts.URL = url
+		broker := NewNatsBrokerService(ctx, opts)
+		require.Nil(t, broker.Initialize())
+		return broker
+	}
+
+	t.Run("Core", func(t *testing.T) {
+		sender := pubsub.NewPublisher()
+		defer newBroker(t, sender, NatsBrokerOptions{}).Deinitialize()
+		receiver := pubsub.NewPublisher()
+		defer newBroker(t, receiver, NatsBrokerOptions{}).Deinitialize()
+		sub := receiver.Topic(events.EventSession).Subscribe()
+		defer sub.Unsubscribe()
+
+		event := events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil)
+		sender.Topic(events.EventSession).Publish(event)
+		received := receive(t, sub)
+		require.Equal(t, event.ID, received.ID)
+		require.Equal(t, "session", received.SessionID)
+
+		// Nothing comes back to the sending instance
+		own := sender.Topic(events.EventSession).Subscribe()
+		defer own.Unsubscribe()
+		select {
+		case v := <-own.Channel():
+			require.FailNow(t, "Received own event", "%v", v)
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+
+	t.Run("JetStream", func(t *testing.T) {
+		opts := NatsBrokerOptions{
+			JetStream: true,
+			Stream:    "AIRSYNC_TEST",
+			MaxAge:    time.Minute,
+		}
+		sender := pubsub.NewPublisher()
+		defer newBroker(t, sender, opts).Deinitialize()
+
+		receiver := pubsub.NewPublisher()
+		durable := opts
+		durable.Durable = fmt.Sprintf("receiver-%d", time.Now().UnixNano())
+		broker := newBroker(t, receiver, durable)
+		sub := receiver.Topic(events.EventSession).Subscribe()
+		defer sub.Unsubscribe()
+
+		event := events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil)
+		sender.Topic(events.EventSession).Publish(event)
+		require.Equal(t, event.ID, receive(t, sub).ID)
+
+		// Events published while the instance is down are replayed
+		broker.Deinitialize()
+		missed := events.CreateSessionEvent("session", events.EventSessionDeleted, nil, nil)
+		sender.Topic(events.EventSession).Publish(missed)
+		time.Sleep(200 * time.Millisecond)
+		broker = newBroker(t, receiver, durable)
+		defer broker.Deinitialize()
+		require.Equal(t, missed.ID, receive(t, sub).ID)
+	})
+}
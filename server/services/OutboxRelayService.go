@@ -0,0 +1,199 @@
+package services
+
+import (
+	"air-sync/models"
+	"air-sync/models/events"
+	repos "air-sync/repositories"
+	"air-sync/util"
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultOutboxRelayInterval bounds how long the entries of an instance
+	// which stopped before publishing them wait for another relay
+	DefaultOutboxRelayInterval = 5 * time.Second
+	DefaultOutboxRetention     = time.Hour
+
+	outboxBatchSize       = 100
+	outboxClaimDuration   = 30 * time.Second
+	outboxCleanupInterval = 10 * time.Minute
+)
+
+type OutboxRelayOptions struct {
+	Repository repos.OutboxRepository
+	Publisher  EventPublisher
+	// Interval is how often the pending entries are looked for without
+	// being notified
+	Interval time.Duration
+	// Retention is how long the delivered entries are kept
+	Retention time.Duration
+}
+
+// OutboxRelayService publishes the events recorded in the outbox and marks
+// them delivered, so a change reaches the other instances at least once
+// even when the broker failed or the instance stopped right after the
+// change.
+type OutboxRelayService struct {
+	context context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	repo      repos.OutboxRepository
+	publisher EventPublisher
+	interval  time.Duration
+	retention time.Duration
+	notify    chan struct{}
+	backoff   util.Backoff
+
+	initialized bool
+}
+
+var _ Initializer = (*OutboxRelayService)(nil)
+
+func NewOutboxRelayService(ctx context.Context, opts OutboxRelayOptions) *OutboxRelayService {
+	ctx, cancel := context.WithCancel(ctx)
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultOutboxRelayInterval
+	}
+	retention := opts.Retention
+	if retention <= 0 {
+		retention = DefaultOutboxRetention
+	}
+	return &OutboxRelayService{
+		context:     ctx,
+		cancel:      cancel,
+		repo:        opts.Repository,
+		publisher:   opts.Publisher,
+		interval:    interval,
+		retention:   retention,
+		notify:      make(chan struct{}, 1),
+		backoff:     newBrokerBackoff(),
+		initialized: false,
+	}
+}
+
+func (s *OutboxRelayService) Initialize() error {
+	if s.initialized {
+		return ErrAlreadyInitialized
+	}
+	s.wg.Add(1)
+	go s.run()
+	s.initialized = true
+	return nil
+}
+
+// Deinitialize stops relaying, leaving the pending entries to the next run
+// or to another instance.
+func (s *OutboxRelayService) Deinitialize() {
+	if !s.initialized {
+		log.Error(ErrNotInitialized)
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+	s.initialized = false
+}
+
+// Notify wakes the relay up after recording entries, without blocking.
+func (s *OutboxRelayService) Notify() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *OutboxRelayService) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(outboxCleanupInterval)
+	defer cleanup.Stop()
+	for {
+		if err := s.relay(); err != nil {
+			if s.context.Err() != nil {
+				return
+			}
+			s.handleError(err)
+			if !s.backoff.Wait(s.context) {
+				return
+			}
+			continue
+		}
+		s.backoff.Reset()
+		select {
+		case <-s.context.Done():
+			return
+		case <-s.notify:
+		case <-ticker.C:
+		case <-cleanup.C:
+			s.deleteDelivered()
+		}
+	}
+}
+
+// relay publishes the pending entries batch by batch, stopping at the first
+// one which fails to keep them in order.
+func (s *OutboxRelayService) relay() error {
+	for {
+		entries, err := s.repo.Claim(s.context, time.Now().Add(outboxClaimDuration), outboxBatchSize)
+		if err != nil {
+			return err
+		}
+		delivered := make([]string, 0, len(entries))
+		for idx, entry := range entries {
+			event, err := events.DecodePubSubEvent(events.JSONCodec, []byte(entry.Payload))
+			if err != nil {
+				// Retrying won't make it decode
+				log.WithField("id", entry.ID).Error(err)
+				delivered = append(delivered, entry.ID)
+				continue
+			}
+			if err := s.publisher.PublishEvent(event.SessionEvent); err != nil {
+				s.abort(delivered, entries[idx:])
+				return err
+			}
+			delivered = append(delivered, entry.ID)
+		}
+		if err := s.repo.MarkDelivered(s.context, delivered); err != nil {
+			return err
+		}
+		if len(entries) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+// abort marks the entries published so far delivered, and releases the
+// others for the retry.
+func (s *OutboxRelayService) abort(delivered []string, pending []models.OutboxEntry) {
+	if err := s.repo.MarkDelivered(s.context, delivered); err != nil {
+		s.handleError(err)
+	}
+	ids := make([]string, len(pending))
+	for idx, entry := range pending {
+		ids[idx] = entry.ID
+	}
+	if err := s.repo.Release(s.context, ids); err != nil {
+		s.handleError(err)
+	}
+}
+
+func (s *OutboxRelayService) deleteDelivered() {
+	n, err := s.repo.DeleteDelivered(s.context, time.Now().Add(-s.retention))
+	if err != nil {
+		s.handleError(err)
+		return
+	}
+	if n > 0 {
+		log.Infof("Outbox: deleted %d delivered event(s)", n)
+	}
+}
+
+func (s *OutboxRelayService) handleError(err error) {
+	log.Error(err)
+}
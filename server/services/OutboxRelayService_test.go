@@ -0,0 +1,78 @@
+package services
+
+import (
+	"air-sync/models/events"
+	repos "air-sync/repositories"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyPublisher fails the given number of publishes before accepting them.
+type flakyPublisher struct {
+	failures  int
+	published []events.SessionEvent
+	mu        sync.Mutex
+}
+
+func (p *flakyPublisher) PublishEvent(event events.SessionEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("Broker unavailable")
+	}
+	p.published = append(p.published, event)
+	return nil
+}
+
+func (p *flakyPublisher) events() []events.SessionEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]events.SessionEvent(nil), p.published...)
+}
+
+func TestOutboxRelayService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := repos.NewMemoryDatabase()
+	sessionRepo := repos.NewSessionMemoryRepository(db)
+	outboxRepo := repos.NewOutboxMemoryRepository(db)
+
+	publisher := &flakyPublisher{failures: 1}
+	relay := NewOutboxRelayService(ctx, OutboxRelayOptions{
+		Repository: outboxRepo,
+		Publisher:  publisher,
+		Interval:   time.Hour,
+	})
+	require.Nil(t, relay.Initialize())
+	defer relay.Deinitialize()
+
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	_, err = sessionRepo.Delete(ctx, session.ID)
+	require.Nil(t, err)
+	relay.Notify()
+
+	// The failed batch is retried after a delay
+	require.Eventually(t, func() bool {
+		return len(publisher.events()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	published := publisher.events()
+	require.Equal(t, events.EventSessionCreated, published[0].Event)
+	require.Equal(t, events.EventSessionDeleted, published[1].Event)
+	require.Equal(t, session.ID, published[1].SessionID)
+
+	// Delivered entries aren't published again
+	relay.Notify()
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 2, len(publisher.events()))
+	pending, err := outboxRepo.Claim(ctx, time.Now(), 10)
+	require.Nil(t, err)
+	require.Empty(t, pending)
+}
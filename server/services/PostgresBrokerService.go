@@ -0,0 +1,328 @@
+package services
+
+import (
+	"air-sync/models/events"
+	"air-sync/util"
+	"air-sync/util/pubsub"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jackc/pgx/v4"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	PostgresEventChannel = "airsync_session_events"
+	PostgresEventTable   = "airsync_session_events"
+
+	// NOTIFY payloads have to be shorter than 8000 bytes
+	postgresMaxPayload     = 7999
+	postgresEventRetention = time.Hour
+)
+
+type PostgresBrokerOptions struct {
+	Publisher *pubsub.Publisher
+	DSN       string
+}
+
+type PostgresBrokerService struct {
+	brokerHealth
+	context context.Context
+	cancel  context.CancelFunc
+
+	pub *pubsub.Publisher
+
+	db  *gorm.DB
+	dsn string
+
+	clientID string
+	seen     *util.SeenSet
+
+	initialized bool
+}
+
+// postgresNotification references the stored event when it's too large for
+// a notification, which otherwise carries the encoded event itself.
+type postgresNotification struct {
+	Ref      string `json:"ref,omitempty"`
+	ClientID string `json:"client_id"`
+}
+
+type postgresEventRecord struct {
+	ID        string
+	Payload   string
+	CreatedAt time.Time
+}
+
+var _ Initializer = (*PostgresBrokerService)(nil)
+var _ HealthReporter = (*PostgresBrokerService)(nil)
+var _ EventPublisher = (*PostgresBrokerService)(nil)
+
+func (postgresEventRecord) TableName() string {
+	return PostgresEventTable
+}
+
+func NewPostgresBrokerService(ctx context.Context, opts PostgresBrokerOptions) *PostgresBrokerService {
+	ctx, cancel := context.WithCancel(ctx)
+	return &PostgresBrokerService{
+		brokerHealth: newBrokerHealth(EventServicePostgres),
+		context:      ctx,
+		cancel:       cancel,
+		pub:          opts.Publisher,
+		dsn:          opts.DSN,
+		clientID:     uuid.NewV4().String(),
+		seen:         newSeenEvents(),
+		initialized:  false,
+	}
+}
+
+func (s *PostgresBrokerService) Initialize() error {
+	if s.initialized {
+		return ErrAlreadyInitialized
+	}
+
+	log.Info("Connecting to PostgreSQL event broker")
+	db, err := gorm.Open(postgres.Open(s.dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Warn),
+	})
+	if err != nil {
+		return err
+	}
+	s.db = db
+	err = db.WithContext(s.context).Exec(`CREATE TABLE IF NOT EXISTS ` + PostgresEventTable + ` (
+		id TEXT PRIMARY KEY,
+		payload TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL
+	)`).Error
+	if err != nil {
+		return err
+	}
+
+	// Listening up front makes sure no event published after initializing
+	// is missed
+	conn, err := s.listen()
+	if err != nil {
+		return err
+	}
+	log.Info("Connected to PostgreSQL event broker")
+	s.setHealth(BrokerStateConnected, nil)
+
+	s.handlePublishingAsync()
+	s.handleSubscriptionAsync(conn)
+	log.Infof("Publishing and subscribing to PostgreSQL channel: %s", PostgresEventChannel)
+
+	s.initialized = true
+	return nil
+}
+
+func (s *PostgresBrokerService) Deinitialize() {
+	if !s.initialized {
+		log.Error(ErrNotInitialized)
+		return
+	}
+	s.cancel()
+	if db, err := s.db.DB(); err == nil {
+		db.Close()
+	}
+	s.setHealth(BrokerStateDisconnected, nil)
+	s.initialized = false
+}
+
+func (s *PostgresBrokerService) listen() (*pgx.Conn, error) {
+	conn, err := pgx.Connect(s.context, s.dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(s.context, "LISTEN "+PostgresEventChannel); err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (s *PostgresBrokerService) handlePublishingAsync() {
+	s.pub.Topic(events.EventSession).Subscribe().
+		ForEachAsync(s.context, s.handlePublishing, s.handleError)
+}
+
+func (s *PostgresBrokerService) handlePublishing(v interface{}) error {
+	event, ok := v.(events.SessionEvent)
+	if !ok {
+		return nil
+	}
+	// Events received from PostgreSQL are published locally too
+	if !s.seen.Add(event.ID) {
+		return nil
+	}
+	return s.publish(event)
+}
+
+func (s *PostgresBrokerService) PublishEvent(event events.SessionEvent) error {
+	if err := s.publish(event); err != nil {
+		return err
+	}
+	s.seen.Add(event.ID)
+	return nil
+}
+
+func (s *PostgresBrokerService) publish(event events.SessionEvent) error {
+	b, err := events.EncodePubSubEvent(events.JSONCodec, events.PubSubSessionEvent{
+		SessionEvent: event,
+		ClientID:     s.clientID,
+	})
+	if err != nil {
+		return err
+	}
+	payload := string(b)
+	if len(payload) > postgresMaxPayload {
+		res, err := s.storeEvent(event.ID, payload)
+		if err != nil {
+			s.reportPublish(err)
+			return err
+		}
+		payload = res
+	}
+	err = s.db.WithContext(s.context).Exec("SELECT pg_notify(?, ?)", PostgresEventChannel, payload).Error
+	s.reportPublish(err)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"id":        event.ID,
+		"event":     event.Event,
+		"timestamp": event.Timestamp,
+	}).Infof("PostgreSQL published event")
+	return nil
+}
+
+// storeEvent saves a payload too large for a notification, and returns the
+// notification referencing it.
+func (s *PostgresBrokerService) storeEvent(id string, payload string) (string, error) {
+	db := s.db.WithContext(s.context)
+	now := time.Now()
+	// Retried events are stored already
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&postgresEventRecord{
+		ID:        id,
+		Payload:   payload,
+		CreatedAt: now,
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	// Every instance fetched the stored events long before they expire
+	err = db.Where("created_at < ?", now.Add(-postgresEventRetention)).Delete(postgresEventRecord{}).Error
+	if err != nil {
+		s.handleError(err)
+	}
+	b, err := json.Marshal(postgresNotification{
+		Ref:      id,
+		ClientID: s.clientID,
+	})
+	return string(b), err
+}
+
+func (s *PostgresBrokerService) handleSubscriptionAsync(conn *pgx.Conn) {
+	go func() {
+		for {
+			err := s.handleSubscription(conn)
+			conn.Close(context.Background())
+			if s.context.Err() != nil {
+				return
+			}
+			s.handleError(err)
+			s.setHealth(BrokerStateReconnecting, err)
+			// Notifications sent while reconnecting are lost
+			conn = s.relisten()
+			if conn == nil {
+				return
+			}
+			log.Info("Reconnected to PostgreSQL event broker")
+			s.setHealth(BrokerStateConnected, nil)
+		}
+	}()
+}
+
+// relisten reconnects with an increasing delay until it succeeds, or returns
+// nil once the context is done.
+func (s *PostgresBrokerService) relisten() *pgx.Conn {
+	backoff := newBrokerBackoff()
+	for {
+		if !backoff.Wait(s.context) {
+			return nil
+		}
+		conn, err := s.listen()
+		if err == nil {
+			return conn
+		}
+		s.handleError(err)
+	}
+}
+
+func (s *PostgresBrokerService) handleSubscription(conn *pgx.Conn) error {
+	for {
+		notification, err := conn.WaitForNotification(s.context)
+		if err != nil {
+			return err
+		}
+		if notification.Channel != PostgresEventChannel {
+			continue
+		}
+		if err := s.handleSubscriptionMessage(notification.Payload); err != nil {
+			s.handleError(err)
+		}
+	}
+}
+
+func (s *PostgresBrokerService) handleSubscriptionMessage(payload string) error {
+	notification := postgresNotification{}
+	if err := json.Unmarshal([]byte(payload), &notification); err != nil {
+		return err
+	}
+	// Prevent pubsub self-loop
+	if notification.ClientID == s.clientID {
+		return nil
+	}
+	if notification.Ref != "" {
+		res, err := s.loadPayload(notification.Ref)
+		if err != nil {
+			return err
+		}
+		payload = res
+	}
+	event, err := events.DecodePubSubEvent(events.JSONCodec, []byte(payload))
+	if err != nil {
+		return s.reportRejected(err)
+	}
+	if !s.seen.Add(event.ID) {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"id":        event.ID,
+		"event":     event.Event,
+		"timestamp": event.Timestamp,
+	}).Infof("PostgreSQL received event")
+	s.reportReceived()
+	s.pub.Topic(events.EventSession).Publish(event.SessionEvent)
+	return nil
+}
+
+func (s *PostgresBrokerService) loadPayload(id string) (string, error) {
+	record := postgresEventRecord{}
+	err := s.db.WithContext(s.context).Where("id = ?", id).Take(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", errors.New("PostgreSQL event expired: " + id)
+	}
+	return record.Payload, err
+}
+
+func (s *PostgresBrokerService) handleError(err error) {
+	log.Error(err)
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"air-sync/models"
+	"air-sync/models/events"
+	"air-sync/util/pubsub"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresBrokerService(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("Requires POSTGRES_DSN env to test")
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newBroker := func(pub *pubsub.Publisher) *PostgresBrokerService {
+		broker := NewPostgresBrokerService(ctx, PostgresBrokerOptions{
+			Publisher: pub,
+			DSN:       dsn,
+		})
+		require.Nil(t, broker.Initialize())
+		return broker
+	}
+	receive := func(sub *pubsub.Subscriber) events.SessionEvent {
+		select {
+		case v := <-sub.Channel():
+			event, ok := v.(events.SessionEvent)
+			require.True(t, ok)
+			return event
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "Timed out waiting for the event")
+			return events.SessionEvent{}
+		}
+	}
+
+	sender := pubsub.NewPublisher()
+	defer newBroker(sender).Deinitialize()
+	receiver := pubsub.NewPublisher()
+	defer newBroker(receiver).Deinitialize()
+	sub := receiver.Topic(events.EventSession).Subscribe()
+	defer sub.Unsubscribe()
+
+	event := events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil)
+	sender.Topic(events.EventSession).Publish(event)
+	require.Equal(t, event.ID, receive(sub).ID)
+
+	// Payloads too large for a notification go through the events table
+	message := models.Message{ID: "message"}
+	message.Body = strings.Repeat("a", 10000)
+	large := events.CreateSessionEvent("session", events.EventMessageInserted, events.MessageInsert{
+		SessionID: "session",
+		Message:   message,
+	}, nil)
+	sender.Topic(events.EventSession).Publish(large)
+	received := receive(sub)
+	require.Equal(t, large.ID, received.ID)
+	value, ok := received.Value.(events.MessageInsert)
+	require.True(t, ok)
+	require.Equal(t, message.Body, value.Message.Body)
+}
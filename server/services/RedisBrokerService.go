@@ -2,10 +2,12 @@ package services
 
 import (
 	"air-sync/models/events"
+	"air-sync/util"
 	"air-sync/util/pubsub"
 	"context"
-	"encoding/json"
-	"sync"
+	"errors"
+	"net"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
@@ -13,38 +15,61 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
+const (
+	// A subscription receiving nothing for this long pings the server, and
+	// resubscribes when the ping isn't answered either
+	redisPingInterval = 15 * time.Second
+)
+
+var errRedisPingTimeout = errors.New("Redis subscription ping timed out")
+
 type RedisBrokerOptions struct {
 	Publisher *pubsub.Publisher
-	Addr      string
-	Password  string
+	Redis     RedisOptions
+	// Keys signs the published envelopes, and rejects the received ones not
+	// signed with them. Without keys, envelopes go unsigned.
+	Keys *events.Keyring
 }
 
 type RedisBrokerService struct {
+	brokerHealth
 	context context.Context
-	mu      sync.Mutex
+	cancel  context.CancelFunc
 
 	pub *pubsub.Publisher
 
-	client   *redis.Client
-	addr     string
-	password string
+	client redis.UniversalClient
+	redis  RedisOptions
 
-	clientID    string
-	lastEventID string
+	clientID string
+	keys     *events.Keyring
+	seen     *util.SeenSet
+	// envelopes holds the signed envelopes received, telling replays apart
+	// from events published again
+	envelopes *util.SeenSet
+	backoff   util.Backoff
 
 	initialized bool
 }
 
 var _ Initializer = (*RedisBrokerService)(nil)
+var _ HealthReporter = (*RedisBrokerService)(nil)
+var _ EventPublisher = (*RedisBrokerService)(nil)
 
 func NewRedisBrokerService(ctx context.Context, opts RedisBrokerOptions) *RedisBrokerService {
+	ctx, cancel := context.WithCancel(ctx)
 	return &RedisBrokerService{
-		context:     ctx,
-		pub:         opts.Publisher,
-		addr:        opts.Addr,
-		password:    opts.Password,
-		clientID:    uuid.NewV4().String(),
-		initialized: false,
+		brokerHealth: newBrokerHealth(EventServiceRedis),
+		context:      ctx,
+		cancel:       cancel,
+		pub:          opts.Publisher,
+		redis:        opts.Redis,
+		clientID:     uuid.NewV4().String(),
+		keys:         opts.Keys,
+		seen:         newSeenEvents(),
+		envelopes:    newSeenEvents(),
+		backoff:      newBrokerBackoff(),
+		initialized:  false,
 	}
 }
 
@@ -53,16 +78,18 @@ func (s *RedisBrokerService) Initialize() error {
 		return ErrAlreadyInitialized
 	}
 
-	log.Infof("Connecting to Redis: %s", s.addr)
-	client := redis.NewClient(&redis.Options{
-		Addr:     s.addr,
-		Password: s.password,
-	})
+	log.Infof("Connecting to Redis: %s", s.redis)
+	client, err := NewRedisClient(s.redis)
+	if err != nil {
+		return err
+	}
 	if err := client.Ping(s.context).Err(); err != nil {
+		client.Close()
 		return err
 	}
 	s.client = client
 	log.Info("Connected to Redis")
+	s.setHealth(BrokerStateConnected, nil)
 
 	s.handlePublishingAsync()
 	s.handleSubscriptionAsync()
@@ -77,6 +104,11 @@ func (s *RedisBrokerService) Deinitialize() {
 		log.Error(ErrNotInitialized)
 		return
 	}
+	s.cancel()
+	if err := s.client.Close(); err != nil {
+		log.Error(err)
+	}
+	s.setHealth(BrokerStateDisconnected, nil)
 	s.initialized = false
 }
 
@@ -90,21 +122,32 @@ func (s *RedisBrokerService) handlePublishing(v interface{}) error {
 	if !ok {
 		return nil
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if event.ID == s.lastEventID {
+	// Events received from Redis are published locally too
+	if !s.seen.Add(event.ID) {
 		return nil
 	}
-	s.lastEventID = event.ID
-	b, err := json.Marshal(events.PubSubSessionEvent{
+	return s.publish(event)
+}
+
+func (s *RedisBrokerService) PublishEvent(event events.SessionEvent) error {
+	if err := s.publish(event); err != nil {
+		return err
+	}
+	s.seen.Add(event.ID)
+	return nil
+}
+
+func (s *RedisBrokerService) publish(event events.SessionEvent) error {
+	b, err := events.EncodeSignedPubSubEvent(events.JSONCodec, s.keys, events.PubSubSessionEvent{
 		SessionEvent: event,
 		ClientID:     s.clientID,
 	})
 	if err != nil {
 		return err
 	}
-	res := s.client.Publish(s.context, events.EventSession, string(b))
-	if err := res.Err(); err != nil {
+	err = s.client.Publish(s.context, events.EventSession, string(b)).Err()
+	s.reportPublish(err)
+	if err != nil {
 		return err
 	}
 	log.WithFields(log.Fields{
@@ -115,33 +158,57 @@ func (s *RedisBrokerService) handlePublishing(v interface{}) error {
 	return nil
 }
 
+// handleSubscriptionAsync keeps a subscription open, resubscribing with an
+// increasing delay whenever it fails.
 func (s *RedisBrokerService) handleSubscriptionAsync() {
 	go func() {
-		err := s.handleSubscription()
-		if err != nil {
+		for {
+			err := s.handleSubscription()
+			if s.context.Err() != nil {
+				return
+			}
 			s.handleError(err)
+			s.setHealth(BrokerStateReconnecting, err)
+			// Events published while resubscribing are lost
+			if !s.backoff.Wait(s.context) {
+				return
+			}
 		}
 	}()
 }
 
 func (s *RedisBrokerService) handleSubscription() error {
 	ps := s.client.Subscribe(s.context, events.EventSession)
-	if err := ps.Ping(s.context); err != nil {
-		return err
-	}
+	defer ps.Close()
 	if _, err := ps.Receive(s.context); err != nil {
 		return err
 	}
-	ch := ps.Channel()
+	s.backoff.Reset()
+	s.setHealth(BrokerStateConnected, nil)
+	pinged := false
 	for {
-		select {
-		case msg := <-ch:
-			err := s.handleSubscriptionMessage(msg)
-			if err != nil {
+		v, err := ps.ReceiveTimeout(s.context, redisPingInterval)
+		if s.context.Err() != nil {
+			return nil
+		}
+		if isTimeout(err) {
+			if pinged {
+				return errRedisPingTimeout
+			}
+			if err := ps.Ping(s.context); err != nil {
 				return err
 			}
-		case <-s.context.Done():
-			return nil
+			pinged = true
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		pinged = false
+		if msg, ok := v.(*redis.Message); ok {
+			if err := s.handleSubscriptionMessage(msg); err != nil {
+				s.handleError(err)
+			}
 		}
 	}
 }
@@ -151,25 +218,28 @@ func (s *RedisBrokerService) handleSubscriptionMessage(msg *redis.Message) error
 		return nil
 	}
 	payload := []byte(msg.Payload)
-	event := events.PubSubSessionEvent{}
-	if err := json.Unmarshal(payload, &event); err != nil {
-		return err
+	// Envelopes older than the window of seen events could be replayed
+	event, err := events.DecodeVerifiedPubSubEvent(events.JSONCodec, s.keys, payload, s.seen.Window())
+	if err != nil {
+		return s.reportRejected(err)
 	}
 	// Prevent pubsub self-loop
 	if event.ClientID == s.clientID {
 		return nil
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if event.ID == s.lastEventID {
+	// Redis delivers each message once, so only a replay repeats an envelope
+	if s.keys != nil && !s.envelopes.Add(envelopeKey(event)) {
+		return s.reportRejected(ErrReplayedEvent)
+	}
+	if !s.seen.Add(event.ID) {
 		return nil
 	}
-	s.lastEventID = event.ID
 	log.WithFields(log.Fields{
 		"id":        event.ID,
 		"event":     event.Event,
 		"timestamp": event.Timestamp,
 	}).Infof("Redis received event")
+	s.reportReceived()
 	s.pub.Topic(events.EventSession).Publish(event.SessionEvent)
 	return nil
 }
@@ -177,3 +247,8 @@ func (s *RedisBrokerService) handleSubscriptionMessage(msg *redis.Message) error
 func (s *RedisBrokerService) handleError(err error) {
 	log.Error(err)
 }
+
+func isTimeout(err error) bool {
+	netErr := net.Error(nil)
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
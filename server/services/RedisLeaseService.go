@@ -0,0 +1,87 @@
+package services
+
+import (
+	repos "air-sync/repositories"
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisLeasePrefix = "airsync:lease:"
+
+// redisAcquireLease sets the holder of a lease unless another one has it.
+// Redis expires the key, so an expired lease is simply missing.
+var redisAcquireLease = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder == false or holder == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// RedisLeaseService hands out the leases through Redis, for deployments
+// where the instances share Redis rather than a database.
+type RedisLeaseService struct {
+	context context.Context
+	redis   RedisOptions
+	client  redis.UniversalClient
+
+	initialized bool
+}
+
+var _ Initializer = (*RedisLeaseService)(nil)
+var _ repos.LeaseRepository = (*RedisLeaseService)(nil)
+
+func NewRedisLeaseService(ctx context.Context, opts RedisOptions) *RedisLeaseService {
+	return &RedisLeaseService{
+		context:     ctx,
+		redis:       opts,
+		initialized: false,
+	}
+}
+
+func (s *RedisLeaseService) Initialize() error {
+	if s.initialized {
+		return ErrAlreadyInitialized
+	}
+	log.Infof("Connecting to Redis for leases: %s", s.redis)
+	client, err := NewRedisClient(s.redis)
+	if err != nil {
+		return err
+	}
+	if err := client.Ping(s.context).Err(); err != nil {
+		client.Close()
+		return err
+	}
+	s.client = client
+	s.initialized = true
+	return nil
+}
+
+func (s *RedisLeaseService) Deinitialize() {
+	if !s.initialized {
+		log.Error(ErrNotInitialized)
+		return
+	}
+	if err := s.client.Close(); err != nil {
+		log.Error(err)
+	}
+	s.initialized = false
+}
+
+func (s *RedisLeaseService) Acquire(ctx context.Context, name string, holder string, until time.Time) (bool, error) {
+	ttl := time.Until(until).Milliseconds()
+	if ttl <= 0 {
+		// Already expired, which Redis can't be told
+		ttl = 1
+	}
+	n, err := redisAcquireLease.Run(ctx, s.client, []string{redisLeasePrefix + name}, holder, ttl).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
@@ -0,0 +1,351 @@
+package services
+
+import (
+	"air-sync/models/events"
+	"air-sync/util"
+	"air-sync/util/pubsub"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	DefaultRedisStream       = "airsync:session_events"
+	DefaultRedisStreamMaxLen = 10000
+
+	redisStreamBlock = 5 * time.Second
+	redisStreamCount = 100
+	// The first ID of any stream, which reads a stream from the start
+	redisStreamStartID = "0-0"
+	// redisStreamClockSkew is how much later than signed an envelope may be
+	// added to the stream
+	redisStreamClockSkew = time.Minute
+)
+
+type RedisStreamsBrokerOptions struct {
+	Publisher *pubsub.Publisher
+	Redis     RedisOptions
+	Stream    string
+	// MaxLen caps the stream length, trimming the oldest events
+	MaxLen int64
+	// InstanceID names the instance across restarts. When set, the ID of the
+	// last processed event is stored so a restarted instance receives the
+	// events published while it was down.
+	InstanceID string
+	// Keys signs the added envelopes, and rejects the read ones not signed
+	// with them. Without keys, envelopes go unsigned.
+	Keys *events.Keyring
+}
+
+type RedisStreamsBrokerService struct {
+	brokerHealth
+	context context.Context
+	cancel  context.CancelFunc
+
+	pub *pubsub.Publisher
+
+	client     redis.UniversalClient
+	redis      RedisOptions
+	stream     string
+	maxLen     int64
+	instanceID string
+
+	clientID string
+	keys     *events.Keyring
+	seen     *util.SeenSet
+	// envelopes holds the signed envelopes read, telling replays apart from
+	// events published again
+	envelopes *util.SeenSet
+	// lastID is the ID of the last processed event, the reads resume after
+	lastID string
+
+	initialized bool
+}
+
+var _ Initializer = (*RedisStreamsBrokerService)(nil)
+var _ HealthReporter = (*RedisStreamsBrokerService)(nil)
+var _ EventPublisher = (*RedisStreamsBrokerService)(nil)
+
+func NewRedisStreamsBrokerService(ctx context.Context, opts RedisStreamsBrokerOptions) *RedisStreamsBrokerService {
+	ctx, cancel := context.WithCancel(ctx)
+	stream := opts.Stream
+	if stream == "" {
+		stream = DefaultRedisStream
+	}
+	maxLen := opts.MaxLen
+	if maxLen <= 0 {
+		maxLen = DefaultRedisStreamMaxLen
+	}
+	// The events of the previous run are read again on restart, which must
+	// still be recognized as our own
+	clientID := opts.InstanceID
+	if clientID == "" {
+		clientID = uuid.NewV4().String()
+	}
+	return &RedisStreamsBrokerService{
+		brokerHealth: newBrokerHealth(EventServiceRedisStreams),
+		context:      ctx,
+		cancel:       cancel,
+		pub:          opts.Publisher,
+		redis:        opts.Redis,
+		stream:       stream,
+		maxLen:       maxLen,
+		instanceID:   opts.InstanceID,
+		clientID:     clientID,
+		keys:         opts.Keys,
+		seen:         newSeenEvents(),
+		envelopes:    newSeenEvents(),
+		initialized:  false,
+	}
+}
+
+func (s *RedisStreamsBrokerService) Initialize() error {
+	if s.initialized {
+		return ErrAlreadyInitialized
+	}
+
+	log.Infof("Connecting to Redis: %s", s.redis)
+	client, err := NewRedisClient(s.redis)
+	if err != nil {
+		return err
+	}
+	if err := client.Ping(s.context).Err(); err != nil {
+		client.Close()
+		return err
+	}
+	s.client = client
+	log.Info("Connected to Redis")
+
+	// Resolving the position up front makes sure no event published after
+	// initializing is missed
+	lastID, err := s.loadCursor()
+	if err != nil {
+		client.Close()
+		return err
+	}
+	s.lastID = lastID
+	s.setHealth(BrokerStateConnected, nil)
+
+	s.handlePublishingAsync()
+	s.handleSubscriptionAsync()
+	log.Infof("Publishing and subscribing to Redis stream: %s", s.stream)
+
+	s.initialized = true
+	return nil
+}
+
+func (s *RedisStreamsBrokerService) Deinitialize() {
+	if !s.initialized {
+		log.Error(ErrNotInitialized)
+		return
+	}
+	s.cancel()
+	if err := s.client.Close(); err != nil {
+		log.Error(err)
+	}
+	s.setHealth(BrokerStateDisconnected, nil)
+	s.initialized = false
+}
+
+// RedisStreamCursorKey returns the key the ID of the last event processed by
+// the instance is stored at.
+func RedisStreamCursorKey(stream string, instanceID string) string {
+	return stream + ":cursor:" + instanceID
+}
+
+// loadCursor returns the ID to read the stream after, which is the stored
+// one when there is one, or the last one of the stream.
+func (s *RedisStreamsBrokerService) loadCursor() (string, error) {
+	if s.instanceID != "" {
+		id, err := s.client.Get(s.context, RedisStreamCursorKey(s.stream, s.instanceID)).Result()
+		if err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, redis.Nil) {
+			return "", err
+		}
+	}
+	// Reading after "$" would miss the events added between two reads
+	res, err := s.client.XRevRangeN(s.context, s.stream, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(res) == 0 {
+		return redisStreamStartID, nil
+	}
+	return res[0].ID, nil
+}
+
+func (s *RedisStreamsBrokerService) saveCursor() error {
+	if s.instanceID == "" {
+		return nil
+	}
+	return s.client.Set(s.context, RedisStreamCursorKey(s.stream, s.instanceID), s.lastID, 0).Err()
+}
+
+func (s *RedisStreamsBrokerService) handlePublishingAsync() {
+	s.pub.Topic(events.EventSession).Subscribe().
+		ForEachAsync(s.context, s.handlePublishing, s.handleError)
+}
+
+func (s *RedisStreamsBrokerService) handlePublishing(v interface{}) error {
+	event, ok := v.(events.SessionEvent)
+	if !ok {
+		return nil
+	}
+	// Events read from the stream are published locally too
+	if !s.seen.Add(event.ID) {
+		return nil
+	}
+	return s.publish(event)
+}
+
+func (s *RedisStreamsBrokerService) PublishEvent(event events.SessionEvent) error {
+	if err := s.publish(event); err != nil {
+		return err
+	}
+	s.seen.Add(event.ID)
+	return nil
+}
+
+func (s *RedisStreamsBrokerService) publish(event events.SessionEvent) error {
+	b, err := events.EncodeSignedPubSubEvent(events.JSONCodec, s.keys, events.PubSubSessionEvent{
+		SessionEvent: event,
+		ClientID:     s.clientID,
+	})
+	if err != nil {
+		return err
+	}
+	id, err := s.client.XAdd(s.context, &redis.XAddArgs{
+		Stream:       s.stream,
+		MaxLenApprox: s.maxLen,
+		Values: map[string]interface{}{
+			// The session lets the stream be filtered without decoding
+			"session_id": event.SessionID,
+			"event":      string(b),
+		},
+	}).Result()
+	s.reportPublish(err)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"id":        event.ID,
+		"stream_id": id,
+		"event":     event.Event,
+		"timestamp": event.Timestamp,
+	}).Infof("Redis stream published event")
+	return nil
+}
+
+// handleSubscriptionAsync keeps reading the stream, resuming after the last
+// processed event with an increasing delay whenever reading fails.
+func (s *RedisStreamsBrokerService) handleSubscriptionAsync() {
+	go func() {
+		backoff := newBrokerBackoff()
+		for {
+			err := s.handleSubscription(&backoff)
+			if s.context.Err() != nil {
+				return
+			}
+			s.handleError(err)
+			s.setHealth(BrokerStateReconnecting, err)
+			if !backoff.Wait(s.context) {
+				return
+			}
+		}
+	}()
+}
+
+func (s *RedisStreamsBrokerService) handleSubscription(backoff *util.Backoff) error {
+	for reading := false; ; reading = true {
+		res, err := s.client.XRead(s.context, &redis.XReadArgs{
+			Streams: []string{s.stream, s.lastID},
+			Count:   redisStreamCount,
+			Block:   redisStreamBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			// Nothing was added while blocking
+			res, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		if !reading {
+			backoff.Reset()
+			s.setHealth(BrokerStateConnected, nil)
+		}
+		for _, stream := range res {
+			for _, msg := range stream.Messages {
+				if err := s.handleSubscriptionMessage(msg); err != nil {
+					s.handleError(err)
+				}
+				s.lastID = msg.ID
+			}
+		}
+		if len(res) > 0 {
+			if err := s.saveCursor(); err != nil {
+				s.handleError(err)
+			}
+		}
+	}
+}
+
+func (s *RedisStreamsBrokerService) handleSubscriptionMessage(msg redis.XMessage) error {
+	payload, ok := msg.Values["event"].(string)
+	if !ok {
+		return errors.New("Redis stream message without event: " + msg.ID)
+	}
+	// Entries are read long after they were added when resuming, so the
+	// envelopes are checked against the time of the entry instead of now. An
+	// envelope added again, with a later ID, is rejected as stale.
+	maxAge := redisStreamClockSkew
+	if added, ok := redisStreamEntryTime(msg.ID); ok {
+		maxAge += time.Since(added)
+	}
+	event, err := events.DecodeVerifiedPubSubEvent(events.JSONCodec, s.keys, []byte(payload), maxAge)
+	if err != nil {
+		return s.reportRejected(err)
+	}
+	// Prevent pubsub self-loop
+	if event.ClientID == s.clientID {
+		return nil
+	}
+	// Each entry is read once, so only a replay repeats an envelope
+	if s.keys != nil && !s.envelopes.Add(envelopeKey(event)) {
+		return s.reportRejected(ErrReplayedEvent)
+	}
+	if !s.seen.Add(event.ID) {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"id":        event.ID,
+		"stream_id": msg.ID,
+		"event":     event.Event,
+		"timestamp": event.Timestamp,
+	}).Infof("Redis stream received event")
+	s.reportReceived()
+	s.pub.Topic(events.EventSession).Publish(event.SessionEvent)
+	return nil
+}
+
+// redisStreamEntryTime returns when the entry was added, from the
+// milliseconds part of its ID.
+func redisStreamEntryTime(id string) (time.Time, bool) {
+	ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+func (s *RedisStreamsBrokerService) handleError(err error) {
+	log.Error(err)
+}
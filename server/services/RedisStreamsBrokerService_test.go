@@ -0,0 +1,205 @@
+package services
+
+import (
+	"air-sync/models/events"
+	"air-sync/util/pubsub"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+// Runs against a local server such as the redis service of
+// docker/airsync/docker-compose.yml
+func TestRedisStreamsBrokerService(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("Requires REDIS_ADDR env to test")
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := fmt.Sprintf("airsync:test:%d", time.Now().UnixNano())
+
+	newBroker := func(pub *pubsub.Publisher, instanceID string) *RedisStreamsBrokerService {
+		broker := NewRedisStreamsBrokerService(ctx, RedisStreamsBrokerOptions{
+			Publisher:  pub,
+			Redis:      RedisOptions{Addrs: []string{addr}},
+			Stream:     stream,
+			MaxLen:     100,
+			InstanceID: instanceID,
+		})
+		require.Nil(t, broker.Initialize())
+		return broker
+	}
+	receive := func(sub *pubsub.Subscriber) events.SessionEvent {
+		select {
+		case v := <-sub.Channel():
+			event, ok := v.(events.SessionEvent)
+			require.True(t, ok)
+			return event
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "Timed out waiting for the event")
+			return events.SessionEvent{}
+		}
+	}
+
+	sender := pubsub.NewPublisher()
+	defer newBroker(sender, "").Deinitialize()
+	receiver := pubsub.NewPublisher()
+	broker := newBroker(receiver, "receiver")
+	sub := receiver.Topic(events.EventSession).Subscribe()
+	defer sub.Unsubscribe()
+
+	event := events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil)
+	sender.Topic(events.EventSession).Publish(event)
+	require.Equal(t, event.ID, receive(sub).ID)
+
+	// Events published while the instance is down are read on restart
+	broker.Deinitialize()
+	missed := events.CreateSessionEvent("session", events.EventSessionDeleted, events.SessionDelete("session"), nil)
+	sender.Topic(events.EventSession).Publish(missed)
+	time.Sleep(200 * time.Millisecond)
+	broker = newBroker(receiver, "receiver")
+	defer broker.Deinitialize()
+	// The received event can't be told apart from the local one
+	require.Equal(t, missed, receive(sub))
+
+	// Each event is read once
+	select {
+	case v := <-sub.Channel():
+		require.FailNow(t, "Received an event twice", "%v", v)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRedisStreamsBrokerServiceSigning(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("Requires REDIS_ADDR env to test")
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := fmt.Sprintf("airsync:test:%d", time.Now().UnixNano())
+
+	keys, err := events.ParseKeyring("current:secret")
+	require.Nil(t, err)
+	newBroker := func(pub *pubsub.Publisher) *RedisStreamsBrokerService {
+		broker := NewRedisStreamsBrokerService(ctx, RedisStreamsBrokerOptions{
+			Publisher: pub,
+			Redis:     RedisOptions{Addrs: []string{addr}},
+			Stream:    stream,
+			MaxLen:    100,
+			Keys:      keys,
+		})
+		require.Nil(t, broker.Initialize())
+		return broker
+	}
+	sender := pubsub.NewPublisher()
+	defer newBroker(sender).Deinitialize()
+	receiver := pubsub.NewPublisher()
+	broker := newBroker(receiver)
+	defer broker.Deinitialize()
+	sub := receiver.Topic(events.EventSession).Subscribe()
+	defer sub.Unsubscribe()
+	ch := sub.Channel()
+	receive := func() events.SessionEvent {
+		select {
+		case v := <-ch:
+			return v.(events.SessionEvent)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "Timed out waiting for the event")
+			return events.SessionEvent{}
+		}
+	}
+
+	// Anyone allowed to write to the stream may try to inject events
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+	add := func(id string, b []byte) {
+		if id == "" {
+			id = "*"
+		}
+		err := client.XAdd(ctx, &redis.XAddArgs{
+			Stream: stream,
+			ID:     id,
+			Values: map[string]interface{}{"session_id": "session", "event": string(b)},
+		}).Err()
+		require.Nil(t, err)
+	}
+	forged := events.PubSubSessionEvent{
+		SessionEvent: events.CreateSessionEvent("session", events.EventSessionDeleted, events.SessionDelete("session"), nil),
+	}
+	unsigned, err := events.EncodePubSubEvent(events.JSONCodec, forged)
+	require.Nil(t, err)
+	otherKeys, err := events.ParseKeyring("current:guessed")
+	require.Nil(t, err)
+	guessed, err := events.EncodeSignedPubSubEvent(events.JSONCodec, otherKeys, forged)
+	require.Nil(t, err)
+	add("", unsigned)
+	add("", guessed)
+
+	// Entries are read in order, after the forged ones
+	event := events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil)
+	sender.Topic(events.EventSession).Publish(event)
+	require.Equal(t, event, receive())
+	metrics := broker.Health().Metrics
+	require.Equal(t, int64(1), metrics.Received)
+	require.Equal(t, map[string]int64{
+		BrokerRejectUnsigned:         1,
+		BrokerRejectInvalidSignature: 1,
+	}, metrics.Rejected)
+
+	// A captured envelope added again is a replay, while the relay
+	// publishing an event again signs a new envelope
+	relayed := events.PubSubSessionEvent{
+		SessionEvent: events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil),
+		ClientID:     "relay",
+	}
+	signed, err := events.EncodeSignedPubSubEvent(events.JSONCodec, keys, relayed)
+	require.Nil(t, err)
+	// Envelopes are signed with millisecond timestamps
+	time.Sleep(2 * time.Millisecond)
+	resigned, err := events.EncodeSignedPubSubEvent(events.JSONCodec, keys, relayed)
+	require.Nil(t, err)
+	last := events.PubSubSessionEvent{
+		SessionEvent: events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil),
+		ClientID:     "relay",
+	}
+	after, err := events.EncodeSignedPubSubEvent(events.JSONCodec, keys, last)
+	require.Nil(t, err)
+	add("", signed)
+	add("", signed)
+	add("", resigned)
+	require.Equal(t, relayed.ID, receive().ID)
+	// The last event is read after the others
+	add("", after)
+	require.Equal(t, last.ID, receive().ID)
+	metrics = broker.Health().Metrics
+	require.Equal(t, int64(3), metrics.Received)
+	require.Equal(t, map[string]int64{
+		BrokerRejectUnsigned:         1,
+		BrokerRejectInvalidSignature: 1,
+		BrokerRejectReplayed:         1,
+	}, metrics.Rejected)
+
+	// An envelope added long after it was signed is stale, however recently
+	// it's read
+	late := events.PubSubSessionEvent{
+		SessionEvent: events.CreateSessionEvent("session", events.EventSessionCreated, nil, nil),
+		ClientID:     "relay",
+	}
+	stale, err := events.EncodeSignedPubSubEvent(events.JSONCodec, keys, late)
+	require.Nil(t, err)
+	addedAt := time.Now().Add(2*redisStreamClockSkew).UnixNano() / int64(time.Millisecond)
+	add(fmt.Sprintf("%d-0", addedAt), stale)
+	require.Eventually(t, func() bool {
+		return broker.Health().Metrics.Rejected[BrokerRejectStale] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(3), broker.Health().Metrics.Received)
+}
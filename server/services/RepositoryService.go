@@ -2,9 +2,55 @@ package services
 
 import (
 	repos "air-sync/repositories"
+	"fmt"
+	"time"
 )
 
+type DatabaseDriver string
+
+const (
+	DatabaseDriverMongo    DatabaseDriver = "mongo"
+	DatabaseDriverMemory   DatabaseDriver = "memory"
+	DatabaseDriverSqlite   DatabaseDriver = "sqlite"
+	DatabaseDriverPostgres DatabaseDriver = "postgres"
+)
+
+// ParseDatabaseDriver checks the driver is one of the known ones.
+func ParseDatabaseDriver(v string) (DatabaseDriver, error) {
+	switch driver := DatabaseDriver(v); driver {
+	case DatabaseDriverMongo, DatabaseDriverMemory, DatabaseDriverSqlite, DatabaseDriverPostgres:
+		return driver, nil
+	}
+	return "", fmt.Errorf("Unknown database driver: %q", v)
+}
+
 type RepositoryService interface {
 	SessionRepository() repos.SessionRepository
 	AttachmentRepository() repos.AttachmentRepository
+	OutboxRepository() repos.OutboxRepository
+	LeaseRepository() repos.LeaseRepository
+	CronRunRepository() repos.CronRunRepository
+	Migrator() *repos.Migrator
+}
+
+type queryTimeoutRepositoryService struct {
+	RepositoryService
+	timeout time.Duration
+}
+
+// WithQueryTimeout bounds each session and attachment query of the service
+// with the timeout, unless it's zero.
+func WithQueryTimeout(service RepositoryService, timeout time.Duration) RepositoryService {
+	if timeout <= 0 {
+		return service
+	}
+	return &queryTimeoutRepositoryService{service, timeout}
+}
+
+func (s *queryTimeoutRepositoryService) SessionRepository() repos.SessionRepository {
+	return repos.NewSessionTimeoutRepository(s.RepositoryService.SessionRepository(), s.timeout)
+}
+
+func (s *queryTimeoutRepositoryService) AttachmentRepository() repos.AttachmentRepository {
+	return repos.NewAttachmentTimeoutRepository(s.RepositoryService.AttachmentRepository(), s.timeout)
 }
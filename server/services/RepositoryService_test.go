@@ -0,0 +1,42 @@
+package services
+
+import (
+	repos "air-sync/repositories"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDatabaseDriver(t *testing.T) {
+	for _, driver := range []DatabaseDriver{DatabaseDriverMongo, DatabaseDriverMemory, DatabaseDriverSqlite, DatabaseDriverPostgres} {
+		res, err := ParseDatabaseDriver(string(driver))
+		require.Nil(t, err)
+		require.Equal(t, driver, res)
+	}
+	// Typos don't fall back to Mongo
+	for _, v := range []string{"", "postgress", "Mongo"} {
+		_, err := ParseDatabaseDriver(v)
+		require.NotNil(t, err, v)
+	}
+}
+
+func TestWithQueryTimeout(t *testing.T) {
+	ctx := context.Background()
+	service := NewMemoryRepositoryService()
+	require.Nil(t, service.Initialize())
+	defer service.Deinitialize()
+	require.Equal(t, service, WithQueryTimeout(service, 0))
+
+	bounded := WithQueryTimeout(service, time.Second)
+	require.IsType(t, &repos.SessionTimeoutRepository{}, bounded.SessionRepository())
+	require.IsType(t, &repos.AttachmentTimeoutRepository{}, bounded.AttachmentRepository())
+	// The queries which finish in time are answered as usual
+	session, err := bounded.SessionRepository().Create(ctx)
+	require.Nil(t, err)
+	found, err := service.SessionRepository().Find(ctx, session.ID)
+	require.Nil(t, err)
+	require.Equal(t, session.ID, found.ID)
+	require.Equal(t, service.Migrator(), bounded.Migrator())
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"air-sync/storages"
+	"context"
+	"errors"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const storageCleanupQueueSize = 1024
+
+// StorageCleanupService deletes stored objects in the background, so requests
+// removing attachments don't have to wait on the storage. Objects it can't
+// take are handed back for the caller to delete itself.
+type StorageCleanupService struct {
+	storage     storages.Storage
+	queue       chan string
+	queueSize   int
+	wg          sync.WaitGroup
+	mu          sync.RWMutex
+	initialized bool
+}
+
+var _ Initializer = (*StorageCleanupService)(nil)
+
+func NewStorageCleanupService(storage storages.Storage) *StorageCleanupService {
+	return &StorageCleanupService{
+		storage:     storage,
+		queueSize:   storageCleanupQueueSize,
+		initialized: false,
+	}
+}
+
+func (s *StorageCleanupService) Initialize() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.initialized {
+		return ErrAlreadyInitialized
+	}
+	s.queue = make(chan string, s.queueSize)
+	s.wg.Add(1)
+	go s.run()
+	s.initialized = true
+	return nil
+}
+
+// Deinitialize stops accepting objects and waits for the queued ones.
+func (s *StorageCleanupService) Deinitialize() {
+	s.mu.Lock()
+	if !s.initialized {
+		s.mu.Unlock()
+		log.Error(ErrNotInitialized)
+		return
+	}
+	s.initialized = false
+	close(s.queue)
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Queue schedules the objects for deletion without blocking. It returns the
+// objects which don't fit in the queue, or all of them once the service is
+// stopped, for the caller to Delete.
+func (s *StorageCleanupService) Queue(names ...string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	skipped := make([]string, 0)
+	for _, name := range names {
+		if !s.initialized {
+			skipped = append(skipped, name)
+			continue
+		}
+		select {
+		case s.queue <- name:
+		default:
+			skipped = append(skipped, name)
+		}
+	}
+	return skipped
+}
+
+// Delete deletes the object right away, ignoring objects already gone.
+func (s *StorageCleanupService) Delete(ctx context.Context, name string) error {
+	exists, err := s.storage.Exists(ctx, name)
+	if err != nil {
+		return err
+	} else if !exists {
+		return nil
+	}
+	err = s.storage.Delete(ctx, name)
+	if errors.Is(err, storages.ErrObjectNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (s *StorageCleanupService) run() {
+	defer s.wg.Done()
+	for name := range s.queue {
+		if err := s.Delete(context.Background(), name); err != nil {
+			log.WithField("object", name).Errorf("Storage cleanup: %v", err)
+		}
+	}
+}
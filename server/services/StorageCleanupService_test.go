@@ -0,0 +1,80 @@
+package services
+
+import (
+	"air-sync/storages"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// blockingStorage holds the cleanup worker on its first object until released.
+type blockingStorage struct {
+	*storages.MemoryStorage
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStorage) Exists(ctx context.Context, name string) (bool, error) {
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+	<-s.release
+	return s.MemoryStorage.Exists(ctx, name)
+}
+
+func TestStorageCleanupService(t *testing.T) {
+	ctx := context.Background()
+	storage := storages.NewMemoryStorage()
+	write := func(names ...string) {
+		for _, name := range names {
+			w, err := storage.Write(ctx, name)
+			require.Nil(t, err)
+			require.Nil(t, w.Close())
+		}
+	}
+	exists := func(name string) bool {
+		ok, err := storage.Exists(ctx, name)
+		require.Nil(t, err)
+		return ok
+	}
+
+	cleanup := NewStorageCleanupService(storage)
+	// Nothing is taken before the service starts
+	require.Equal(t, []string{"a"}, cleanup.Queue("a"))
+
+	require.Nil(t, cleanup.Initialize())
+	write("b", "c")
+	require.Empty(t, cleanup.Queue("b", "c"))
+	require.Eventually(t, func() bool {
+		return !exists("b") && !exists("c")
+	}, time.Second, 10*time.Millisecond)
+
+	cleanup.Deinitialize()
+	write("d")
+	require.Equal(t, []string{"d"}, cleanup.Queue("d"))
+	require.Nil(t, cleanup.Delete(ctx, "d"))
+	require.False(t, exists("d"))
+	// Objects already gone aren't an error
+	require.Nil(t, cleanup.Delete(ctx, "d"))
+}
+
+func TestStorageCleanupServiceFullQueue(t *testing.T) {
+	storage := &blockingStorage{
+		MemoryStorage: storages.NewMemoryStorage(),
+		started:       make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+	cleanup := NewStorageCleanupService(storage)
+	cleanup.queueSize = 2
+	require.Nil(t, cleanup.Initialize())
+
+	require.Empty(t, cleanup.Queue("a"))
+	<-storage.started
+	// The worker holds a, so b and c fill the queue
+	require.Equal(t, []string{"d", "e"}, cleanup.Queue("b", "c", "d", "e"))
+	close(storage.release)
+	cleanup.Deinitialize()
+}
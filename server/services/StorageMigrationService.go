@@ -0,0 +1,290 @@
+package services
+
+import (
+	"air-sync/models"
+	repos "air-sync/repositories"
+	"air-sync/storages"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type StorageMigrationOptions struct {
+	Source               storages.Storage
+	Destination          storages.Storage
+	AttachmentRepository repos.AttachmentRepository
+	Workers              int
+	CheckpointFile       string
+	// Route names the source and destination. It heads the checkpoint file
+	// so the file isn't resumed by a migration between other storages.
+	Route  string
+	Verify bool
+}
+
+type StorageMigrationReport struct {
+	Total    int
+	Copied   int
+	Skipped  int
+	Missing  []string
+	Orphaned []string
+	Failed   map[string]error
+}
+
+type StorageMigrationService struct {
+	source         storages.Storage
+	destination    storages.Storage
+	attachmentRepo repos.AttachmentRepository
+	workers        int
+	checkpointFile string
+	route          string
+	verify         bool
+
+	checkpoint *os.File
+	completed  map[string]bool
+	report     StorageMigrationReport
+	mu         sync.Mutex
+}
+
+var (
+	ErrChecksumMismatch   = errors.New("Checksum mismatch")
+	ErrCheckpointMismatch = errors.New("Checkpoint file belongs to another migration")
+)
+
+const checkpointHeaderPrefix = "# route: "
+
+func NewStorageMigrationService(opts StorageMigrationOptions) *StorageMigrationService {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	return &StorageMigrationService{
+		source:         opts.Source,
+		destination:    opts.Destination,
+		attachmentRepo: opts.AttachmentRepository,
+		workers:        workers,
+		checkpointFile: opts.CheckpointFile,
+		route:          opts.Route,
+		verify:         opts.Verify,
+	}
+}
+
+func (s *StorageMigrationService) Run(ctx context.Context) (StorageMigrationReport, error) {
+	s.report = StorageMigrationReport{
+		Missing:  make([]string, 0),
+		Orphaned: make([]string, 0),
+		Failed:   make(map[string]error),
+	}
+	if err := s.openCheckpoint(); err != nil {
+		return s.report, err
+	}
+	defer s.closeCheckpoint()
+
+	attachments, err := s.attachmentRepo.FindAll(ctx)
+	if err != nil {
+		return s.report, err
+	}
+	s.report.Total = len(attachments)
+	if err := s.findOrphans(ctx, attachments); err != nil {
+		return s.report, err
+	}
+
+	pending := make([]models.Attachment, 0, len(attachments))
+	for _, attachment := range attachments {
+		if s.completed[attachment.ID] {
+			s.report.Skipped++
+			continue
+		}
+		pending = append(pending, attachment)
+	}
+
+	ch := make(chan models.Attachment)
+	wg := sync.WaitGroup{}
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for attachment := range ch {
+				s.migrate(ctx, attachment)
+			}
+		}()
+	}
+	for _, attachment := range pending {
+		select {
+		case ch <- attachment:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(ch)
+	wg.Wait()
+	return s.report, ctx.Err()
+}
+
+func (s *StorageMigrationService) migrate(ctx context.Context, attachment models.Attachment) {
+	logger := log.WithField("attachment_id", attachment.ID)
+	exists, err := s.source.Exists(ctx, attachment.ID)
+	if err != nil {
+		s.fail(attachment.ID, err)
+		return
+	} else if !exists {
+		logger.Warn("Storage migration: object missing from source")
+		s.mu.Lock()
+		s.report.Missing = append(s.report.Missing, attachment.ID)
+		s.mu.Unlock()
+		return
+	}
+	if err := s.copy(ctx, attachment.ID); err != nil {
+		s.fail(attachment.ID, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.markCompleted(attachment.ID); err != nil {
+		s.report.Failed[attachment.ID] = err
+		return
+	}
+	s.report.Copied++
+	logger.Infof("Storage migration: copied object (%d/%d)", s.report.Copied+s.report.Skipped, s.report.Total)
+}
+
+func (s *StorageMigrationService) copy(ctx context.Context, name string) error {
+	r, err := s.source.Read(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := s.destination.Write(ctx, name)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), r); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if !s.verify {
+		return nil
+	}
+	sum, err := s.checksum(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum, hash.Sum(nil)) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+func (s *StorageMigrationService) checksum(ctx context.Context, name string) ([]byte, error) {
+	r, err := s.destination.Read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+func (s *StorageMigrationService) findOrphans(ctx context.Context, attachments []models.Attachment) error {
+	ids := make(map[string]bool, len(attachments))
+	for _, attachment := range attachments {
+		ids[attachment.ID] = true
+	}
+	return s.source.Iterate(ctx, func(name string) error {
+		if !ids[name] {
+			log.WithField("object", name).Warn("Storage migration: orphaned object in source")
+			s.report.Orphaned = append(s.report.Orphaned, name)
+		}
+		return nil
+	})
+}
+
+func (s *StorageMigrationService) fail(id string, err error) {
+	log.WithField("attachment_id", id).Errorf("Storage migration: %v", err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report.Failed[id] = err
+}
+
+// The checkpoint file lists the attachment IDs already migrated, one per line,
+// so an interrupted migration can resume where it left off. Its first line
+// names the route of the migration, which has to match to resume.
+func (s *StorageMigrationService) openCheckpoint() error {
+	s.completed = make(map[string]bool)
+	if s.checkpointFile == "" {
+		return nil
+	}
+	f, err := os.OpenFile(s.checkpointFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	header := checkpointHeaderPrefix + s.route
+	scanner := bufio.NewScanner(f)
+	lines := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lines++
+		if lines == 1 {
+			if line != header {
+				f.Close()
+				return fmt.Errorf("%w: %s, delete it to start over", ErrCheckpointMismatch, s.checkpointFile)
+			}
+			continue
+		}
+		if line != "" {
+			s.completed[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return err
+	}
+	if lines == 0 {
+		if _, err := fmt.Fprintln(f, header); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if len(s.completed) > 0 {
+		log.Infof("Storage migration: resuming from checkpoint with %d object(s) done", len(s.completed))
+	}
+	s.checkpoint = f
+	return nil
+}
+
+// markCompleted must be called with the lock held.
+func (s *StorageMigrationService) markCompleted(id string) error {
+	s.completed[id] = true
+	if s.checkpoint == nil {
+		return nil
+	}
+	_, err := fmt.Fprintln(s.checkpoint, id)
+	return err
+}
+
+func (s *StorageMigrationService) closeCheckpoint() {
+	if s.checkpoint == nil {
+		return
+	}
+	if err := s.checkpoint.Close(); err != nil {
+		log.Error(err)
+	}
+	s.checkpoint = nil
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"air-sync/models"
+	repos "air-sync/repositories"
+	"air-sync/storages"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// corruptStorage reads back other bytes than the ones written.
+type corruptStorage struct {
+	*storages.MemoryStorage
+}
+
+func (s *corruptStorage) Read(ctx context.Context, name string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader("corrupt")), nil
+}
+
+func TestStorageMigrationService(t *testing.T) {
+	ctx := context.Background()
+	attachmentRepo := repos.NewAttachmentMemoryRepository(repos.NewMemoryDatabase())
+	source := storages.NewMemoryStorage()
+	write := func(storage storages.Storage, name string) {
+		w, err := storage.Write(ctx, name)
+		require.Nil(t, err)
+		_, err = w.Write([]byte("object " + name))
+		require.Nil(t, err)
+		require.Nil(t, w.Close())
+	}
+	ids := make([]string, 0)
+	for i := 0; i < 20; i++ {
+		attachment, err := attachmentRepo.Create(ctx, models.NewCreateAttachment("file", "file", "text/plain"))
+		require.Nil(t, err)
+		ids = append(ids, attachment.ID)
+		write(source, attachment.ID)
+	}
+	missing, err := attachmentRepo.Create(ctx, models.NewCreateAttachment("gone", "file", "text/plain"))
+	require.Nil(t, err)
+	write(source, "orphan")
+
+	checkpoint := filepath.Join(t.TempDir(), "migrate.checkpoint")
+	migrate := func(destination storages.Storage, route string) (StorageMigrationReport, error) {
+		return NewStorageMigrationService(StorageMigrationOptions{
+			Source:               source,
+			Destination:          destination,
+			AttachmentRepository: attachmentRepo,
+			Workers:              4,
+			CheckpointFile:       checkpoint,
+			Route:                route,
+			Verify:               true,
+		}).Run(ctx)
+	}
+
+	// Checksums catch objects not stored as they were read
+	report, err := migrate(&corruptStorage{storages.NewMemoryStorage()}, "memory -> memory")
+	require.Nil(t, err)
+	require.Equal(t, 0, report.Copied)
+	require.Equal(t, len(ids), len(report.Failed))
+	for _, err := range report.Failed {
+		require.Equal(t, ErrChecksumMismatch, err)
+	}
+
+	destination := storages.NewMemoryStorage()
+	// Interrupted after a few objects, as recorded in the checkpoint
+	require.Nil(t, ioutil.WriteFile(checkpoint, []byte(
+		checkpointHeaderPrefix+"memory -> memory\n"+strings.Join(ids[:5], "\n")+"\n",
+	), 0644))
+	for _, id := range ids[:5] {
+		write(destination, id)
+	}
+	report, err = migrate(destination, "memory -> memory")
+	require.Nil(t, err)
+	require.Equal(t, len(ids)+1, report.Total)
+	require.Equal(t, 5, report.Skipped)
+	require.Equal(t, len(ids)-5, report.Copied)
+	require.Equal(t, []string{missing.ID}, report.Missing)
+	require.Equal(t, []string{"orphan"}, report.Orphaned)
+	require.Empty(t, report.Failed)
+	for _, id := range ids {
+		r, err := destination.Read(ctx, id)
+		require.Nil(t, err)
+		b, err := ioutil.ReadAll(r)
+		require.Nil(t, err)
+		require.True(t, bytes.Equal([]byte("object "+id), b))
+	}
+
+	// Everything is skipped when resumed again
+	report, err = migrate(destination, "memory -> memory")
+	require.Nil(t, err)
+	require.Equal(t, len(ids), report.Skipped)
+	require.Zero(t, report.Copied)
+
+	// Another migration doesn't take the checkpoint for its own
+	_, err = migrate(storages.NewMemoryStorage(), "memory -> other")
+	require.True(t, errors.Is(err, ErrCheckpointMismatch))
+}
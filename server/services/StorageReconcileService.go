@@ -0,0 +1,280 @@
+package services
+
+import (
+	"air-sync/models"
+	repos "air-sync/repositories"
+	"air-sync/storages"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	CronJobReconcileStorage = "reconcile-storage"
+
+	// DefaultStorageReconcileMinAge leaves the records of uploads still being
+	// written out of the missing objects
+	DefaultStorageReconcileMinAge = time.Hour
+)
+
+type StorageReconcileOptions struct {
+	Storage              storages.Storage
+	AttachmentRepository repos.AttachmentRepository
+	// MinAge is how old a record must be before its object counts as missing
+	MinAge time.Duration
+	// Repair deletes the orphaned objects and the records of missing ones no
+	// message references, and copies unsynced objects to the tiers lacking
+	// them
+	Repair bool
+}
+
+type StorageReconcileReport struct {
+	// Repair is set when the drift found was repaired
+	Repair  bool `json:"repair"`
+	Tiers   int  `json:"tiers"`
+	Objects int  `json:"objects"`
+	Records int  `json:"records"`
+	// Orphaned objects are stored without an attachment record
+	Orphaned []string `json:"orphaned"`
+	// Missing objects have an attachment record but are in no tier
+	Missing []string `json:"missing"`
+	// Unsynced objects are in a tier but missing from a later one
+	Unsynced []string `json:"unsynced"`
+	Repaired int      `json:"repaired"`
+	// Failed maps the objects which couldn't be repaired to the error
+	Failed map[string]string `json:"failed"`
+}
+
+var _ CronJobReport = (*StorageReconcileReport)(nil)
+
+func (r *StorageReconcileReport) Counts() CronJobCounts {
+	return CronJobCounts{
+		"objects":  int64(r.Objects),
+		"records":  int64(r.Records),
+		"orphaned": int64(len(r.Orphaned)),
+		"missing":  int64(len(r.Missing)),
+		"unsynced": int64(len(r.Unsynced)),
+		"repaired": int64(r.Repaired),
+		"failed":   int64(len(r.Failed)),
+	}
+}
+
+// StorageReconcileService compares every tier of the storage with the
+// attachment records, reporting and optionally repairing the drift left by
+// failed uploads and deletions.
+type StorageReconcileService struct {
+	storage        storages.Storage
+	attachmentRepo repos.AttachmentRepository
+	minAge         time.Duration
+	repair         bool
+}
+
+func NewStorageReconcileService(opts StorageReconcileOptions) *StorageReconcileService {
+	minAge := opts.MinAge
+	if minAge == 0 {
+		minAge = DefaultStorageReconcileMinAge
+	}
+	return &StorageReconcileService{
+		storage:        opts.Storage,
+		attachmentRepo: opts.AttachmentRepository,
+		minAge:         minAge,
+		repair:         opts.Repair,
+	}
+}
+
+func (s *StorageReconcileService) Run(ctx context.Context) (StorageReconcileReport, error) {
+	return s.run(ctx, s.repair)
+}
+
+func (s *StorageReconcileService) run(ctx context.Context, repair bool) (StorageReconcileReport, error) {
+	tiers := storages.Tiers(s.storage)
+	report := StorageReconcileReport{
+		Repair:   repair,
+		Tiers:    len(tiers),
+		Orphaned: make([]string, 0),
+		Missing:  make([]string, 0),
+		Unsynced: make([]string, 0),
+		Failed:   make(map[string]string),
+	}
+	// Objects are listed before the records: uploads create the record
+	// before writing the object, so no upload in progress looks orphaned
+	stored := make(map[string][]bool)
+	for idx, tier := range tiers {
+		err := tier.Iterate(ctx, func(name string) error {
+			if stored[name] == nil {
+				stored[name] = make([]bool, len(tiers))
+			}
+			stored[name][idx] = true
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+	report.Objects = len(stored)
+
+	attachments, err := s.attachmentRepo.FindAll(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Records = len(attachments)
+	deadline := models.FromTime(time.Now().Add(-s.minAge))
+	records := make(map[string]bool, len(attachments))
+	for _, attachment := range attachments {
+		records[attachment.ID] = true
+		if stored[attachment.ID] == nil && attachment.CreatedAt < deadline {
+			report.Missing = append(report.Missing, attachment.ID)
+		}
+	}
+	for name, present := range stored {
+		if !records[name] {
+			report.Orphaned = append(report.Orphaned, name)
+		} else if isUnsynced(present) {
+			report.Unsynced = append(report.Unsynced, name)
+		}
+	}
+	sort.Strings(report.Missing)
+	sort.Strings(report.Orphaned)
+	sort.Strings(report.Unsynced)
+	log.Infof(
+		"Storage reconciliation: %d orphaned, %d missing and %d unsynced object(s)",
+		len(report.Orphaned), len(report.Missing), len(report.Unsynced),
+	)
+	if repair {
+		s.repairAll(ctx, tiers, stored, &report)
+	}
+	return report, ctx.Err()
+}
+
+// RunJob runs the reconciliation as a cron job, reporting the drift found.
+func (s *StorageReconcileService) RunJob(ctx context.Context) (CronJobReport, error) {
+	return s.runJob(ctx, s.repair)
+}
+
+// DryRunJob reports the drift without repairing it.
+func (s *StorageReconcileService) DryRunJob(ctx context.Context) (CronJobReport, error) {
+	return s.runJob(ctx, false)
+}
+
+func (s *StorageReconcileService) runJob(ctx context.Context, repair bool) (CronJobReport, error) {
+	report, err := s.run(ctx, repair)
+	if err == nil && len(report.Failed) > 0 {
+		err = errors.New("Storage reconciliation failed to repair some objects")
+	}
+	return &report, err
+}
+
+// isUnsynced tells whether an object is missing from a tier after one having
+// it. Earlier tiers are caches, filled again when the object is read.
+func isUnsynced(tiers []bool) bool {
+	for idx := 1; idx < len(tiers); idx++ {
+		if tiers[idx-1] && !tiers[idx] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *StorageReconcileService) repairAll(ctx context.Context, tiers []storages.Storage, stored map[string][]bool, report *StorageReconcileReport) {
+	for _, name := range report.Orphaned {
+		if ctx.Err() != nil {
+			return
+		}
+		err := s.storage.Delete(ctx, name)
+		if err != nil && !errors.Is(err, storages.ErrObjectNotFound) {
+			s.fail(report, name, err)
+			continue
+		}
+		report.Repaired++
+	}
+	for _, name := range report.Unsynced {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.sync(ctx, tiers, stored[name], name); err != nil {
+			s.fail(report, name, err)
+			continue
+		}
+		report.Repaired++
+	}
+	if err := s.deleteMissing(ctx, report); err != nil {
+		log.Errorf("Storage reconciliation: %v", err)
+		for _, id := range report.Missing {
+			report.Failed[id] = err.Error()
+		}
+	}
+	log.Infof("Storage reconciliation: repaired %d object(s)", report.Repaired)
+}
+
+// deleteMissing deletes the records of missing objects, keeping the ones
+// messages still show, which have nothing left to repair them with.
+func (s *StorageReconcileService) deleteMissing(ctx context.Context, report *StorageReconcileReport) error {
+	if len(report.Missing) == 0 {
+		return nil
+	}
+	orphans, err := s.attachmentRepo.FindOrphansBefore(ctx, time.Now().Add(-s.minAge))
+	if err != nil {
+		return err
+	}
+	missing := make(map[string]bool, len(report.Missing))
+	for _, id := range report.Missing {
+		missing[id] = true
+	}
+	ids := make([]string, 0)
+	for _, attachment := range orphans {
+		if missing[attachment.ID] {
+			ids = append(ids, attachment.ID)
+		}
+	}
+	n, err := s.attachmentRepo.DeleteMany(ctx, ids)
+	if err != nil {
+		return err
+	}
+	report.Repaired += n
+	return nil
+}
+
+// sync copies the object from the first tier having it to the later tiers
+// lacking it, keeping the stored bytes as they are.
+func (s *StorageReconcileService) sync(ctx context.Context, tiers []storages.Storage, stored []bool, name string) error {
+	source := -1
+	for idx := range tiers {
+		if !stored[idx] {
+			if source < 0 {
+				continue
+			}
+			if err := copyObject(ctx, tiers[source], tiers[idx], name); err != nil {
+				return err
+			}
+		} else if source < 0 {
+			source = idx
+		}
+	}
+	return nil
+}
+
+func copyObject(ctx context.Context, source storages.Storage, destination storages.Storage, name string) error {
+	r, err := source.Read(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := destination.Write(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *StorageReconcileService) fail(report *StorageReconcileReport, name string, err error) {
+	log.WithField("object", name).Errorf("Storage reconciliation: %v", err)
+	report.Failed[name] = err.Error()
+}
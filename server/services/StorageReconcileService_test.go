@@ -0,0 +1,99 @@
+package services
+
+import (
+	"air-sync/models"
+	repos "air-sync/repositories"
+	"air-sync/storages"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageReconcileService(t *testing.T) {
+	ctx := context.Background()
+	db := repos.NewMemoryDatabase()
+	attachmentRepo := repos.NewAttachmentMemoryRepository(db)
+	sessionRepo := repos.NewSessionMemoryRepository(db)
+	cache := storages.NewMemoryStorage()
+	durable := storages.NewMemoryStorage()
+	storage := storages.NewCompressedStorage(storages.NewCacheStorage(cache, durable), "gzip")
+
+	write := func(storage storages.Storage, name string) {
+		w, err := storage.Write(ctx, name)
+		require.Nil(t, err)
+		_, err = w.Write([]byte(name))
+		require.Nil(t, err)
+		require.Nil(t, w.Close())
+	}
+	create := func() string {
+		attachment, err := attachmentRepo.Create(ctx, models.NewCreateAttachment("file", "file", "text/plain"))
+		require.Nil(t, err)
+		return attachment.ID
+	}
+	synced, cached, uncached, missing, shown := create(), create(), create(), create(), create()
+	write(storage, synced)
+	write(cache, cached)
+	write(durable, uncached)
+	write(cache, "orphan-cached")
+	write(durable, "orphan-durable")
+	session, err := sessionRepo.Create(ctx)
+	require.Nil(t, err)
+	_, err = sessionRepo.InsertMessage(ctx, session.ID, models.InsertMessage{
+		BaseMessage: models.BaseMessage{AttachmentID: shown},
+	})
+	require.Nil(t, err)
+
+	{
+		// Records this recent are taken for uploads in progress
+		report, err := NewStorageReconcileService(StorageReconcileOptions{
+			Storage:              storage,
+			AttachmentRepository: attachmentRepo,
+		}).Run(ctx)
+		require.Nil(t, err)
+		require.Equal(t, 2, report.Tiers)
+		require.Equal(t, 5, report.Objects)
+		require.Equal(t, 5, report.Records)
+		require.Equal(t, []string{"orphan-cached", "orphan-durable"}, report.Orphaned)
+		require.Equal(t, []string{}, report.Missing)
+		require.Equal(t, []string{cached}, report.Unsynced)
+	}
+	reconcile := NewStorageReconcileService(StorageReconcileOptions{
+		Storage:              storage,
+		AttachmentRepository: attachmentRepo,
+		MinAge:               -time.Second,
+		Repair:               true,
+	})
+	{
+		report, err := reconcile.DryRunJob(ctx)
+		require.Nil(t, err)
+		require.Equal(t, int64(0), report.Counts()["repaired"])
+		require.False(t, report.(*StorageReconcileReport).Repair)
+		report, err = reconcile.RunJob(ctx)
+		require.Nil(t, err)
+		// The job reports the objects along with their counts
+		require.Equal(t, []string{cached}, report.(*StorageReconcileReport).Unsynced)
+		require.Empty(t, report.(*StorageReconcileReport).Failed)
+		counts := report.Counts()
+		require.Equal(t, int64(2), counts["orphaned"])
+		require.Equal(t, int64(2), counts["missing"])
+		require.Equal(t, int64(1), counts["unsynced"])
+		require.Equal(t, int64(4), counts["repaired"])
+		require.Equal(t, int64(0), counts["failed"])
+	}
+	{
+		report, err := reconcile.Run(ctx)
+		require.Nil(t, err)
+		require.Equal(t, []string{}, report.Orphaned)
+		require.Equal(t, []string{}, report.Unsynced)
+		// Messages still show the attachment, so its record is kept
+		require.Equal(t, []string{shown}, report.Missing)
+		require.Equal(t, 0, report.Repaired)
+	}
+	names, err := storages.List(ctx, durable)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{synced, cached, uncached}, names)
+	_, err = attachmentRepo.Find(ctx, missing)
+	require.Equal(t, repos.ErrAttachmentNotFound, err)
+}
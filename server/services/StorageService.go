@@ -11,12 +11,14 @@ const (
 	StorageModeLocal        StorageMode = "local"
 	StorageModeCloudStorage StorageMode = "cloud_storage"
 	StorageModeCache        StorageMode = "cache"
+	StorageModeMemory       StorageMode = "memory"
 )
 
 type StorageOptions struct {
 	StorageMode StorageMode
 	BucketName  string
 	UploadsDir  string
+	Compression string
 }
 
 type StorageService struct {
@@ -38,9 +40,14 @@ func NewStorageService(ctx context.Context, opts StorageOptions) *StorageService
 		)
 	case StorageModeCloudStorage:
 		service.storage = cloudStorage
+	case StorageModeMemory:
+		service.storage = storages.NewMemoryStorage()
 	default:
 		service.storage = fileStorage
 	}
+	if opts.Compression != "" {
+		service.storage = storages.NewCompressedStorage(service.storage, opts.Compression)
+	}
 
 	return service
 }
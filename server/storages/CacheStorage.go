@@ -1,6 +1,7 @@
 package storages
 
 import (
+	"context"
 	"errors"
 	"io"
 )
@@ -30,6 +31,11 @@ func NewCacheStorage(storages ...Storage) *CacheStorage {
 	return &CacheStorage{storages}
 }
 
+// Tiers returns the storages in the order they are read from.
+func (s *CacheStorage) Tiers() []Storage {
+	return append([]Storage{}, s.storages...)
+}
+
 func (s *CacheStorage) Initialize() error {
 	for _, storage := range s.storages {
 		init, ok := storage.(Initializer)
@@ -51,9 +57,9 @@ func (s *CacheStorage) Deinitialize() {
 	}
 }
 
-func (s *CacheStorage) Exists(name string) (bool, error) {
+func (s *CacheStorage) Exists(ctx context.Context, name string) (bool, error) {
 	for _, storage := range s.storages {
-		exists, err := storage.Exists(name)
+		exists, err := storage.Exists(ctx, name)
 		if err != nil {
 			return false, err
 		} else if exists {
@@ -63,23 +69,23 @@ func (s *CacheStorage) Exists(name string) (bool, error) {
 	return false, nil
 }
 
-func (s *CacheStorage) Read(name string) (io.ReadCloser, error) {
+func (s *CacheStorage) Read(ctx context.Context, name string) (io.ReadCloser, error) {
 	rwc := &CacheReadWriteCloser{}
 	writers := make([]io.WriteCloser, 0)
 	for _, storage := range s.storages {
-		exists, err := storage.Exists(name)
+		exists, err := storage.Exists(ctx, name)
 		if err != nil {
 			return nil, err
 		} else if !exists {
 			// If not exists, then add it as one of the writers to write during reads
-			w, err := storage.Write(name)
+			w, err := storage.Write(ctx, name)
 			if err != nil {
 				return nil, err
 			}
 			writers = append(writers, w)
 		} else if rwc.ReadCloser == nil {
 			// If exists but no reader is assigned, then use it as the main source for reads
-			r, err := storage.Read(name)
+			r, err := storage.Read(ctx, name)
 			if err != nil {
 				return nil, err
 			}
@@ -93,10 +99,10 @@ func (s *CacheStorage) Read(name string) (io.ReadCloser, error) {
 	return rwc, nil
 }
 
-func (s *CacheStorage) Write(name string) (io.WriteCloser, error) {
+func (s *CacheStorage) Write(ctx context.Context, name string) (io.WriteCloser, error) {
 	writers := make([]io.WriteCloser, len(s.storages))
 	for idx, storage := range s.storages {
-		w, err := storage.Write(name)
+		w, err := storage.Write(ctx, name)
 		if err != nil {
 			return nil, err
 		}
@@ -105,15 +111,45 @@ func (s *CacheStorage) Write(name string) (io.WriteCloser, error) {
 	return NewCacheWriteCloser(writers...), nil
 }
 
-func (s *CacheStorage) Delete(name string) error {
+func (s *CacheStorage) Delete(ctx context.Context, name string) error {
 	for _, storage := range s.storages {
-		exists, err := storage.Exists(name)
+		exists, err := storage.Exists(ctx, name)
 		if err != nil {
 			return err
 		} else if !exists {
 			continue
 		}
-		if err := storage.Delete(name); err != nil {
+		if err := storage.Delete(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Size returns the size of the object in the first tier having it.
+func (s *CacheStorage) Size(ctx context.Context, name string) (int64, error) {
+	for _, storage := range s.storages {
+		size, err := storage.Size(ctx, name)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue
+		}
+		return size, err
+	}
+	return 0, ErrObjectNotFound
+}
+
+// Iterate walks every tier, reporting objects present in several tiers once.
+func (s *CacheStorage) Iterate(ctx context.Context, fn IterateFunc) error {
+	seen := make(map[string]bool)
+	for _, storage := range s.storages {
+		err := storage.Iterate(ctx, func(name string) error {
+			if seen[name] {
+				return nil
+			}
+			seen[name] = true
+			return fn(name)
+		})
+		if err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,80 @@
+package storages
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+var ErrUnknownCodec = errors.New("Unknown compression codec")
+
+// Codec compresses objects on write and decompresses them on read. The codec
+// name doubles as the HTTP content coding of the compressed bytes.
+type Codec interface {
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+type GzipCodec struct{}
+
+type ZstdCodec struct{}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+var (
+	_ Codec = GzipCodec{}
+	_ Codec = ZstdCodec{}
+)
+
+func GetCodec(name string) (Codec, error) {
+	switch name {
+	case EncodingGzip:
+		return GzipCodec{}, nil
+	case EncodingZstd:
+		return ZstdCodec{}, nil
+	}
+	return nil, ErrUnknownCodec
+}
+
+func (GzipCodec) Name() string {
+	return EncodingGzip
+}
+
+func (GzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (GzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (ZstdCodec) Name() string {
+	return EncodingZstd
+}
+
+func (ZstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func (ZstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zstdReadCloser{dec}, nil
+}
+
+func (rc zstdReadCloser) Close() error {
+	rc.Decoder.Close()
+	return nil
+}
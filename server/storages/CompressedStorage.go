@@ -0,0 +1,159 @@
+package storages
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// EncodingStorage is a storage able to pick a content encoding for objects
+// based on their MIME type. The plain Storage methods keep working on the
+// stored bytes, so objects can be copied between backends untouched.
+type EncodingStorage interface {
+	Storage
+	Encoding(mime string) string
+}
+
+// CompressedStorage decorates a storage to compress compressible objects.
+// The chosen encoding has to be recorded by the caller next to the object,
+// and passed back to ReadDecoded to get the original bytes. Its plain Write
+// and Read don't compress: objects are only encoded through WriteEncoded.
+type CompressedStorage struct {
+	Storage
+	codecName string
+	codec     Codec
+}
+
+type encodedWriteCloser struct {
+	io.WriteCloser
+	inner io.WriteCloser
+}
+
+type decodedReadCloser struct {
+	io.ReadCloser
+	inner io.ReadCloser
+}
+
+var (
+	_ EncodingStorage    = (*CompressedStorage)(nil)
+	_ StorageInitializer = (*CompressedStorage)(nil)
+)
+
+var compressibleMimes = []string{
+	"text/",
+	"application/json",
+	"application/xml",
+	"application/javascript",
+	"application/x-ndjson",
+	"application/x-yaml",
+	"application/x-sh",
+	"image/svg+xml",
+}
+
+func NewCompressedStorage(storage Storage, codec string) *CompressedStorage {
+	return &CompressedStorage{
+		Storage:   storage,
+		codecName: codec,
+	}
+}
+
+func (s *CompressedStorage) Initialize() error {
+	codec, err := GetCodec(s.codecName)
+	if err != nil {
+		return err
+	}
+	s.codec = codec
+	if init, ok := s.Storage.(Initializer); ok {
+		return init.Initialize()
+	}
+	return nil
+}
+
+func (s *CompressedStorage) Deinitialize() {
+	if init, ok := s.Storage.(Initializer); ok {
+		init.Deinitialize()
+	}
+}
+
+func (s *CompressedStorage) Encoding(mime string) string {
+	if IsCompressibleMime(mime) {
+		return s.codec.Name()
+	}
+	return ""
+}
+
+func IsCompressibleMime(mime string) bool {
+	mime = strings.ToLower(strings.TrimSpace(strings.SplitN(mime, ";", 2)[0]))
+	for _, prefix := range compressibleMimes {
+		if strings.HasPrefix(mime, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentEncoding returns the encoding the storage wants for the MIME type,
+// or an empty string when objects should be stored as is.
+func ContentEncoding(storage Storage, mime string) string {
+	if v, ok := storage.(EncodingStorage); ok {
+		return v.Encoding(mime)
+	}
+	return ""
+}
+
+// WriteEncoded opens a writer which encodes the written bytes before storing.
+func WriteEncoded(ctx context.Context, storage Storage, name string, encoding string) (io.WriteCloser, error) {
+	if encoding == "" {
+		return storage.Write(ctx, name)
+	}
+	codec, err := GetCodec(encoding)
+	if err != nil {
+		return nil, err
+	}
+	w, err := storage.Write(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	cw, err := codec.NewWriter(w)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &encodedWriteCloser{cw, w}, nil
+}
+
+// ReadDecoded opens a reader returning the original bytes of an object stored
+// with the given encoding. It does not depend on the storage being decorated,
+// so objects stay readable after compression is turned off.
+func ReadDecoded(ctx context.Context, storage Storage, name string, encoding string) (io.ReadCloser, error) {
+	if encoding == "" {
+		return storage.Read(ctx, name)
+	}
+	codec, err := GetCodec(encoding)
+	if err != nil {
+		return nil, err
+	}
+	r, err := storage.Read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	cr, err := codec.NewReader(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &decodedReadCloser{cr, r}, nil
+}
+
+func (wc *encodedWriteCloser) Close() error {
+	if err := wc.WriteCloser.Close(); err != nil {
+		wc.inner.Close()
+		return err
+	}
+	return wc.inner.Close()
+}
+
+func (rc *decodedReadCloser) Close() error {
+	rc.ReadCloser.Close()
+	return rc.inner.Close()
+}
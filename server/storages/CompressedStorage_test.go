@@ -0,0 +1,78 @@
+package storages
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressedStorage(t *testing.T) {
+	ctx := context.Background()
+	content := []byte(strings.Repeat("Hello, world!\n", 100))
+	write := func(storage Storage, name string, encoding string) {
+		w, err := WriteEncoded(ctx, storage, name, encoding)
+		require.Nil(t, err)
+		_, err = w.Write(content)
+		require.Nil(t, err)
+		require.Nil(t, w.Close())
+	}
+	read := func(storage Storage, name string, encoding string) []byte {
+		r, err := ReadDecoded(ctx, storage, name, encoding)
+		require.Nil(t, err)
+		defer r.Close()
+		b, err := ioutil.ReadAll(r)
+		require.Nil(t, err)
+		return b
+	}
+
+	for _, codec := range []string{EncodingGzip, EncodingZstd} {
+		memory := NewMemoryStorage()
+		storage := NewCompressedStorage(memory, codec)
+		require.Nil(t, storage.Initialize())
+
+		encoding := ContentEncoding(storage, "text/plain; charset=utf-8")
+		require.Equal(t, codec, encoding)
+		write(storage, "text", encoding)
+		require.Equal(t, content, read(storage, "text", encoding), codec)
+		// The stored bytes are the compressed ones
+		stored := read(memory, "text", "")
+		require.Less(t, len(stored), len(content), codec)
+		require.False(t, bytes.Equal(content, stored), codec)
+
+		// Incompressible objects are stored as they are
+		encoding = ContentEncoding(storage, "image/png")
+		require.Equal(t, "", encoding)
+		write(storage, "image", encoding)
+		require.Equal(t, content, read(memory, "image", ""), codec)
+		require.Equal(t, content, read(storage, "image", encoding), codec)
+		storage.Deinitialize()
+	}
+
+	// Plain storages never pick an encoding
+	require.Equal(t, "", ContentEncoding(NewMemoryStorage(), "text/plain"))
+	_, err := WriteEncoded(ctx, NewMemoryStorage(), "text", "brotli")
+	require.Equal(t, ErrUnknownCodec, err)
+	require.NotNil(t, NewCompressedStorage(NewMemoryStorage(), "brotli").Initialize())
+}
+
+func TestIsCompressibleMime(t *testing.T) {
+	for mime, compressible := range map[string]bool{
+		"text/plain":                      true,
+		"text/html; charset=utf-8":        true,
+		" Application/JSON ":              true,
+		"application/json; charset=utf-8": true,
+		"application/xml":                 true,
+		"image/svg+xml":                   true,
+		"image/png":                       false,
+		"application/zip":                 false,
+		"application/octet-stream":        false,
+		"video/mp4":                       false,
+		"":                                false,
+	} {
+		require.Equal(t, compressible, IsCompressibleMime(mime), mime)
+	}
+}
@@ -1,7 +1,9 @@
 package storages
 
 import (
+	"context"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -45,7 +47,10 @@ func (s *FileStorage) Deinitialize() {
 	// Do nothing
 }
 
-func (s *FileStorage) Exists(name string) (bool, error) {
+func (s *FileStorage) Exists(ctx context.Context, name string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	_, err := os.Stat(s.getPath(name))
 	if os.IsNotExist(err) {
 		return false, nil
@@ -55,18 +60,59 @@ func (s *FileStorage) Exists(name string) (bool, error) {
 	return true, nil
 }
 
-func (s *FileStorage) Read(name string) (io.ReadCloser, error) {
+func (s *FileStorage) Read(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return os.Open(s.getPath(name))
 }
 
-func (s *FileStorage) Write(name string) (io.WriteCloser, error) {
+func (s *FileStorage) Write(ctx context.Context, name string) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return os.Create(s.getPath(name))
 }
 
-func (s *FileStorage) Delete(name string) error {
+func (s *FileStorage) Delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	return os.Remove(s.getPath(name))
 }
 
+func (s *FileStorage) Size(ctx context.Context, name string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(s.getPath(name))
+	if os.IsNotExist(err) {
+		return 0, ErrObjectNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *FileStorage) Iterate(ctx context.Context, fn IterateFunc) error {
+	files, err := ioutil.ReadDir(s.absDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if file.IsDir() {
+			continue
+		}
+		if err := fn(file.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *FileStorage) getPath(name string) string {
 	return filepath.Join(s.absDir, filepath.Clean(name))
 }
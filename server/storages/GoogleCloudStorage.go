@@ -6,6 +6,7 @@ import (
 	"io"
 
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
 
 	. "cloud.google.com/go/storage"
 )
@@ -39,8 +40,8 @@ func (s *GoogleCloudStorage) Deinitialize() {
 	// Do nothing
 }
 
-func (s *GoogleCloudStorage) Exists(name string) (bool, error) {
-	_, err := s.bucket.Object(name).Attrs(s.context)
+func (s *GoogleCloudStorage) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := s.bucket.Object(name).Attrs(ctx)
 	if errors.Is(err, ErrObjectNotExist) {
 		return false, nil
 	} else if err != nil {
@@ -49,14 +50,39 @@ func (s *GoogleCloudStorage) Exists(name string) (bool, error) {
 	return true, nil
 }
 
-func (s *GoogleCloudStorage) Read(name string) (io.ReadCloser, error) {
-	return s.bucket.Object(name).NewReader(s.context)
+func (s *GoogleCloudStorage) Read(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.bucket.Object(name).NewReader(ctx)
 }
 
-func (s *GoogleCloudStorage) Write(name string) (io.WriteCloser, error) {
-	return s.bucket.Object(name).NewWriter(s.context), nil
+func (s *GoogleCloudStorage) Write(ctx context.Context, name string) (io.WriteCloser, error) {
+	return s.bucket.Object(name).NewWriter(ctx), nil
 }
 
-func (s *GoogleCloudStorage) Delete(name string) error {
-	return s.bucket.Object(name).Delete(s.context)
+func (s *GoogleCloudStorage) Delete(ctx context.Context, name string) error {
+	return s.bucket.Object(name).Delete(ctx)
+}
+
+func (s *GoogleCloudStorage) Size(ctx context.Context, name string) (int64, error) {
+	attrs, err := s.bucket.Object(name).Attrs(ctx)
+	if errors.Is(err, ErrObjectNotExist) {
+		return 0, ErrObjectNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	return attrs.Size, nil
+}
+
+func (s *GoogleCloudStorage) Iterate(ctx context.Context, fn IterateFunc) error {
+	it := s.bucket.Objects(ctx, nil)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(attrs.Name); err != nil {
+			return err
+		}
+	}
 }
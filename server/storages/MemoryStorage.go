@@ -0,0 +1,131 @@
+package storages
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+type MemoryStorage struct {
+	objects map[string][]byte
+	mu      sync.RWMutex
+}
+
+type MemoryWriteCloser struct {
+	bytes.Buffer
+	storage *MemoryStorage
+	name    string
+	closed  bool
+}
+
+var (
+	_ Storage        = (*MemoryStorage)(nil)
+	_ io.WriteCloser = (*MemoryWriteCloser)(nil)
+)
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		objects: make(map[string][]byte),
+	}
+}
+
+func (s *MemoryStorage) Exists(ctx context.Context, name string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.objects[name]
+	return ok, nil
+}
+
+func (s *MemoryStorage) Read(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.objects[name]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	// Objects are never mutated in place, so readers can share the slice
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *MemoryStorage) Write(ctx context.Context, name string) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &MemoryWriteCloser{
+		storage: s,
+		name:    name,
+	}, nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[name]; !ok {
+		return ErrObjectNotFound
+	}
+	delete(s.objects, name)
+	return nil
+}
+
+func (s *MemoryStorage) Size(ctx context.Context, name string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.objects[name]
+	if !ok {
+		return 0, ErrObjectNotFound
+	}
+	return int64(len(b)), nil
+}
+
+func (s *MemoryStorage) Iterate(ctx context.Context, fn IterateFunc) error {
+	// Snapshot the names so the callback is free to modify the storage
+	s.mu.RLock()
+	names := make([]string, 0, len(s.objects))
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) put(name string, b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[name] = b
+}
+
+// Close commits the buffered object, making it visible to readers at once.
+func (wc *MemoryWriteCloser) Close() error {
+	if wc.closed {
+		return nil
+	}
+	wc.closed = true
+	b := make([]byte, wc.Len())
+	copy(b, wc.Bytes())
+	wc.storage.put(wc.name, b)
+	return nil
+}
@@ -1,12 +1,23 @@
 package storages
 
-import "io"
+import (
+	"context"
+	"io"
+	"sort"
+)
+
+// IterateFunc is called with the name of each stored object. Returning an
+// error stops the iteration and the error is passed back to the caller.
+type IterateFunc func(name string) error
 
 type Storage interface {
-	Exists(name string) (bool, error)
-	Read(name string) (io.ReadCloser, error)
-	Write(name string) (io.WriteCloser, error)
-	Delete(name string) error
+	Exists(ctx context.Context, name string) (bool, error)
+	Read(ctx context.Context, name string) (io.ReadCloser, error)
+	Write(ctx context.Context, name string) (io.WriteCloser, error)
+	Delete(ctx context.Context, name string) error
+	// Size returns how many bytes the object takes in the storage
+	Size(ctx context.Context, name string) (int64, error)
+	Iterate(ctx context.Context, fn IterateFunc) error
 }
 
 type Initializer interface {
@@ -18,3 +29,38 @@ type StorageInitializer interface {
 	Storage
 	Initializer
 }
+
+// TieredStorage keeps copies of its objects in several storages, the first
+// ones acting as caches of the last.
+type TieredStorage interface {
+	Storage
+	Tiers() []Storage
+}
+
+var _ TieredStorage = (*CacheStorage)(nil)
+
+// Tiers returns the storages actually holding the objects of the storage,
+// looking through the decorators. A storage without tiers is its own tier.
+func Tiers(storage Storage) []Storage {
+	if v, ok := storage.(*CompressedStorage); ok {
+		return Tiers(v.Storage)
+	}
+	if v, ok := storage.(TieredStorage); ok {
+		return v.Tiers()
+	}
+	return []Storage{storage}
+}
+
+// List returns the sorted names of every object in the storage.
+func List(ctx context.Context, storage Storage) ([]string, error) {
+	names := make([]string, 0)
+	err := storage.Iterate(ctx, func(name string) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
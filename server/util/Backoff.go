@@ -0,0 +1,41 @@
+package util
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponentially growing delays between retries. Half of
+// each delay is random, so instances failing together don't retry together.
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	attempt int
+}
+
+func (b *Backoff) Next() time.Duration {
+	d := b.Max
+	if b.attempt < 32 && b.Min<<b.attempt < b.Max {
+		d = b.Min << b.attempt
+	}
+	b.attempt++
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// Wait sleeps for the next delay, and reports false when ctx is done first.
+func (b *Backoff) Wait(ctx context.Context) bool {
+	timer := time.NewTimer(b.Next())
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
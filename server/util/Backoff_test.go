@@ -0,0 +1,29 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Min: time.Second, Max: 10 * time.Second}
+	for _, max := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		d := b.Next()
+		require.GreaterOrEqual(t, int64(d), int64(max*time.Second/2))
+		require.LessOrEqual(t, int64(d), int64(max*time.Second))
+	}
+	for i := 0; i < 100; i++ {
+		require.LessOrEqual(t, int64(b.Next()), int64(10*time.Second))
+	}
+	b.Reset()
+	require.LessOrEqual(t, int64(b.Next()), int64(time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.False(t, b.Wait(ctx))
+	b = Backoff{Min: time.Millisecond, Max: time.Millisecond}
+	require.True(t, b.Wait(context.Background()))
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -198,6 +199,32 @@ func WriteResponse(w http.ResponseWriter, req *http.Request, res *Response) {
 	}
 }
 
+// AcceptsEncoding reports whether the request's Accept-Encoding header allows
+// the given content coding.
+func AcceptsEncoding(req *http.Request, encoding string) bool {
+	wildcard := false
+	for _, value := range req.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			params := strings.Split(part, ";")
+			coding := strings.TrimSpace(params[0])
+			accepted := true
+			for _, param := range params[1:] {
+				param = strings.ReplaceAll(param, " ", "")
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(param[2:], 64)
+					accepted = err == nil && q > 0
+				}
+			}
+			if strings.EqualFold(coding, encoding) {
+				return accepted
+			} else if coding == "*" {
+				wildcard = accepted
+			}
+		}
+	}
+	return wildcard
+}
+
 func GetClientIP(req *http.Request) string {
 	host := req.Header.Get("X-Real-IP")
 	if host == "" {
@@ -27,3 +27,20 @@ func TestClientIP(t *testing.T) {
 		require.Equal(t, expectedIP, clientIP)
 	}
 }
+
+func TestAcceptsEncoding(t *testing.T) {
+	accepts := func(value string, encoding string) bool {
+		header := make(http.Header)
+		if value != "" {
+			header.Set("Accept-Encoding", value)
+		}
+		return AcceptsEncoding(&http.Request{Header: header}, encoding)
+	}
+	require.True(t, accepts("gzip, deflate, br", "gzip"))
+	require.True(t, accepts("deflate, GZIP;q=0.5", "gzip"))
+	require.True(t, accepts("*", "zstd"))
+	require.False(t, accepts("gzip, deflate, br", "zstd"))
+	require.True(t, accepts("*;q=0, gzip", "gzip"))
+	require.False(t, accepts("gzip;q=0", "gzip"))
+	require.False(t, accepts("", "gzip"))
+}
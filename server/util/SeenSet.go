@@ -0,0 +1,76 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// SeenSet remembers recently seen IDs. An ID is forgotten once it's older
+// than the TTL, or when more than size newer IDs were seen since.
+type SeenSet struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	seen  map[string]time.Time
+	order []seenID
+	now   func() time.Time
+}
+
+type seenID struct {
+	id string
+	at time.Time
+}
+
+func NewSeenSet(size int, ttl time.Duration) *SeenSet {
+	return &SeenSet{
+		size:  size,
+		ttl:   ttl,
+		seen:  make(map[string]time.Time),
+		order: make([]seenID, 0),
+		now:   time.Now,
+	}
+}
+
+// Add marks the ID as seen, and reports whether it wasn't seen already.
+func (s *SeenSet) Add(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.prune(now)
+	if _, ok := s.seen[id]; ok {
+		return false
+	}
+	s.seen[id] = now
+	s.order = append(s.order, seenID{id, now})
+	s.prune(now)
+	return true
+}
+
+// Window returns how long back every seen ID is remembered: the TTL, or less
+// once IDs are forgotten beyond the size.
+func (s *SeenSet) Window() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.prune(now)
+	if len(s.order) < s.size {
+		return s.ttl
+	}
+	return now.Sub(s.order[0].at)
+}
+
+func (s *SeenSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(s.now())
+	return len(s.order)
+}
+
+func (s *SeenSet) prune(now time.Time) {
+	n := 0
+	for n < len(s.order) && (len(s.order)-n > s.size || now.Sub(s.order[n].at) > s.ttl) {
+		delete(s.seen, s.order[n].id)
+		n++
+	}
+	s.order = s.order[n:]
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeenSet(t *testing.T) {
+	now := time.Unix(0, 0)
+	set := NewSeenSet(3, time.Minute)
+	set.now = func() time.Time {
+		return now
+	}
+	require.True(t, set.Add("a"))
+	require.False(t, set.Add("a"))
+
+	// Interleaved IDs are still recognized
+	require.True(t, set.Add("b"))
+	require.False(t, set.Add("a"))
+	require.False(t, set.Add("b"))
+
+	// The oldest IDs are forgotten beyond the size
+	require.True(t, set.Add("c"))
+	require.True(t, set.Add("d"))
+	require.Equal(t, 3, set.Len())
+	require.True(t, set.Add("a"))
+	require.False(t, set.Add("d"))
+
+	// And once they expire
+	now = now.Add(2 * time.Minute)
+	require.Zero(t, set.Len())
+	require.True(t, set.Add("d"))
+
+	for i := 0; i < 100; i++ {
+		set.Add(strconv.Itoa(i))
+	}
+	require.Equal(t, 3, set.Len())
+	require.Equal(t, 3, len(set.seen))
+}
+
+func TestSeenSetWindow(t *testing.T) {
+	now := time.Unix(0, 0)
+	set := NewSeenSet(2, time.Minute)
+	set.now = func() time.Time {
+		return now
+	}
+	require.Equal(t, time.Minute, set.Window())
+	set.Add("a")
+	now = now.Add(10 * time.Second)
+	set.Add("b")
+	now = now.Add(10 * time.Second)
+	// Full, so only as far back as the oldest ID
+	require.Equal(t, 20*time.Second, set.Window())
+	set.Add("c")
+	require.Equal(t, 10*time.Second, set.Window())
+	now = now.Add(2 * time.Minute)
+	require.Equal(t, time.Minute, set.Window())
+}
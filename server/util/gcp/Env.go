@@ -12,6 +12,11 @@ func EnvPubSubTopicID() string {
 	return os.Getenv("GOOGLE_PUBSUB_TOPIC_ID")
 }
 
-func EnvPubSubSubscriptionID() string {
+// EnvPubSubSubscriptionPrefix falls back to the subscription ID, which each
+// instance used to share.
+func EnvPubSubSubscriptionPrefix() string {
+	if prefix := os.Getenv("GOOGLE_PUBSUB_SUBSCRIPTION_PREFIX"); prefix != "" {
+		return prefix
+	}
 	return os.Getenv("GOOGLE_PUBSUB_SUBSCRIPTION_ID")
 }